@@ -0,0 +1,181 @@
+// signerd holds the signer keys and signs through a Unix socket, so the
+// network-facing server started with pblind -server -signerd does not need
+// them.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/blanu/pblind/signing"
+	"golang.org/x/crypto/ssh/terminal"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	println("signerd")
+
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "signerd v0.0.1\n\n")
+		_, _ = fmt.Fprintf(os.Stderr, "Usage:\n\tsignerd -socket [path] -allow [uid,uid,...]\n\n")
+		_, _ = fmt.Fprintf(os.Stderr, "Example:\n\tsignerd -socket signer/signerd.sock\n\n")
+		_, _ = fmt.Fprintf(os.Stderr, "Flags:\n\n")
+		flag.PrintDefaults()
+	}
+
+	socket := flag.String("socket", "signer/signerd.sock", "Unix socket to listen on")
+	allow := flag.String("allow", "", "User IDs allowed to connect, comma separated, the user running signerd if empty")
+	keys := flag.String("keys", "signer/keys", "Directory with the rotation state of the signer keys")
+	keyFile := flag.String("keyfile", "signer/signer.secret", "Secret key that starts rotation if -keys holds no state yet")
+	lifetime := flag.Duration("lifetime", 0, "Rotate the signer key after this long, 0 to rotate by hand")
+	maxIssuance := flag.Uint64("maxissuance", 0, "Rotate the signer key after this many signatures, 0 for no limit")
+	overlap := flag.Duration("overlap", 24*time.Hour, "How long a replaced signer key still verifies")
+	poolSize := flag.Int("pool", 16, "Commitments to precompute in the background, 0 to compute them per session")
+	mlock := flag.Bool("mlock", false, "Lock the signer keys in memory so they are not swapped out (Linux only, may need a raised RLIMIT_MEMLOCK)")
+
+	flag.Parse()
+
+	policy := signing.RotationPolicy{Lifetime: *lifetime, MaxIssuance: *maxIssuance, Overlap: *overlap}
+
+	allowed, allowError := parseUIDs(*allow)
+	if allowError != nil {
+		println("failed to parse -allow")
+		println(allowError.Error())
+		return
+	}
+
+	passphrase, passphraseError := readPassphrase()
+	if passphraseError != nil {
+		println("failed to read passphrase")
+		println(passphraseError.Error())
+		return
+	}
+
+	rotator, loadError := loadRotator(*keys, *keyFile, policy, passphrase)
+	if loadError != nil {
+		println("failed to load signer keys")
+		println(loadError.Error())
+		return
+	}
+
+	if *mlock {
+		if lockError := rotator.Lock(); lockError != nil {
+			println("failed to lock signer keys in memory")
+			println(lockError.Error())
+			return
+		}
+	}
+
+	backend := signing.NewLocalSigner(func() (signing.KeyBackend, error) {
+		sk, issueError := rotator.Issue()
+		if issueError != nil {
+			return nil, issueError
+		}
+
+		if saveError := rotator.Save(*keys, passphrase); saveError != nil {
+			println("failed to save signer keys")
+			println(saveError.Error())
+		}
+
+		return sk, nil
+	})
+	if *poolSize > 0 {
+		backend.Pool = signing.NewCommitmentPool(rotator.Active().Curve, *poolSize)
+		defer backend.Pool.Close()
+	}
+
+	server := &signing.SignerServer{Backend: backend}
+	if allowed != nil {
+		server.Authorize = func(peer signing.PeerCredentials) error {
+			if !allowed[peer.UID] {
+				println("refused peer with uid " + strconv.Itoa(peer.UID))
+				return signing.ErrorPeerNotAllowed
+			}
+			return nil
+		}
+	}
+
+	listener, listenError := signing.ListenSigner(*socket)
+	if listenError != nil {
+		println("failure to listen on socket")
+		println(listenError.Error())
+		return
+	}
+
+	// closing the listener removes the socket file
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupts
+		listener.Close()
+	}()
+
+	println("Listening on " + *socket)
+
+	server.Serve(listener)
+}
+
+// loadRotator reads the signer keys and their states, starting rotation
+// with keyFile on first use.
+func loadRotator(dir string, keyFile string, policy signing.RotationPolicy, passphrase []byte) (*signing.KeyRotator, error) {
+	passphraseFunc := func() ([]byte, error) { return passphrase, nil }
+
+	if _, statError := os.Stat(filepath.Join(dir, "rotation.json")); statError == nil {
+		return signing.LoadKeyRotator(dir, policy, passphraseFunc, nil)
+	}
+
+	sk, loadError := signing.LoadSecretKey(keyFile, passphraseFunc)
+	if loadError != nil {
+		return nil, loadError
+	}
+
+	rotator, rotatorError := signing.NewKeyRotator(policy, sk, nil)
+	if rotatorError != nil {
+		return nil, rotatorError
+	}
+
+	return rotator, rotator.Save(dir, passphrase)
+}
+
+// parseUIDs parses a comma separated list of user IDs, nil if empty.
+func parseUIDs(list string) (map[int]bool, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	uids := make(map[int]bool)
+	for _, field := range strings.Split(list, ",") {
+		uid, parseError := strconv.Atoi(strings.TrimSpace(field))
+		if parseError != nil {
+			return nil, parseError
+		}
+		uids[uid] = true
+	}
+
+	return uids, nil
+}
+
+// readPassphrase takes the secret key passphrase from PBLIND_PASSPHRASE or
+// prompts for it on the terminal.
+func readPassphrase() ([]byte, error) {
+	if passphrase := os.Getenv("PBLIND_PASSPHRASE"); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	stdin := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(stdin) {
+		return nil, errors.New("no terminal, set PBLIND_PASSPHRASE")
+	}
+
+	_, _ = fmt.Fprint(os.Stderr, "Passphrase: ")
+	passphrase, readError := terminal.ReadPassword(stdin)
+	_, _ = fmt.Fprintln(os.Stderr)
+
+	return passphrase, readError
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/blanu/pblind/signing"
+)
+
+// Token is a redeemable review token: a signature on a random serial.
+type Token struct {
+	Serial    []byte
+	Signature signing.Signature
+}
+
+type Review struct {
+	Item string
+	Text string
+}
+
+type reviewRequest struct {
+	Item  string
+	Text  string
+	Token Token
+}
+
+// Board accepts one anonymous review per token issued by the shop.
+type Board struct {
+	Shop       string
+	Key        *signing.PublicKey
+	Nullifiers signing.NullifierStore
+
+	mutex   sync.Mutex
+	reviews []Review
+}
+
+func NewBoard(shop string, key *signing.PublicKey) *Board {
+	return &Board{
+		Shop:       shop,
+		Key:        key,
+		Nullifiers: signing.NewMemoryNullifierStore(),
+	}
+}
+
+func (board *Board) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reviews", board.handleReviews)
+	return mux
+}
+
+func (board *Board) handleReviews(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, board.Reviews(r.URL.Query().Get("item")))
+		return
+	}
+
+	var request reviewRequest
+	if decodeError := json.NewDecoder(r.Body).Decode(&request); decodeError != nil {
+		http.Error(w, decodeError.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if submitError := board.Submit(request.Item, request.Text, request.Token); submitError != nil {
+		status := http.StatusForbidden
+		if submitError == signing.ErrorAlreadyRedeemed {
+			status = http.StatusConflict
+		}
+		http.Error(w, submitError.Error(), status)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (board *Board) Submit(item string, text string, token Token) error {
+	info, infoError := reviewInfo(board.Shop, item)
+	if infoError != nil {
+		return infoError
+	}
+
+	if token.Signature.P == nil || token.Signature.W == nil || token.Signature.O == nil || token.Signature.G == nil {
+		return signing.ErrorInvalidSignature
+	}
+
+	if !board.Key.Check(token.Signature, info, token.Serial) {
+		return signing.ErrorInvalidSignature
+	}
+
+	nullifier := signing.Nullifier(board.Key.Curve, info, token.Serial)
+	if redeemError := board.Nullifiers.Redeem(nullifier); redeemError != nil {
+		return redeemError
+	}
+
+	board.mutex.Lock()
+	board.reviews = append(board.reviews, Review{Item: item, Text: text})
+	board.mutex.Unlock()
+
+	return nil
+}
+
+func (board *Board) Reviews(item string) []Review {
+	board.mutex.Lock()
+	defer board.mutex.Unlock()
+
+	reviews := []Review{}
+	for _, review := range board.reviews {
+		if item == "" || review.Item == item {
+			reviews = append(reviews, review)
+		}
+	}
+
+	return reviews
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/blanu/pblind/signing"
+)
+
+var errorUnsupportedCurve = errors.New("unsupported curve")
+
+// Buyer talks to the shop and the review board on behalf of a customer.
+type Buyer struct {
+	Client *http.Client
+	Shop   string // shop base URL
+	Board  string // review board base URL
+
+	name string
+	key  *signing.PublicKey
+}
+
+func NewBuyer(shop string, board string) *Buyer {
+	return &Buyer{Client: http.DefaultClient, Shop: shop, Board: board}
+}
+
+func (buyer *Buyer) fetchKey() error {
+	if buyer.key != nil {
+		return nil
+	}
+
+	var response keyResponse
+	if getError := buyer.call(buyer.Shop+"/key", nil, &response); getError != nil {
+		return getError
+	}
+
+	if response.Curve != elliptic.P256().Params().Name {
+		return errorUnsupportedCurve
+	}
+
+	curve := elliptic.P256()
+	if response.X == nil || response.Y == nil || !curve.IsOnCurve(response.X, response.Y) {
+		return signing.ErrorPointNotOnCurve
+	}
+
+	buyer.name = response.Shop
+	buyer.key = &signing.PublicKey{Curve: curve, X: response.X, Y: response.Y}
+
+	return nil
+}
+
+// RequestToken obtains a review token for an item of a paid order. The shop
+// learns which item is reviewable but not the serial it signs.
+func (buyer *Buyer) RequestToken(order string, item string) (*Token, error) {
+	if keyError := buyer.fetchKey(); keyError != nil {
+		return nil, keyError
+	}
+
+	info, infoError := reviewInfo(buyer.name, item)
+	if infoError != nil {
+		return nil, infoError
+	}
+
+	serial := make([]byte, 32)
+	if _, randError := rand.Read(serial); randError != nil {
+		return nil, randError
+	}
+
+	requester, requestError := signing.CreateRequester(buyer.key, info, serial)
+	if requestError != nil {
+		return nil, requestError
+	}
+
+	var start startResponse
+	if startError := buyer.call(buyer.Shop+"/tokens/start", startRequest{Order: order, Item: item}, &start); startError != nil {
+		return nil, startError
+	}
+
+	if processError := requester.ProcessMessage1(start.Msg1); processError != nil {
+		return nil, processError
+	}
+
+	msg2, createError := requester.CreateMessage2()
+	if createError != nil {
+		return nil, createError
+	}
+
+	var finish finishResponse
+	if finishError := buyer.call(buyer.Shop+"/tokens/finish", finishRequest{Session: start.Session, Msg2: msg2}, &finish); finishError != nil {
+		return nil, finishError
+	}
+
+	if processError := requester.ProcessMessage3(finish.Msg3); processError != nil {
+		return nil, processError
+	}
+
+	sig, signError := requester.Signature()
+	if signError != nil {
+		return nil, signError
+	}
+
+	return &Token{Serial: serial, Signature: sig}, nil
+}
+
+func (buyer *Buyer) SubmitReview(item string, text string, token Token) error {
+	return buyer.call(buyer.Board+"/reviews", reviewRequest{Item: item, Text: text, Token: token}, nil)
+}
+
+func (buyer *Buyer) Reviews(item string) ([]Review, error) {
+	var reviews []Review
+	getError := buyer.call(buyer.Board+"/reviews?item="+url.QueryEscape(item), nil, &reviews)
+	return reviews, getError
+}
+
+// call GETs endpoint when request is nil and POSTs request as JSON otherwise.
+func (buyer *Buyer) call(endpoint string, request interface{}, response interface{}) error {
+	var httpResponse *http.Response
+	var httpError error
+
+	if request == nil {
+		httpResponse, httpError = buyer.Client.Get(endpoint)
+	} else {
+		body, encodeError := json.Marshal(request)
+		if encodeError != nil {
+			return encodeError
+		}
+		httpResponse, httpError = buyer.Client.Post(endpoint, "application/json", bytes.NewReader(body))
+	}
+
+	if httpError != nil {
+		return httpError
+	}
+	defer httpResponse.Body.Close()
+
+	if httpResponse.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(httpResponse.Body)
+		return fmt.Errorf("%s: %s", httpResponse.Status, bytes.TrimSpace(message))
+	}
+
+	if response == nil {
+		return nil
+	}
+
+	return json.NewDecoder(httpResponse.Body).Decode(response)
+}
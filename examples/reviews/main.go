@@ -0,0 +1,84 @@
+// Command reviews is an example anonymous product review system.
+//
+// A shop issues one partially blind review token per purchased item, using
+// the item as info. A review board accepts one review per token without
+// learning which order it came from.
+//
+//	reviews -serve -shopAddr localhost:8080 -boardAddr localhost:8081
+//	reviews -buy -order 1001 -item widget -review "works great"
+//	reviews -list -item widget
+//
+// The -serve mode preloads order 1001 containing widget and gadget.
+package main
+
+import (
+	"crypto/elliptic"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/blanu/pblind/signing"
+)
+
+func main() {
+	serve := flag.Bool("serve", false, "Run the shop and the review board")
+	buy := flag.Bool("buy", false, "Obtain a review token and post a review")
+	list := flag.Bool("list", false, "List reviews")
+	shopAddr := flag.String("shopAddr", "localhost:8080", "Shop address")
+	boardAddr := flag.String("boardAddr", "localhost:8081", "Review board address")
+	order := flag.String("order", "", "Order number of the purchase")
+	item := flag.String("item", "", "Item to review")
+	review := flag.String("review", "", "Review text")
+
+	flag.Parse()
+
+	if *serve {
+		sk, keyError := signing.NewSecretKey(elliptic.P256())
+		if keyError != nil {
+			fail(keyError)
+		}
+
+		shop := NewShop("example-shop", sk)
+		shop.Purchase("1001", "widget", "gadget")
+		board := NewBoard(shop.Name, sk.GetPublicKey())
+
+		go func() {
+			fail(http.ListenAndServe(*boardAddr, board.Handler()))
+		}()
+
+		println("Listening...")
+		fail(http.ListenAndServe(*shopAddr, shop.Handler()))
+	}
+
+	buyer := NewBuyer("http://"+*shopAddr, "http://"+*boardAddr)
+
+	if *buy {
+		token, tokenError := buyer.RequestToken(*order, *item)
+		if tokenError != nil {
+			fail(tokenError)
+		}
+
+		if submitError := buyer.SubmitReview(*item, *review, *token); submitError != nil {
+			fail(submitError)
+		}
+
+		println("Review posted.")
+	}
+
+	if *list {
+		reviews, listError := buyer.Reviews(*item)
+		if listError != nil {
+			fail(listError)
+		}
+
+		for _, review := range reviews {
+			fmt.Printf("%s: %s\n", review.Item, review.Text)
+		}
+	}
+}
+
+func fail(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/elliptic"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/blanu/pblind/signing"
+)
+
+func TestReviewFlow(t *testing.T) {
+	sk, err := signing.NewSecretKey(elliptic.P256())
+	if err != nil {
+		t.Fatal("failed to generate secret key:", err)
+	}
+
+	shop := NewShop("test-shop", sk)
+	shop.Purchase("1001", "widget")
+	board := NewBoard(shop.Name, sk.GetPublicKey())
+
+	shopServer := httptest.NewServer(shop.Handler())
+	defer shopServer.Close()
+
+	boardServer := httptest.NewServer(board.Handler())
+	defer boardServer.Close()
+
+	buyer := NewBuyer(shopServer.URL, boardServer.URL)
+
+	// items not in the order are refused by the policy
+
+	if _, err = buyer.RequestToken("1001", "gadget"); err == nil {
+		t.Error("token issued for item not purchased")
+	}
+
+	token, err := buyer.RequestToken("1001", "widget")
+	if err != nil {
+		t.Fatal("failed to obtain token:", err)
+	}
+
+	if _, err = buyer.RequestToken("1001", "widget"); err == nil {
+		t.Error("second token issued for the same purchase")
+	}
+
+	// a token is bound to its item
+
+	if err = buyer.SubmitReview("gadget", "not bought", *token); err == nil {
+		t.Error("token accepted for another item")
+	}
+
+	if err = buyer.SubmitReview("widget", "works great", *token); err != nil {
+		t.Fatal("failed to submit review:", err)
+	}
+
+	if err = buyer.SubmitReview("widget", "works great again", *token); err == nil {
+		t.Error("token redeemed twice")
+	}
+
+	reviews, err := buyer.Reviews("widget")
+	if err != nil {
+		t.Fatal("failed to list reviews:", err)
+	}
+
+	if len(reviews) != 1 || reviews[0].Text != "works great" {
+		t.Error("unexpected reviews:", reviews)
+	}
+}
+
+func TestShopSessions(t *testing.T) {
+	sk, err := signing.NewSecretKey(elliptic.P256())
+	if err != nil {
+		t.Fatal("failed to generate secret key:", err)
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	shop := NewShop("test-shop", sk)
+	shop.Now = func() time.Time { return now }
+	shop.Purchase("1001", "widget")
+
+	shopServer := httptest.NewServer(shop.Handler())
+	defer shopServer.Close()
+
+	buyer := NewBuyer(shopServer.URL, "")
+
+	// an abandoned session does not use up the token
+
+	var start startResponse
+	if err = buyer.call(shopServer.URL+"/tokens/start", startRequest{Order: "1001", Item: "widget"}, &start); err != nil {
+		t.Fatal("failed to start session:", err)
+	}
+
+	if _, err = buyer.RequestToken("1001", "widget"); err != nil {
+		t.Fatal("token refused after an abandoned session:", err)
+	}
+
+	// and it expires
+
+	shop.Purchase("1002", "widget")
+	if err = buyer.call(shopServer.URL+"/tokens/start", startRequest{Order: "1002", Item: "widget"}, &start); err != nil {
+		t.Fatal("failed to start session:", err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err = buyer.RequestToken("1002", "widget"); err != nil {
+		t.Fatal("failed to obtain token:", err)
+	}
+	if len(shop.sessions) != 0 {
+		t.Error("expired sessions kept:", len(shop.sessions))
+	}
+}
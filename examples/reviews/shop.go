@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/blanu/pblind/signing"
+)
+
+var errorNotPurchased = errors.New("order does not contain item")
+var errorAlreadyIssued = errors.New("review token already issued for order")
+
+const defaultSessionTimeout = 5 * time.Minute
+
+// Policy decides whether a review token for item may be issued for order.
+type Policy func(order string, item string) error
+
+type keyResponse struct {
+	Shop  string
+	Curve string
+	X, Y  *big.Int
+}
+
+type startRequest struct {
+	Order string
+	Item  string
+}
+
+type startResponse struct {
+	Session string
+	Msg1    signing.Message1
+}
+
+type finishRequest struct {
+	Session string
+	Msg2    signing.Message2
+}
+
+type finishResponse struct {
+	Msg3 signing.Message3
+}
+
+// Shop sells items and issues one blind review token per purchased item.
+type Shop struct {
+	Name    string
+	Key     *signing.SecretKey
+	Policy  Policy
+	Timeout time.Duration    // sessions expire after this long, 5 minutes if 0
+	Now     func() time.Time // time.Now if nil
+
+	mutex     sync.Mutex
+	purchases map[string]map[string]bool // order -> item -> token issued
+	sessions  map[string]*shopSession
+}
+
+type shopSession struct {
+	signer  *signing.StateSigner
+	order   string
+	item    string
+	started time.Time
+}
+
+func NewShop(name string, key *signing.SecretKey) *Shop {
+	shop := &Shop{
+		Name:      name,
+		Key:       key,
+		purchases: make(map[string]map[string]bool),
+		sessions:  make(map[string]*shopSession),
+	}
+	shop.Policy = shop.purchasedOnce
+	return shop
+}
+
+func (shop *Shop) now() time.Time {
+	if shop.Now != nil {
+		return shop.Now()
+	}
+	return time.Now()
+}
+
+func (shop *Shop) timeout() time.Duration {
+	if shop.Timeout > 0 {
+		return shop.Timeout
+	}
+	return defaultSessionTimeout
+}
+
+func (shop *Shop) Purchase(order string, items ...string) {
+	shop.mutex.Lock()
+	defer shop.mutex.Unlock()
+
+	if shop.purchases[order] == nil {
+		shop.purchases[order] = make(map[string]bool)
+	}
+
+	for _, item := range items {
+		shop.purchases[order][item] = false
+	}
+}
+
+// purchasedOnce is the default policy: the item must be in the order and no
+// token may have been issued for it yet. Tokens count as issued once the
+// shop has answered a session, so abandoned sessions do not use them up.
+func (shop *Shop) purchasedOnce(order string, item string) error {
+	shop.mutex.Lock()
+	defer shop.mutex.Unlock()
+
+	issued, ok := shop.purchases[order][item]
+	if !ok {
+		return errorNotPurchased
+	}
+
+	if issued {
+		return errorAlreadyIssued
+	}
+
+	return nil
+}
+
+// markIssued records the token for item of order as issued. It fails if a
+// concurrent session got there first. The mutex must be held.
+func (shop *Shop) markIssued(order string, item string) error {
+	items := shop.purchases[order]
+
+	issued, ok := items[item]
+	if !ok {
+		// not a purchase, the policy allowed it
+		return nil
+	}
+
+	if issued {
+		return errorAlreadyIssued
+	}
+
+	items[item] = true
+
+	return nil
+}
+
+// expire drops sessions that were not finished in time. The mutex must be
+// held.
+func (shop *Shop) expire(now time.Time) {
+	for id, session := range shop.sessions {
+		if now.Sub(session.started) > shop.timeout() {
+			session.signer.Destroy()
+			delete(shop.sessions, id)
+		}
+	}
+}
+
+func (shop *Shop) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/key", shop.handleKey)
+	mux.HandleFunc("/tokens/start", shop.handleStart)
+	mux.HandleFunc("/tokens/finish", shop.handleFinish)
+	return mux
+}
+
+func (shop *Shop) handleKey(w http.ResponseWriter, r *http.Request) {
+	pk := shop.Key.GetPublicKey()
+	writeJSON(w, keyResponse{Shop: shop.Name, Curve: pk.Curve.Params().Name, X: pk.X, Y: pk.Y})
+}
+
+func (shop *Shop) handleStart(w http.ResponseWriter, r *http.Request) {
+	var request startRequest
+	if decodeError := json.NewDecoder(r.Body).Decode(&request); decodeError != nil {
+		http.Error(w, decodeError.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if policyError := shop.Policy(request.Order, request.Item); policyError != nil {
+		http.Error(w, policyError.Error(), http.StatusForbidden)
+		return
+	}
+
+	info, infoError := reviewInfo(shop.Name, request.Item)
+	if infoError != nil {
+		http.Error(w, infoError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	signer, signerError := signing.CreateSigner(*shop.Key, info)
+	if signerError != nil {
+		http.Error(w, signerError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	msg1, messageError := signer.CreateMessage1()
+	if messageError != nil {
+		http.Error(w, messageError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	session := make([]byte, 16)
+	if _, randError := rand.Read(session); randError != nil {
+		http.Error(w, randError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	id := hex.EncodeToString(session)
+
+	shop.mutex.Lock()
+	now := shop.now()
+	shop.expire(now)
+	shop.sessions[id] = &shopSession{signer: signer, order: request.Order, item: request.Item, started: now}
+	shop.mutex.Unlock()
+
+	writeJSON(w, startResponse{Session: id, Msg1: msg1})
+}
+
+func (shop *Shop) handleFinish(w http.ResponseWriter, r *http.Request) {
+	var request finishRequest
+	if decodeError := json.NewDecoder(r.Body).Decode(&request); decodeError != nil {
+		http.Error(w, decodeError.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.Msg2.E == nil {
+		http.Error(w, "missing challenge", http.StatusBadRequest)
+		return
+	}
+
+	// sessions are single use
+
+	shop.mutex.Lock()
+	shop.expire(shop.now())
+	session := shop.sessions[request.Session]
+	delete(shop.sessions, request.Session)
+	shop.mutex.Unlock()
+
+	if session == nil {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	defer session.signer.Destroy()
+
+	if processError := session.signer.ProcessMessage2(request.Msg2); processError != nil {
+		http.Error(w, processError.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msg3, messageError := session.signer.CreateMessage3()
+	if messageError != nil {
+		http.Error(w, messageError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the token is only used up once it is actually handed out
+
+	shop.mutex.Lock()
+	issueError := shop.markIssued(session.order, session.item)
+	shop.mutex.Unlock()
+
+	if issueError != nil {
+		http.Error(w, issueError.Error(), http.StatusForbidden)
+		return
+	}
+
+	writeJSON(w, finishResponse{Msg3: msg3})
+}
+
+// reviewInfo is the structured info shared by the shop and the buyer.
+func reviewInfo(shop string, item string) (signing.Info, error) {
+	return signing.CompressInfoFields(elliptic.P256(),
+		signing.InfoField{Key: "purpose", Value: "review"},
+		signing.InfoField{Key: "shop", Value: shop},
+		signing.InfoField{Key: "item", Value: item},
+	)
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/rand"
+
+	"github.com/blanu/pblind/signing"
+)
+
+// Ballot is a vote blindly signed by the registrar. The nonce keeps equal
+// choices from producing equal messages.
+type Ballot struct {
+	Choice    string
+	Nonce     []byte
+	Signature signing.Signature
+}
+
+func (ballot Ballot) Message() []byte {
+	return signing.EncodeInfo(
+		signing.InfoField{Key: "choice", Value: ballot.Choice},
+		signing.InfoField{Key: "nonce", Value: string(ballot.Nonce)},
+	)
+}
+
+type Voter struct {
+	ID         string
+	Credential []byte
+}
+
+// Vote obtains the registrar's blind signature on a ballot for choice.
+func (voter Voter) Vote(registrar *Registrar, choice string) (*Ballot, error) {
+	ballot := Ballot{Choice: choice, Nonce: make([]byte, 16)}
+	if _, randError := rand.Read(ballot.Nonce); randError != nil {
+		return nil, randError
+	}
+
+	info, infoError := electionInfo(registrar.Election)
+	if infoError != nil {
+		return nil, infoError
+	}
+
+	requester, requestError := signing.CreateRequester(registrar.PublicKey(), info, ballot.Message())
+	if requestError != nil {
+		return nil, requestError
+	}
+
+	session, msg1, beginError := registrar.Begin(voter.ID, voter.Credential)
+	if beginError != nil {
+		return nil, beginError
+	}
+
+	if processError := requester.ProcessMessage1(msg1); processError != nil {
+		return nil, processError
+	}
+
+	msg2, createError := requester.CreateMessage2()
+	if createError != nil {
+		return nil, createError
+	}
+
+	msg3, finishError := registrar.Finish(session, msg2)
+	if finishError != nil {
+		return nil, finishError
+	}
+
+	if processError := requester.ProcessMessage3(msg3); processError != nil {
+		return nil, processError
+	}
+
+	if ballot.Signature, createError = requester.Signature(); createError != nil {
+		return nil, createError
+	}
+
+	return &ballot, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"math/big"
+	"sync"
+
+	"github.com/blanu/pblind/signing"
+)
+
+// BallotBox accepts registrar-signed ballots and rejects duplicates.
+type BallotBox struct {
+	Election   string
+	Key        *signing.PublicKey
+	Nullifiers signing.NullifierStore
+
+	mutex    sync.Mutex
+	accepted []Ballot
+}
+
+func NewBallotBox(election string, key *signing.PublicKey) *BallotBox {
+	return &BallotBox{
+		Election:   election,
+		Key:        key,
+		Nullifiers: signing.NewMemoryNullifierStore(),
+	}
+}
+
+func (box *BallotBox) Cast(ballot Ballot) error {
+	info, infoError := electionInfo(box.Election)
+	if infoError != nil {
+		return infoError
+	}
+
+	if !checkBallot(box.Key, info, ballot) {
+		return signing.ErrorInvalidSignature
+	}
+
+	if redeemError := box.Nullifiers.Redeem(signing.Nullifier(box.Key.Curve, info, ballot.Message())); redeemError != nil {
+		return redeemError
+	}
+
+	box.mutex.Lock()
+	box.accepted = append(box.accepted, ballot)
+	box.mutex.Unlock()
+
+	return nil
+}
+
+func (box *BallotBox) Accepted() []Ballot {
+	box.mutex.Lock()
+	defer box.mutex.Unlock()
+
+	return append([]Ballot{}, box.accepted...)
+}
+
+func checkBallot(pk *signing.PublicKey, info signing.Info, ballot Ballot) bool {
+	sig := ballot.Signature
+	if sig.P == nil || sig.W == nil || sig.O == nil || sig.G == nil {
+		return false
+	}
+
+	return pk.Check(sig, info, ballot.Message())
+}
+
+// Mixer stands in for an anonymizing channel: it batches ballots and
+// delivers them in random order, so arrival order at the ballot box does not
+// reveal which voter cast which ballot.
+type Mixer struct {
+	mutex   sync.Mutex
+	pending []Ballot
+}
+
+func (mixer *Mixer) Send(ballot Ballot) {
+	mixer.mutex.Lock()
+	defer mixer.mutex.Unlock()
+
+	mixer.pending = append(mixer.pending, ballot)
+}
+
+// Flush delivers the batch to box and returns the errors of rejected ballots.
+func (mixer *Mixer) Flush(box *BallotBox) ([]error, error) {
+	mixer.mutex.Lock()
+	batch := mixer.pending
+	mixer.pending = nil
+	mixer.mutex.Unlock()
+
+	for i := len(batch) - 1; i > 0; i-- {
+		j, randError := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if randError != nil {
+			return nil, randError
+		}
+		batch[i], batch[j.Int64()] = batch[j.Int64()], batch[i]
+	}
+
+	var rejected []error
+	for _, ballot := range batch {
+		if castError := box.Cast(ballot); castError != nil {
+			rejected = append(rejected, castError)
+		}
+	}
+
+	return rejected, nil
+}
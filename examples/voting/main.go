@@ -0,0 +1,70 @@
+// Command voting runs an example anonymous election in a single process.
+//
+// The registrar authenticates eligible voters and blindly signs one ballot
+// per voter with the election ID as info. Ballots reach the ballot box
+// through a mixer standing in for an anonymizing channel, and the tallier
+// publishes the accepted ballots with their signatures as JSON.
+package main
+
+import (
+	"crypto/elliptic"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/blanu/pblind/signing"
+)
+
+func main() {
+	election := flag.String("election", "poll-2026", "Election ID")
+	flag.Parse()
+
+	sk, keyError := signing.NewSecretKey(elliptic.P256())
+	if keyError != nil {
+		fail(keyError)
+	}
+
+	registrar := NewRegistrar(*election, sk)
+	box := NewBallotBox(*election, registrar.PublicKey())
+	mixer := &Mixer{}
+
+	choices := []string{"yes", "no", "yes", "yes", "abstain"}
+
+	for i, choice := range choices {
+		voter := Voter{ID: fmt.Sprintf("voter-%d", i), Credential: []byte(fmt.Sprintf("secret-%d", i))}
+		registrar.Enroll(voter.ID, voter.Credential)
+
+		ballot, voteError := voter.Vote(registrar, choice)
+		if voteError != nil {
+			fail(voteError)
+		}
+
+		mixer.Send(*ballot)
+	}
+
+	rejected, flushError := mixer.Flush(box)
+	if flushError != nil {
+		fail(flushError)
+	}
+
+	for _, err := range rejected {
+		println("rejected ballot:", err.Error())
+	}
+
+	tally := Count(box)
+	if verifyError := tally.Verify(registrar.PublicKey()); verifyError != nil {
+		fail(verifyError)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if encodeError := encoder.Encode(tally); encodeError != nil {
+		fail(encodeError)
+	}
+}
+
+func fail(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/blanu/pblind/signing"
+)
+
+var errorNotEligible = errors.New("voter is not eligible")
+var errorAlreadyRegistered = errors.New("voter already received a ballot signature")
+var errorUnknownSession = errors.New("unknown registration session")
+
+// Registrar authenticates eligible voters and blindly signs one ballot each.
+type Registrar struct {
+	Election string
+	Key      *signing.SecretKey
+
+	mutex    sync.Mutex
+	voters   map[string][]byte // voter -> credential
+	issued   map[string]bool
+	sessions map[string]*signing.StateSigner
+}
+
+func NewRegistrar(election string, key *signing.SecretKey) *Registrar {
+	return &Registrar{
+		Election: election,
+		Key:      key,
+		voters:   make(map[string][]byte),
+		issued:   make(map[string]bool),
+		sessions: make(map[string]*signing.StateSigner),
+	}
+}
+
+func (registrar *Registrar) PublicKey() *signing.PublicKey {
+	return registrar.Key.GetPublicKey()
+}
+
+// Enroll adds an eligible voter with the credential they authenticate with.
+func (registrar *Registrar) Enroll(voter string, credential []byte) {
+	registrar.mutex.Lock()
+	defer registrar.mutex.Unlock()
+
+	registrar.voters[voter] = append([]byte{}, credential...)
+}
+
+// Begin authenticates the voter and starts their single signing session.
+func (registrar *Registrar) Begin(voter string, credential []byte) (string, signing.Message1, error) {
+	registrar.mutex.Lock()
+	defer registrar.mutex.Unlock()
+
+	expected, ok := registrar.voters[voter]
+	if !ok || subtle.ConstantTimeCompare(expected, credential) != 1 {
+		return "", signing.Message1{}, errorNotEligible
+	}
+
+	if registrar.issued[voter] {
+		return "", signing.Message1{}, errorAlreadyRegistered
+	}
+
+	info, infoError := electionInfo(registrar.Election)
+	if infoError != nil {
+		return "", signing.Message1{}, infoError
+	}
+
+	signer, signerError := signing.CreateSigner(*registrar.Key, info)
+	if signerError != nil {
+		return "", signing.Message1{}, signerError
+	}
+
+	msg1, messageError := signer.CreateMessage1()
+	if messageError != nil {
+		return "", signing.Message1{}, messageError
+	}
+
+	session := make([]byte, 16)
+	if _, randError := rand.Read(session); randError != nil {
+		return "", signing.Message1{}, randError
+	}
+
+	id := hex.EncodeToString(session)
+
+	// the voter is marked before the signature is released, so an aborted
+	// session cannot be retried for a second ballot
+
+	registrar.issued[voter] = true
+	registrar.sessions[id] = signer
+
+	return id, msg1, nil
+}
+
+func (registrar *Registrar) Finish(session string, msg2 signing.Message2) (signing.Message3, error) {
+	registrar.mutex.Lock()
+	signer := registrar.sessions[session]
+	delete(registrar.sessions, session)
+	registrar.mutex.Unlock()
+
+	if signer == nil || msg2.E == nil {
+		return signing.Message3{}, errorUnknownSession
+	}
+
+	if processError := signer.ProcessMessage2(msg2); processError != nil {
+		return signing.Message3{}, processError
+	}
+
+	return signer.CreateMessage3()
+}
+
+// electionInfo is the info shared by every ballot of an election.
+func electionInfo(election string) (signing.Info, error) {
+	return signing.CompressInfoFields(elliptic.P256(),
+		signing.InfoField{Key: "purpose", Value: "ballot"},
+		signing.InfoField{Key: "election", Value: election},
+	)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/blanu/pblind/signing"
+)
+
+var errorTallyMismatch = errors.New("tally counts do not match ballots")
+var errorDuplicateBallot = errors.New("tally contains a duplicate ballot")
+
+// Tally is the published election result. It lists every accepted ballot
+// with its signature so anyone holding the registrar key can recount.
+type Tally struct {
+	Election string
+	Counts   map[string]int
+	Ballots  []Ballot
+}
+
+func Count(box *BallotBox) Tally {
+	tally := Tally{
+		Election: box.Election,
+		Counts:   make(map[string]int),
+		Ballots:  box.Accepted(),
+	}
+
+	// publish in a canonical order rather than the order of arrival
+
+	sort.Slice(tally.Ballots, func(i, j int) bool {
+		return string(tally.Ballots[i].Message()) < string(tally.Ballots[j].Message())
+	})
+
+	for _, ballot := range tally.Ballots {
+		tally.Counts[ballot.Choice]++
+	}
+
+	return tally
+}
+
+// Verify checks every ballot signature, that no ballot is counted twice and
+// that the counts match the ballots.
+func (tally Tally) Verify(pk *signing.PublicKey) error {
+	info, infoError := electionInfo(tally.Election)
+	if infoError != nil {
+		return infoError
+	}
+
+	nullifiers := signing.NewMemoryNullifierStore()
+	counts := make(map[string]int)
+
+	for _, ballot := range tally.Ballots {
+		if !checkBallot(pk, info, ballot) {
+			return signing.ErrorInvalidSignature
+		}
+
+		if nullifiers.Redeem(signing.Nullifier(pk.Curve, info, ballot.Message())) != nil {
+			return errorDuplicateBallot
+		}
+
+		counts[ballot.Choice]++
+	}
+
+	if len(counts) != len(tally.Counts) {
+		return errorTallyMismatch
+	}
+
+	for choice, count := range counts {
+		if tally.Counts[choice] != count {
+			return errorTallyMismatch
+		}
+	}
+
+	return nil
+}
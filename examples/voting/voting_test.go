@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/elliptic"
+	"encoding/json"
+	"testing"
+
+	"github.com/blanu/pblind/signing"
+)
+
+func TestElection(t *testing.T) {
+	sk, err := signing.NewSecretKey(elliptic.P256())
+	if err != nil {
+		t.Fatal("failed to generate secret key:", err)
+	}
+
+	registrar := NewRegistrar("test-election", sk)
+	box := NewBallotBox("test-election", registrar.PublicKey())
+	mixer := &Mixer{}
+
+	alice := Voter{ID: "alice", Credential: []byte("alice-secret")}
+	bob := Voter{ID: "bob", Credential: []byte("bob-secret")}
+	registrar.Enroll(alice.ID, alice.Credential)
+	registrar.Enroll(bob.ID, bob.Credential)
+
+	// ineligible voters and wrong credentials are refused
+
+	if _, err = (Voter{ID: "mallory", Credential: []byte("x")}).Vote(registrar, "yes"); err != errorNotEligible {
+		t.Error("ineligible voter got a ballot:", err)
+	}
+
+	if _, err = (Voter{ID: "alice", Credential: []byte("wrong")}).Vote(registrar, "yes"); err != errorNotEligible {
+		t.Error("wrong credential accepted:", err)
+	}
+
+	aliceBallot, err := alice.Vote(registrar, "yes")
+	if err != nil {
+		t.Fatal("failed to vote:", err)
+	}
+
+	if _, err = alice.Vote(registrar, "no"); err != errorAlreadyRegistered {
+		t.Error("voter got a second ballot:", err)
+	}
+
+	bobBallot, err := bob.Vote(registrar, "no")
+	if err != nil {
+		t.Fatal("failed to vote:", err)
+	}
+
+	// a ballot signed for another election is rejected
+
+	other := NewRegistrar("other-election", sk)
+	other.Enroll(alice.ID, alice.Credential)
+	foreign, err := alice.Vote(other, "no")
+	if err != nil {
+		t.Fatal("failed to vote:", err)
+	}
+
+	mixer.Send(*aliceBallot)
+	mixer.Send(*bobBallot)
+	mixer.Send(*aliceBallot)
+	mixer.Send(*foreign)
+
+	rejected, err := mixer.Flush(box)
+	if err != nil {
+		t.Fatal("failed to flush mixer:", err)
+	}
+
+	if len(rejected) != 2 {
+		t.Error("expected duplicate and foreign ballot to be rejected:", rejected)
+	}
+
+	tally := Count(box)
+	if tally.Counts["yes"] != 1 || tally.Counts["no"] != 1 || len(tally.Ballots) != 2 {
+		t.Error("unexpected tally:", tally.Counts)
+	}
+
+	// the published tally can be verified after a JSON round trip
+
+	published, err := json.Marshal(tally)
+	if err != nil {
+		t.Fatal("failed to encode tally:", err)
+	}
+
+	var received Tally
+	if err = json.Unmarshal(published, &received); err != nil {
+		t.Fatal("failed to decode tally:", err)
+	}
+
+	if err = received.Verify(registrar.PublicKey()); err != nil {
+		t.Error("failed to verify tally:", err)
+	}
+
+	received.Counts["yes"]++
+	if err = received.Verify(registrar.PublicKey()); err != errorTallyMismatch {
+		t.Error("inflated tally verified:", err)
+	}
+
+	received.Counts["yes"]--
+	received.Ballots = append(received.Ballots, received.Ballots[0])
+	received.Counts[received.Ballots[0].Choice]++
+	if err = received.Verify(registrar.PublicKey()); err != errorDuplicateBallot {
+		t.Error("duplicate ballot verified:", err)
+	}
+}
@@ -1,19 +1,42 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ed25519"
 	"crypto/elliptic"
+	"encoding/base64"
 	"encoding/binary"
-	"encoding/gob"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/blanu/pblind/signing"
+	"golang.org/x/crypto/ssh/terminal"
+	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"time"
+)
+
+const rotationDir = "signer/keys"
+
+const (
+	rootSecretFile        = "signer/root.secret"
+	rootPublicFile        = "requester/root.public"
+	revocationsFile       = "signer/revocations.json"
+	manifestFile          = "signer/manifest.json"
+	requesterManifestFile = "requester/manifest.json"
+	pinFile               = "requester/pins.json"
+	logDir                = "signer/log"
+	logPublicFile         = "requester/log.public"
+	treeHeadFile          = "requester/treehead.json"
+	tokenFile             = "signature/token"
+	signerEndpoint        = "localhost:1234"
 )
 
 func main() {
 	println("pblind")
-	gob.Register(elliptic.P256())
 
 	flag.Usage = func() {
 		_, _ = fmt.Fprintf(os.Stderr, "pblind v0.0.1\n\n")
@@ -35,12 +58,50 @@ func main() {
 	server := flag.Bool("server", false, "Run a signature server")
 	client := flag.Bool("client", false, "Run a signature requester client")
 	demo := flag.Bool("demo", false, "Test client and server on the same machine")
+	jwks := flag.String("jwks", "localhost:1235", "Address where the server publishes its key set")
+	keyURL := flag.String("keyurl", "", "Fetch the signer key from this key set URL instead of requester/signer.public")
+	rotate := flag.Bool("rotate", false, "Activate the pending signer key now")
+	keys := flag.Bool("keys", false, "List the signer keys and their states")
+	lifetime := flag.Duration("lifetime", 0, "Rotate the signer key after this long, 0 to rotate by hand")
+	maxIssuance := flag.Uint64("maxissuance", 0, "Rotate the signer key after this many signatures, 0 for no limit")
+	overlap := flag.Duration("overlap", 24*time.Hour, "How long a replaced signer key still verifies")
+	genroot := flag.Bool("genroot", false, "Generate the root key that signs revocation lists")
+	revoke := flag.String("revoke", "", "Revoke the signer key with this key ID")
+	reason := flag.String("reason", "", "Reason for the revocation")
+	manifest := flag.Bool("manifest", false, "Sign a key manifest of the current signer keys")
+	manifestValidity := flag.Duration("manifestvalidity", 7*24*time.Hour, "How long a key manifest stays valid")
+	manifestURL := flag.String("manifesturl", "", "Fetch the key manifest from this URL instead of requester/manifest.json")
+	mirrorURL := flag.String("mirror", "", "Cross-check the signer key with the key set at this mirror URL")
+	logAddr := flag.String("logaddr", "localhost:1236", "Address where the server runs the key transparency log")
+	logURL := flag.String("logurl", "", "Check the signer key in the key transparency log at this URL")
+	audit := flag.Bool("audit", false, "Audit the key transparency log at -logurl")
+	split := flag.Bool("split", false, "Split the secret key in -keyfile into Shamir shares in shares/")
+	threshold := flag.Int("threshold", 3, "Number of shares needed to recover the secret key")
+	shares := flag.Int("shares", 5, "Number of shares to split the secret key into")
+	recoverKey := flag.Bool("recover", false, "Recover the secret key into -keyfile from the share files given as arguments")
+	keyFile := flag.String("keyfile", "signer/signer.secret", "Secret key file to split or recover")
+	publicFile := flag.String("pubfile", "requester/signer.public", "Public key the recovered secret key must match")
+	mnemonic := flag.Bool("mnemonic", false, "With -genkeys, derive the signer key from a new seed and print its mnemonic")
+	restore := flag.String("restore", "", "With -genkeys, derive the signer key from the seed with this mnemonic")
+	path := flag.String("path", "signer", "Derivation path of the signer key under the seed")
+	force := flag.Bool("force", false, "With -genkeys, replace the signer keys even if rotated keys exist, which stops their tokens from verifying")
+	signerd := flag.String("signerd", "", "Sign through the signerd daemon listening on this socket instead of loading the signer keys")
+	poolSize := flag.Int("pool", 16, "Signer commitments to precompute in the background, 0 to compute them per session")
 
 	flag.Parse()
 
+	policy := signing.RotationPolicy{Lifetime: *lifetime, MaxIssuance: *maxIssuance, Overlap: *overlap}
+
 	if *genkeys {
 		println("Generating keys...")
 
+		// rotated keys still verify outstanding tokens
+		if _, statError := os.Stat(rotationDir); statError == nil && !*force {
+			println("signer keys are already being rotated in " + rotationDir)
+			println("use -rotate to replace the active key, or -force to discard every rotated key")
+			return
+		}
+
 		if _, err := os.Stat("requester"); os.IsNotExist(err) {
 			os.Mkdir("requester", 0755)
 		}
@@ -53,20 +114,179 @@ func main() {
 			os.Mkdir("signature", 0755)
 		}
 
-		sk, err := signing.NewSecretKey(elliptic.P256())
+		sk, err := generateSecretKey(*mnemonic, *restore, *path)
 		if err != nil {
 			println("failed to generate secret key")
+			println(err.Error())
 			return
 		}
 
-		sk.Save("signer/signer.secret")
+		passphrase, passphraseError := newPassphrase()
+		if passphraseError != nil {
+			println("failed to read passphrase")
+			println(passphraseError.Error())
+			return
+		}
+
+		saveError := sk.Save("signer/signer.secret", passphrase)
+		if saveError != nil {
+			println("failed to save secret key")
+			println(saveError.Error())
+			return
+		}
 
 		pk := sk.GetPublicKey()
 		pk.Save("requester/signer.public")
 
+		// the new key starts a new rotation, as asked for with -force
+		if removeError := os.RemoveAll(rotationDir); removeError != nil {
+			println("failed to remove rotated keys")
+			println(removeError.Error())
+			return
+		}
+
 		println("Generated keys.")
 	}
 
+	if *genroot {
+		println("Generating root key...")
+
+		root, rootError := signing.NewRootKey()
+		if rootError != nil {
+			println("failed to generate root key")
+			return
+		}
+
+		passphrase, passphraseError := newPassphrase()
+		if passphraseError != nil {
+			println("failed to read passphrase")
+			println(passphraseError.Error())
+			return
+		}
+
+		saveError := signing.SaveRootKey(rootSecretFile, root, passphrase)
+		if saveError != nil {
+			println("failed to save root key")
+			println(saveError.Error())
+			return
+		}
+
+		publicError := signing.SaveRootPublicKey(rootPublicFile, root.Public().(ed25519.PublicKey))
+		if publicError != nil {
+			println("failed to save root public key")
+			println(publicError.Error())
+			return
+		}
+
+		println("Generated root key.")
+	}
+
+	if *revoke != "" {
+		println("Revoking key...")
+
+		revokeError := doRevoke(*revoke, *reason, policy)
+		if revokeError != nil {
+			println("failed to revoke key")
+			println(revokeError.Error())
+			return
+		}
+
+		println("Revoked.")
+	}
+
+	if *manifest {
+		println("Signing key manifest...")
+
+		manifestError := doManifest(*manifestValidity, policy)
+		if manifestError != nil {
+			println("failed to sign key manifest")
+			println(manifestError.Error())
+			return
+		}
+
+		println("Signed key manifest.")
+	}
+
+	if *audit {
+		println("Auditing key transparency log...")
+
+		auditError := doAudit(*logURL)
+		if auditError != nil {
+			println("audit failed")
+			println(auditError.Error())
+			return
+		}
+
+		println("Log is consistent.")
+	}
+
+	if *split {
+		println("Splitting secret key...")
+
+		splitError := doSplit(*keyFile, *threshold, *shares)
+		if splitError != nil {
+			println("failed to split secret key")
+			println(splitError.Error())
+			return
+		}
+
+		println("Split.")
+	}
+
+	if *recoverKey {
+		println("Recovering secret key...")
+
+		recoverError := doRecover(*keyFile, *publicFile, flag.Args())
+		if recoverError != nil {
+			println("failed to recover secret key")
+			println(recoverError.Error())
+			return
+		}
+
+		println("Recovered.")
+	}
+
+	if *rotate {
+		println("Rotating keys...")
+
+		rotator, loadError := loadRotator(policy)
+		if loadError != nil {
+			println("failed to load signer keys, try -genkeys first")
+			println(loadError.Error())
+			return
+		}
+
+		rotateError := rotator.Rotate()
+		if rotateError != nil {
+			println("failed to rotate keys")
+			println(rotateError.Error())
+			return
+		}
+
+		saveError := saveRotator(rotator)
+		if saveError != nil {
+			println("failed to save signer keys")
+			println(saveError.Error())
+			return
+		}
+
+		println("Rotated.")
+	}
+
+	if *keys {
+		rotator, loadError := loadRotator(policy)
+		if loadError != nil {
+			println("failed to load signer keys, try -genkeys first")
+			println(loadError.Error())
+			return
+		}
+
+		for _, key := range rotator.Keys() {
+			kid, _ := key.Pk.KeyID()
+			fmt.Printf("%s %-11s issued %d, created %s\n", kid, key.State, key.Issued, key.Created.Format(time.RFC3339))
+		}
+	}
+
 	if *request {
 		println("Generting request...")
 
@@ -83,13 +303,25 @@ func main() {
 			return
 		}
 
-		requester, requestError := signing.CreateRequester(pk, compressed, []byte(*message))
+		requester, requestError := createRequester(pk, compressed, []byte(*message), *manifestURL)
 		if requestError != nil {
 			println("failed to create requester")
+			println(requestError.Error())
 			return
 		}
 
-		requester.Save("requester/request.0")
+		passphrase, passphraseError := newPassphrase()
+		if passphraseError != nil {
+			println("failed to read passphrase")
+			println(passphraseError.Error())
+			return
+		}
+
+		if saveError := requester.Save("requester/request.0", passphrase); saveError != nil {
+			println("failed to save requester")
+			println(saveError.Error())
+			return
+		}
 
 		println("Generated request.")
 	}
@@ -97,13 +329,25 @@ func main() {
 	if *stage1 {
 		println("Processing, stage 1...")
 
-		sk, loadError := signing.LoadSecretKey("signer/signer.secret")
+		rotator, loadError := loadRotator(policy)
 		if loadError != nil {
 			println("failed to load secret, try -genkeys first")
 			print(loadError.Error())
 			return
 		}
 
+		sk, issueError := rotator.Issue()
+		if issueError != nil {
+			println("failed to select signer key")
+			return
+		}
+
+		if saveError := saveRotator(rotator); saveError != nil {
+			println("failed to save signer keys")
+			println(saveError.Error())
+			return
+		}
+
 		compressed, compressError := signing.CompressInfo(elliptic.P256(), []byte(*info))
 		if compressError != nil {
 			println("failed to compress info")
@@ -116,7 +360,7 @@ func main() {
 			return
 		}
 
-		requester, requestError := signing.LoadRequester("requester/request.0")
+		requester, requestError := signing.LoadRequester("requester/request.0", readPassphrase)
 		if requestError != nil {
 			println("failed to load requester")
 			println(requestError.Error())
@@ -134,9 +378,10 @@ func main() {
 			println("failed to process msg1")
 		}
 
-		requesterSaveError := requester.Save("requester/request.1")
+		requesterSaveError := saveRequester(requester, "requester/request.1")
 		if requesterSaveError != nil {
 			println("failed to save requester")
+			println(requesterSaveError.Error())
 			return
 		}
 
@@ -153,14 +398,15 @@ func main() {
 	if *stage2 {
 		println("Processing, stage 2...")
 
-		signer, signerError := signing.LoadSigner("signer/signer.1")
+		// the key is only needed to answer the challenge in stage 3
+		signer, signerError := signing.LoadSigner("signer/signer.1", nil)
 		if signerError != nil {
 			println("failed to create signer")
 			println(signerError.Error())
 			return
 		}
 
-		requester, requestError := signing.LoadRequester("requester/request.1")
+		requester, requestError := signing.LoadRequester("requester/request.1", readPassphrase)
 		if requestError != nil {
 			println("failed to create requester")
 			return
@@ -179,7 +425,11 @@ func main() {
 			return
 		}
 
-		requester.Save("requester/request.2")
+		if saveError := saveRequester(requester, "requester/request.2"); saveError != nil {
+			println("failed to save requester")
+			println(saveError.Error())
+			return
+		}
 		signer.Save("signer/signer.2")
 
 		println("Processed.")
@@ -188,14 +438,21 @@ func main() {
 	if *stage3 {
 		println("Processing, stage 3...")
 
-		signer, signerError := signing.LoadSigner("signer/signer.2")
+		rotator, loadError := loadRotator(policy)
+		if loadError != nil {
+			println("failed to load secret, try -genkeys first")
+			print(loadError.Error())
+			return
+		}
+
+		signer, signerError := signing.LoadSigner("signer/signer.2", rotator.Key)
 		if signerError != nil {
 			println("failed to create signer")
 			println(signerError.Error())
 			return
 		}
 
-		requester, requestError := signing.LoadRequester("requester/request.2")
+		requester, requestError := signing.LoadRequester("requester/request.2", readPassphrase)
 		if requestError != nil {
 			println("failed to create requester")
 			return
@@ -214,7 +471,14 @@ func main() {
 			return
 		}
 
-		requester.Save("requester/request.3")
+		signer.Destroy()
+		requester.Destroy()
+
+		if saveError := saveRequester(requester, "requester/request.3"); saveError != nil {
+			println("failed to save requester")
+			println(saveError.Error())
+			return
+		}
 		signer.Save("signer/signer.3")
 
 		println("Processed.")
@@ -223,74 +487,107 @@ func main() {
 	if *sign {
 		println("Signing...")
 
-		requester, loadError := signing.LoadRequester("requester/request.3")
+		requester, loadError := signing.LoadRequester("requester/request.3", readPassphrase)
 		if loadError != nil {
 			println("failed to load requester, try -request first")
 			return
 		}
 
-		sig, signError := requester.Signature()
-		if signError != nil {
+		token, tokenError := requester.Token()
+		if tokenError != nil {
 			println("failed to obtain signature")
-			print(signError.Error())
+			print(tokenError.Error())
 			return
 		}
 
-		sig.Save("signature/signature")
+		requester.Destroy()
+
+		if saveError := token.Save(tokenFile); saveError != nil {
+			println("failed to save signature")
+			println(saveError.Error())
+			return
+		}
+
+		// the stage files link the signature to the issuance session
+		for stage := 0; stage <= 3; stage++ {
+			stageFile := fmt.Sprintf("requester/request.%d", stage)
+			if wipeError := signing.WipeFile(stageFile); wipeError != nil && !os.IsNotExist(wipeError) {
+				println("failed to wipe", stageFile)
+				println(wipeError.Error())
+			}
+		}
+
 		println("Signed")
 	}
 
 	if *check {
 		println("Checking signature...")
 
-		sig, signatureError := signing.LoadSignature("signature/signature")
-		if signatureError != nil {
-			println("failed to load signature")
-			println(signatureError.Error())
-			return
-		}
-
-		sk, loadError := signing.LoadSecretKey("signer/signer.secret")
-		if loadError != nil {
-			println("failed to load secret, try -genkeys first")
-			print(loadError.Error())
+		token, tokenError := signing.LoadToken(tokenFile)
+		if tokenError != nil {
+			println("failed to load signature, try -sign first")
+			println(tokenError.Error())
 			return
 		}
 
-		pk := sk.GetPublicKey()
-
 		compressed, compressError := signing.CompressInfo(elliptic.P256(), []byte(*info))
 		if compressError != nil {
 			println("failed to compress info")
 			return
 		}
 
-		if !pk.Check(*sig, compressed, []byte(*message)) {
+		ring, ringError := loadKeyring()
+		if ringError != nil {
+			println("failed to load keyring, try -genkeys first")
+			println(ringError.Error())
+			return
+		}
+
+		// keys are only trusted once they are found in the log
+		if *logURL != "" {
+			client, clientError := logClient(*logURL)
+			if clientError != nil {
+				println("failed to load key transparency log key")
+				println(clientError.Error())
+				return
+			}
+			ring.Log = client
+		}
+
+		if checkError := ring.Check(*token, compressed, []byte(*message)); checkError != nil {
 			println("failed to validate signature")
+			println(checkError.Error())
 			println(*info)
 			println(*message)
 			return
 		}
 
+		if ring.Log != nil {
+			if saveError := saveTreeHead(ring.Log); saveError != nil {
+				println("failed to save tree head")
+				println(saveError.Error())
+			}
+		}
+
 		println("Success!")
 	}
 
 	if *server {
-		doServer()
+		doServer(*jwks, *logAddr, policy, *signerd, *poolSize)
 	}
 
 	if *client {
-		doClient(*info, *message)
+		doClient(*info, *message, *keyURL, *manifestURL, *mirrorURL, *logURL)
 	}
 
 	if *demo {
-		go doServer()
-		doClient(*info, *message)
+		go doServer(*jwks, *logAddr, policy, *signerd, *poolSize)
+		doClient(*info, *message, *keyURL, *manifestURL, *mirrorURL, *logURL)
 	}
 }
 
-func doClient(info string, message string) {
-	pk, loadError := signing.LoadPublicKey("requester/signer.public")
+func doClient(info string, message string, keyURL string, manifestURL string, mirrorURL string, logURL string) {
+	pk, loadError := loadSignerKey(keyURL)
 	if loadError != nil {
 		println("failed to load signer public key, try -genkeys first")
 		print(loadError.Error())
@@ -303,13 +600,30 @@ func doClient(info string, message string) {
 		return
 	}
 
-	requester, requestError := signing.CreateRequester(pk, compressed, []byte(message))
+	pinError := checkPin(pk, manifestURL, mirrorURL)
+	if pinError != nil {
+		println("signer key not trusted")
+		println(pinError.Error())
+		return
+	}
+
+	if logURL != "" {
+		logError := checkKeyLog(pk, logURL)
+		if logError != nil {
+			println("signer key not in key transparency log")
+			println(logError.Error())
+			return
+		}
+	}
+
+	requester, requestError := createRequester(pk, compressed, []byte(message), manifestURL)
 	if requestError != nil {
 		println("failed to create requester")
+		println(requestError.Error())
 		return
 	}
 
-	connection, dialError := net.Dial("tcp","localhost:1234")
+	connection, dialError := net.Dial("tcp", signerEndpoint)
 	if dialError != nil {
 		println("failure dialing")
 		return
@@ -318,20 +632,68 @@ func doClient(info string, message string) {
 	requester = requesterStage1(connection, requester, info)
 	requester = requesterStage2(connection, requester)
 	requester = requesterStage3(connection, requester)
-	signature := requesterSign(requester)
+	token := requesterSign(requester)
+	requester.Destroy()
+	if token == nil {
+		return
+	}
 
-	signature.Save("signature")
+	if saveError := token.Save("token"); saveError != nil {
+		println("failed to save signature")
+		println(saveError.Error())
+		return
+	}
 	println("Signed")
 }
 
-func doServer() {
-	sk, loadError := signing.LoadSecretKey("signer/signer.secret")
-	if loadError != nil {
-		println("failed to load secret, try -genkeys first")
-		print(loadError.Error())
+func doServer(jwksAddr string, logAddr string, policy signing.RotationPolicy, signerdPath string, poolSize int) {
+	var backend signing.SignerBackend
+	var keySet http.Handler
+
+	if signerdPath != "" {
+		// the daemon holds the keys, only their public halves are read here
+		backend = signing.SocketSigner{Path: signerdPath}
+		keySet = http.HandlerFunc(serveRotationKeySet)
+	} else {
+		rotator, loadError := loadRotator(policy)
+		if loadError != nil {
+			println("failed to load secret, try -genkeys first")
+			print(loadError.Error())
+			return
+		}
+
+		local := signing.NewLocalSigner(func() (signing.KeyBackend, error) {
+			sk, issueError := rotator.Issue()
+			if issueError != nil {
+				return nil, issueError
+			}
+
+			if saveError := saveRotator(rotator); saveError != nil {
+				println("failed to save signer keys")
+				println(saveError.Error())
+			}
+
+			return sk, nil
+		})
+		if poolSize > 0 {
+			local.Pool = signing.NewCommitmentPool(rotator.Active().Curve, poolSize)
+			defer local.Pool.Close()
+		}
+		backend = local
+		keySet = rotator
+	}
+
+	go serveKeySet(jwksAddr, keySet)
+
+	keyLog, logError := openKeyLog()
+	if logError != nil {
+		println("failed to open key transparency log")
+		println(logError.Error())
 		return
 	}
 
+	go serveKeyLog(logAddr, keyLog)
+
 	println("Listening...")
 
 	listener, listenError := net.Listen("tcp", "localhost:1234")
@@ -350,27 +712,561 @@ func doServer() {
 		println("Incoming connection")
 		println(connection.RemoteAddr().String())
 
-		serverHandleConnection(connection, sk)
+		serverHandleConnection(connection, backend)
+	}
+}
+
+// serveRotationKeySet publishes the key set of the rotation state kept by
+// signerd.
+func serveRotationKeySet(w http.ResponseWriter, r *http.Request) {
+	set, loadError := signing.LoadKeySet(rotationDir)
+	if loadError != nil {
+		http.Error(w, loadError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	set.ServeHTTP(w, r)
+}
+
+func serveKeySet(addr string, keySet http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/.well-known/jwks.json", keySet)
+	mux.HandleFunc("/revocations.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, revocationsFile)
+	})
+	mux.HandleFunc("/manifest.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, manifestFile)
+	})
+
+	println("Publishing key set on " + addr)
+
+	serveError := http.ListenAndServe(addr, mux)
+	if serveError != nil {
+		println("failure to publish key set")
+		println(serveError.Error())
+	}
+}
+
+// loadRotator reads the signer keys and their states, starting rotation
+// with signer/signer.secret on first use.
+func loadRotator(policy signing.RotationPolicy) (*signing.KeyRotator, error) {
+	if _, statError := os.Stat(filepath.Join(rotationDir, "rotation.json")); statError == nil {
+		return signing.LoadKeyRotator(rotationDir, policy, readPassphrase, nil)
+	}
+
+	sk, loadError := signing.LoadSecretKey("signer/signer.secret", readPassphrase)
+	if loadError != nil {
+		return nil, loadError
+	}
+
+	rotator, rotatorError := signing.NewKeyRotator(policy, sk, nil)
+	if rotatorError != nil {
+		return nil, rotatorError
+	}
+
+	return rotator, saveRotator(rotator)
+}
+
+// saveRotator saves the signer keys and keeps the requester copy of the
+// active key current.
+func saveRotator(rotator *signing.KeyRotator) error {
+	passphrase, passphraseError := readPassphrase()
+	if passphraseError != nil {
+		return passphraseError
+	}
+
+	if saveError := rotator.Save(rotationDir, passphrase); saveError != nil {
+		return saveError
+	}
+
+	keyLog, logError := openKeyLog()
+	if logError != nil {
+		return logError
+	}
+
+	// keys are logged as soon as they are published, before activation
+	for _, key := range rotator.Keys() {
+		if key.State == signing.KeyRetired {
+			continue
+		}
+		if _, appendError := keyLog.AppendKey(key.Pk); appendError != nil {
+			return appendError
+		}
+	}
+
+	return rotator.Active().Save("requester/signer.public")
+}
+
+// openedKeyLog is the key transparency log once opened, shared by the log
+// server and the code that appends keys.
+var openedKeyLog *signing.TransparencyLog
+
+func openKeyLog() (*signing.TransparencyLog, error) {
+	if openedKeyLog != nil {
+		return openedKeyLog, nil
+	}
+
+	opened, openError := signing.OpenTransparencyLog(logDir, readPassphrase)
+	if openError != nil {
+		return nil, openError
+	}
+
+	if _, statError := os.Stat(logPublicFile); os.IsNotExist(statError) {
+		if saveError := signing.SaveRootPublicKey(logPublicFile, opened.PublicKey()); saveError != nil {
+			return nil, saveError
+		}
+	}
+
+	openedKeyLog = opened
+
+	return openedKeyLog, nil
+}
+
+func serveKeyLog(addr string, keyLog *signing.TransparencyLog) {
+	println("Running key transparency log on " + addr)
+
+	serveError := http.ListenAndServe(addr, keyLog)
+	if serveError != nil {
+		println("failure to run key transparency log")
+		println(serveError.Error())
+	}
+}
+
+// logClient returns a client for the log at logURL that continues from the
+// tree head saved in requester/treehead.json.
+func logClient(logURL string) (*signing.LogClient, error) {
+	key, keyError := signing.LoadRootPublicKey(logPublicFile)
+	if keyError != nil {
+		return nil, keyError
+	}
+
+	client := &signing.LogClient{URL: logURL, Key: key}
+
+	head, headError := signing.LoadTreeHead(treeHeadFile)
+	if headError != nil && !os.IsNotExist(headError) {
+		return nil, headError
 	}
+	client.Head = head
+
+	return client, nil
+}
+
+func saveTreeHead(client *signing.LogClient) error {
+	return signing.SaveTreeHead(treeHeadFile, client.Head)
+}
+
+// checkKeyLog checks that pk is in the key transparency log, as of a tree
+// head consistent with the ones seen before.
+func checkKeyLog(pk *signing.PublicKey, logURL string) error {
+	client, clientError := logClient(logURL)
+	if clientError != nil {
+		return clientError
+	}
+
+	if checkError := client.Check(pk); checkError != nil {
+		return checkError
+	}
+
+	return saveTreeHead(client)
+}
+
+// doAudit recomputes the key transparency log from all its entries and
+// lists the logged keys.
+func doAudit(logURL string) error {
+	if logURL == "" {
+		return errors.New("no log, set -logurl")
+	}
+
+	client, clientError := logClient(logURL)
+	if clientError != nil {
+		return clientError
+	}
+
+	entries, auditError := client.Audit()
+	if auditError != nil {
+		return auditError
+	}
+
+	for index, entry := range entries {
+		var pk signing.PublicKey
+		if decodeError := pk.UnmarshalBinary(entry); decodeError != nil {
+			return decodeError
+		}
+
+		kid, _ := pk.KeyID()
+		fmt.Printf("%d %s %s\n", index, kid, pk.Curve.Params().Name)
+	}
+
+	fmt.Printf("tree size %d, root %s\n", client.Head.Size, base64.RawURLEncoding.EncodeToString(client.Head.RootHash))
+
+	return saveTreeHead(client)
+}
+
+// loadKeyring returns the public keys of the rotation, with the revocation
+// list applied if there is one.
+func loadKeyring() (*signing.Keyring, error) {
+	ring, ringError := signing.LoadKeyring(rotationDir)
+	if os.IsNotExist(ringError) {
+		// keys have not been rotated yet
+		pk, loadError := signing.LoadPublicKey("requester/signer.public")
+		if loadError != nil {
+			return nil, loadError
+		}
+
+		ring = signing.NewKeyring()
+		ringError = ring.Add(pk, time.Time{}, time.Time{})
+	}
+	if ringError != nil {
+		return nil, ringError
+	}
+
+	if _, statError := os.Stat(revocationsFile); os.IsNotExist(statError) {
+		return ring, nil
+	}
+
+	root, rootError := signing.LoadRootPublicKey(rootPublicFile)
+	if rootError != nil {
+		return nil, rootError
+	}
+
+	list, listError := signing.LoadRevocationList(revocationsFile, root)
+	if listError != nil {
+		return nil, listError
+	}
+
+	return ring, ring.SetRevocations(list)
+}
+
+// doRevoke adds the key to the signed revocation list and takes it out of
+// rotation if it is one of ours.
+func doRevoke(kid string, reason string, policy signing.RotationPolicy) error {
+	keyID, decodeError := base64.RawURLEncoding.DecodeString(kid)
+	if decodeError != nil {
+		return decodeError
+	}
+
+	root, rootError := signing.LoadRootKey(rootSecretFile, readPassphrase)
+	if rootError != nil {
+		return rootError
+	}
+
+	list := &signing.RevocationList{}
+	if _, statError := os.Stat(revocationsFile); statError == nil {
+		var listError error
+		list, listError = signing.LoadRevocationList(revocationsFile, root.Public().(ed25519.PublicKey))
+		if listError != nil {
+			return listError
+		}
+	}
+
+	now := time.Now().UTC()
+	list.Sequence++
+	list.Issued = now
+	list.Revoke(keyID, now, reason)
+
+	data, signError := list.Sign(root)
+	if signError != nil {
+		return signError
+	}
+
+	if writeError := signing.SaveRevocationList(revocationsFile, data); writeError != nil {
+		return writeError
+	}
+
+	rotator, loadError := loadRotator(policy)
+	if loadError != nil {
+		return loadError
+	}
+
+	retireError := rotator.Retire(keyID)
+	if retireError == signing.ErrorUnknownKey {
+		return nil
+	}
+	if retireError != nil {
+		return retireError
+	}
+
+	return saveRotator(rotator)
+}
+
+// doManifest signs a manifest of the signer keys that are not retired and
+// gives the requester a copy.
+func doManifest(validity time.Duration, policy signing.RotationPolicy) error {
+	root, rootError := signing.LoadRootKey(rootSecretFile, readPassphrase)
+	if rootError != nil {
+		return rootError
+	}
+
+	rotator, loadError := loadRotator(policy)
+	if loadError != nil {
+		return loadError
+	}
+
+	var sequence uint64 = 1
+	if data, readError := ioutil.ReadFile(manifestFile); readError == nil {
+		previous, verifyError := signing.VerifyManifest(data, root.Public().(ed25519.PublicKey))
+		if verifyError != nil {
+			return verifyError
+		}
+		sequence = previous.Sequence + 1
+	}
+
+	manifest, manifestError := rotator.Manifest(sequence, time.Now().Add(validity).UTC())
+	if manifestError != nil {
+		return manifestError
+	}
+
+	data, signError := manifest.Sign(root)
+	if signError != nil {
+		return signError
+	}
+
+	if writeError := signing.SaveManifest(manifestFile, data); writeError != nil {
+		return writeError
+	}
+
+	return signing.SaveManifest(requesterManifestFile, data)
+}
+
+// createRequester only runs issuance under keys in the signed key manifest
+// once the requester has a root key. The manifest is fetched from
+// manifestURL, or read from requester/manifest.json.
+func createRequester(pk *signing.PublicKey, info signing.Info, message []byte, manifestURL string) (*signing.StateRequester, error) {
+	if _, statError := os.Stat(rootPublicFile); os.IsNotExist(statError) {
+		return signing.CreateRequester(pk, info, message)
+	}
+
+	manifest, manifestError := loadManifest(manifestURL)
+	if manifestError != nil {
+		return nil, manifestError
+	}
+
+	return manifest.CreateRequester(pk, info, message)
+}
+
+func loadManifest(manifestURL string) (*signing.KeyManifest, error) {
+	root, rootError := signing.LoadRootPublicKey(rootPublicFile)
+	if rootError != nil {
+		return nil, rootError
+	}
+
+	var saved *signing.KeyManifest
+	if data, readError := ioutil.ReadFile(requesterManifestFile); readError == nil {
+		manifest, verifyError := signing.VerifyManifest(data, root)
+		if verifyError != nil {
+			return nil, verifyError
+		}
+		saved = manifest
+	}
+
+	if manifestURL == "" {
+		if saved == nil {
+			return nil, errors.New("no key manifest, try -manifest first")
+		}
+		return saved, nil
+	}
+
+	data, fetchError := signing.Fetch(manifestURL)
+	if fetchError != nil {
+		return nil, fetchError
+	}
+
+	manifest, verifyError := signing.VerifyManifest(data, root)
+	if verifyError != nil {
+		return nil, verifyError
+	}
+
+	if sequenceError := manifest.CheckSequence(saved); sequenceError != nil {
+		return nil, sequenceError
+	}
+
+	// keep the newest manifest seen, so an older one is refused next time
+	if saved == nil || manifest.Sequence > saved.Sequence {
+		if writeError := signing.SaveManifest(requesterManifestFile, data); writeError != nil {
+			return nil, writeError
+		}
+	}
+
+	return manifest, nil
 }
 
-func serverHandleConnection(connection net.Conn, sk *signing.SecretKey) {
-	signer := signerStage1(connection, sk)
-	if signer == nil {
+// checkPin checks the signer key against the key pinned for the signer in
+// requester/pins.json, cross-checked with the mirror and the key manifest
+// when there are any.
+func checkPin(pk *signing.PublicKey, manifestURL string, mirrorURL string) error {
+	store, openError := signing.OpenPinStore(pinFile)
+	if openError != nil {
+		return openError
+	}
+
+	if mirrorURL != "" {
+		store.Sources = append(store.Sources, signing.MirrorSource{URLs: map[string]string{signerEndpoint: mirrorURL}})
+	}
+
+	if _, statError := os.Stat(rootPublicFile); statError == nil {
+		manifest, manifestError := loadManifest(manifestURL)
+		if manifestError != nil {
+			return manifestError
+		}
+		store.Sources = append(store.Sources, manifest)
+	}
+
+	store.OnChange = func(endpoint string, pinned []byte, seen []byte) {
+		println("WARNING: the key of " + endpoint + " changed")
+		println("pinned: " + base64.RawURLEncoding.EncodeToString(pinned))
+		println("seen:   " + base64.RawURLEncoding.EncodeToString(seen))
+	}
+
+	return store.Check(signerEndpoint, pk)
+}
+
+// generateSecretKey returns a random signer key, or one derived from a new
+// seed or from the seed with the mnemonic restore.
+func generateSecretKey(mnemonic bool, restore string, path string) (*signing.SecretKey, error) {
+	if !mnemonic && restore == "" {
+		return signing.NewSecretKey(elliptic.P256())
+	}
+
+	var seed []byte
+	var seedError error
+
+	if restore != "" {
+		seed, seedError = signing.DecodeMnemonic(restore)
+	} else {
+		seed, seedError = signing.NewSeed()
+	}
+
+	if seedError != nil {
+		return nil, seedError
+	}
+
+	if mnemonic {
+		words, encodeError := signing.EncodeMnemonic(seed)
+		if encodeError != nil {
+			return nil, encodeError
+		}
+
+		println("Write down the seed mnemonic, it recreates the key with -restore:")
+		fmt.Println(words)
+	}
+
+	return signing.DeriveSecretKey(elliptic.P256(), seed, path)
+}
+
+// doSplit writes the shares of the secret key to shares/share.<index>, to be
+// handed to different custodians.
+func doSplit(keyFile string, threshold int, count int) error {
+	sk, loadError := signing.LoadSecretKey(keyFile, readPassphrase)
+	if loadError != nil {
+		return loadError
+	}
+
+	keyShares, splitError := signing.SplitSecretKey(sk, threshold, count)
+	if splitError != nil {
+		return splitError
+	}
+
+	if mkdirError := os.MkdirAll("shares", 0700); mkdirError != nil {
+		return mkdirError
+	}
+
+	for _, share := range keyShares {
+		text, marshalError := share.MarshalText()
+		if marshalError != nil {
+			return marshalError
+		}
+
+		shareFile := filepath.Join("shares", fmt.Sprintf("share.%d", share.Index))
+		if writeError := ioutil.WriteFile(shareFile, text, 0600); writeError != nil {
+			return writeError
+		}
+
+		println("wrote " + shareFile)
+	}
+
+	return nil
+}
+
+// doRecover combines the share files into the secret key of the public key
+// in publicFile and saves it, never over an existing file.
+func doRecover(keyFile string, publicFile string, shareFiles []string) error {
+	if _, statError := os.Stat(keyFile); statError == nil {
+		return errors.New(keyFile + " exists, choose another -keyfile")
+	}
+
+	pk, loadError := signing.LoadPublicKey(publicFile)
+	if loadError != nil {
+		return loadError
+	}
+
+	var keyShares []signing.KeyShare
+	for _, shareFile := range shareFiles {
+		text, readError := ioutil.ReadFile(shareFile)
+		if readError != nil {
+			return readError
+		}
+
+		var share signing.KeyShare
+		if parseError := share.UnmarshalText(text); parseError != nil {
+			return errors.New(shareFile + ": " + parseError.Error())
+		}
+
+		keyShares = append(keyShares, share)
+	}
+
+	sk, combineError := signing.CombineKeyShares(keyShares, pk)
+	if combineError != nil {
+		return combineError
+	}
+
+	passphrase, passphraseError := newPassphrase()
+	if passphraseError != nil {
+		return passphraseError
+	}
+
+	return sk.Save(keyFile, passphrase)
+}
+
+// loadSignerKey fetches the signer key from a key set URL, or reads the
+// local copy if no URL is given.
+func loadSignerKey(keyURL string) (*signing.PublicKey, error) {
+	if keyURL == "" {
+		return signing.LoadPublicKey("requester/signer.public")
+	}
+
+	keySet, fetchError := signing.FetchKeySet(keyURL)
+	if fetchError != nil {
+		return nil, fetchError
+	}
+
+	if len(keySet.Keys) == 0 {
+		return nil, errors.New("key set contains no pblind keys")
+	}
+
+	println("Using signer key " + keySet.Keys[0].KeyID)
+
+	return keySet.Keys[0].Key, nil
+}
+
+func serverHandleConnection(connection net.Conn, backend signing.SignerBackend) {
+	session := signerStage1(connection, backend)
+	if session == nil {
 		println("error in stage 1")
 		return
 	}
 
-	signer = signerStage2(connection, signer)
-	if signer == nil {
+	msg2 := signerStage2(connection)
+	if msg2 == nil {
 		println("error in stage 2")
 		return
 	}
 
-	signerStage3(connection, signer)
+	signerStage3(connection, backend, session, msg2)
 }
 
-func signerStage1(connection net.Conn, sk *signing.SecretKey) *signing.StateSigner {
+func signerStage1(connection net.Conn, backend signing.SignerBackend) []byte {
 	info := receiveMessage(connection)
 
 	compressed, compressError := signing.CompressInfo(elliptic.P256(), info)
@@ -379,25 +1275,20 @@ func signerStage1(connection net.Conn, sk *signing.SecretKey) *signing.StateSign
 		return nil
 	}
 
-	signer, signerError := signing.CreateSigner(*sk, compressed)
-	if signerError != nil {
-		println("failed to create signer")
-		return nil
-	}
-
-	msg1, messageError := signer.CreateMessage1()
-	if messageError != nil {
+	session, msg1, beginError := backend.Begin(compressed)
+	if beginError != nil {
 		println("failed to create msg1")
+		println(beginError.Error())
 		return nil
 	}
 
 	response := msg1.Bytes()
 	sendMessage(connection, response)
 
-	return signer
+	return session
 }
 
-func signerStage2(connection net.Conn, signer *signing.StateSigner) *signing.StateSigner {
+func signerStage2(connection net.Conn) *signing.Message2 {
 	msg2Bytes := receiveMessage(connection)
 
 	msg2, messageError := signing.Message2FromBytes(msg2Bytes)
@@ -405,20 +1296,14 @@ func signerStage2(connection net.Conn, signer *signing.StateSigner) *signing.Sta
 		return nil
 	}
 
-	processError := signer.ProcessMessage2(*msg2)
-	if processError != nil {
-		println("failed to process msg2Bytes")
-		println(processError.Error())
-		return nil
-	}
-
-	return signer
+	return msg2
 }
 
-func signerStage3(connection net.Conn, signer *signing.StateSigner) {
-	msg3, createError := signer.CreateMessage3()
-	if createError != nil {
+func signerStage3(connection net.Conn, backend signing.SignerBackend, session []byte, msg2 *signing.Message2) {
+	msg3, respondError := backend.Respond(session, *msg2)
+	if respondError != nil {
 		println("failed to create msg3")
+		println(respondError.Error())
 		return
 	}
 
@@ -475,15 +1360,15 @@ func requesterStage3(connection net.Conn, requester *signing.StateRequester) *si
 	return requester
 }
 
-func requesterSign(requester *signing.StateRequester) *signing.Signature {
-	sig, signError := requester.Signature()
-	if signError != nil {
+func requesterSign(requester *signing.StateRequester) *signing.Token {
+	token, tokenError := requester.Token()
+	if tokenError != nil {
 		println("failed to obtain signature")
-		print(signError.Error())
+		print(tokenError.Error())
 		return nil
 	}
 
-	return &sig
+	return &token
 }
 
 func sendMessage(connection net.Conn, data []byte) {
@@ -506,4 +1391,66 @@ func receiveMessage(connection net.Conn) []byte {
 	connection.Read(data)
 
 	return data
-}
\ No newline at end of file
+}
+
+// cachedPassphrase is the passphrase once it has been entered, so it is only
+// prompted for once per run.
+var cachedPassphrase []byte
+
+// readPassphrase takes the secret key passphrase from PBLIND_PASSPHRASE or
+// prompts for it on the terminal.
+func readPassphrase() ([]byte, error) {
+	if cachedPassphrase != nil {
+		return cachedPassphrase, nil
+	}
+
+	if passphrase := os.Getenv("PBLIND_PASSPHRASE"); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	stdin := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(stdin) {
+		return nil, errors.New("no terminal, set PBLIND_PASSPHRASE")
+	}
+
+	_, _ = fmt.Fprint(os.Stderr, "Passphrase: ")
+	passphrase, readError := terminal.ReadPassword(stdin)
+	_, _ = fmt.Fprintln(os.Stderr)
+
+	if readError == nil {
+		cachedPassphrase = passphrase
+	}
+
+	return passphrase, readError
+}
+
+// saveRequester seals a requester stage file under the passphrase.
+func saveRequester(requester *signing.StateRequester, filename string) error {
+	passphrase, passphraseError := readPassphrase()
+	if passphraseError != nil {
+		return passphraseError
+	}
+
+	return requester.Save(filename, passphrase)
+}
+
+// newPassphrase is readPassphrase with confirmation when prompting.
+func newPassphrase() ([]byte, error) {
+	passphrase, readError := readPassphrase()
+	if readError != nil || os.Getenv("PBLIND_PASSPHRASE") != "" {
+		return passphrase, readError
+	}
+
+	_, _ = fmt.Fprint(os.Stderr, "Confirm ")
+	cachedPassphrase = nil
+	confirmation, confirmError := readPassphrase()
+	if confirmError != nil {
+		return nil, confirmError
+	}
+
+	if !bytes.Equal(passphrase, confirmation) {
+		return nil, errors.New("passphrases do not match")
+	}
+
+	return passphrase, nil
+}
@@ -0,0 +1,132 @@
+package signing
+
+// Signer backends.
+//
+// A SignerBackend runs the signer side of signing sessions: it commits to
+// the session nonces for the info of a request and later answers the
+// challenge of the requester. A server that only relays messages between
+// requesters and a backend never sees the secret key, so the key can be
+// kept in a separate process, such as the signerd daemon behind a Unix
+// socket.
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+)
+
+const sessionIDSize = 16
+
+const defaultSessionTimeout = 5 * time.Minute
+
+type SignerBackend interface {
+	// Begin starts a session for info and returns its ID and first message.
+	Begin(info Info) ([]byte, Message1, error)
+
+	// Respond answers the challenge of a session and ends it.
+	Respond(session []byte, msg Message2) (Message3, error)
+}
+
+// LocalSigner is a SignerBackend that holds its keys in this process.
+type LocalSigner struct {
+	Key     func() (KeyBackend, error) // key for each new session, e.g. from KeyRotator.Issue
+	Timeout time.Duration              // sessions expire after this long, 5 minutes if 0
+	Now     func() time.Time           // time.Now if nil
+	Pool    *CommitmentPool            // precomputed commitments, if not nil
+
+	mutex    sync.Mutex
+	sessions map[string]*localSession
+}
+
+type localSession struct {
+	signer  *StateSigner
+	started time.Time
+}
+
+func NewLocalSigner(key func() (KeyBackend, error)) *LocalSigner {
+	return &LocalSigner{Key: key, sessions: make(map[string]*localSession)}
+}
+
+func (backend *LocalSigner) now() time.Time {
+	if backend.Now != nil {
+		return backend.Now()
+	}
+	return time.Now()
+}
+
+func (backend *LocalSigner) timeout() time.Duration {
+	if backend.Timeout > 0 {
+		return backend.Timeout
+	}
+	return defaultSessionTimeout
+}
+
+func (backend *LocalSigner) Begin(info Info) ([]byte, Message1, error) {
+	key, err := backend.Key()
+	if err != nil {
+		return nil, Message1{}, err
+	}
+
+	signer, msg, err := backend.createSigner(key, info)
+	if err != nil {
+		return nil, Message1{}, err
+	}
+
+	session := make([]byte, sessionIDSize)
+	if _, err = rand.Read(session); err != nil {
+		return nil, Message1{}, err
+	}
+
+	backend.mutex.Lock()
+	defer backend.mutex.Unlock()
+
+	now := backend.now()
+	backend.expire(now)
+	backend.sessions[string(session)] = &localSession{signer: signer, started: now}
+
+	return session, msg, nil
+}
+
+func (backend *LocalSigner) Respond(session []byte, msg Message2) (Message3, error) {
+	backend.mutex.Lock()
+	backend.expire(backend.now())
+	state, ok := backend.sessions[string(session)]
+	delete(backend.sessions, string(session))
+	backend.mutex.Unlock()
+
+	if !ok {
+		return Message3{}, ErrorUnknownSession
+	}
+	defer state.signer.Destroy()
+
+	if err := state.signer.ProcessMessage2(msg); err != nil {
+		return Message3{}, err
+	}
+
+	return state.signer.CreateMessage3()
+}
+
+func (backend *LocalSigner) createSigner(key KeyBackend, info Info) (*StateSigner, Message1, error) {
+	if backend.Pool != nil {
+		return backend.Pool.CreateSigner(key, info)
+	}
+
+	signer, err := CreateSignerWithBackend(key, info)
+	if err != nil {
+		return nil, Message1{}, err
+	}
+
+	msg, err := signer.CreateMessage1()
+	return signer, msg, err
+}
+
+// expire drops sessions that were not answered in time. The mutex must be
+// held.
+func (backend *LocalSigner) expire(now time.Time) {
+	for session, state := range backend.sessions {
+		if now.Sub(state.started) > backend.timeout() {
+			state.signer.Destroy()
+			delete(backend.sessions, session)
+		}
+	}
+}
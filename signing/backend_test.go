@@ -0,0 +1,132 @@
+package signing
+
+import (
+	"crypto/elliptic"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func signWithBackend(t *testing.T, backend SignerBackend, pk *PublicKey, info Info, message []byte) error {
+	requester, _ := CreateRequester(pk, info, message)
+
+	session, msg1, err := backend.Begin(info)
+	if err != nil {
+		return err
+	}
+	if err = requester.ProcessMessage1(msg1); err != nil {
+		t.Fatal("failed to process msg1:", err)
+	}
+	msg2, _ := requester.CreateMessage2()
+	msg3, err := backend.Respond(session, msg2)
+	if err != nil {
+		return err
+	}
+	if err = requester.ProcessMessage3(msg3); err != nil {
+		t.Fatal("failed to process msg3:", err)
+	}
+
+	sig, _ := requester.Signature()
+	if !pk.Check(sig, info, message) {
+		t.Fatal("backend signature does not verify")
+	}
+
+	return nil
+}
+
+func TestLocalSigner(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	sk, _ := NewSecretKey(elliptic.P256())
+	info, _ := CompressInfo(elliptic.P256(), []byte("info"))
+
+	backend := NewLocalSigner(func() (KeyBackend, error) { return sk, nil })
+	backend.Now = func() time.Time { return now }
+
+	if err := signWithBackend(t, backend, sk.GetPublicKey(), info, []byte("message")); err != nil {
+		t.Fatal("local signer failed:", err)
+	}
+
+	// sessions are single use and expire
+
+	session, _, _ := backend.Begin(info)
+	msg2 := Message2{Curve: elliptic.P256(), E: big.NewInt(1)}
+
+	now = now.Add(defaultSessionTimeout + time.Second)
+	if _, err := backend.Respond(session, msg2); err != ErrorUnknownSession {
+		t.Fatal("answered an expired session:", err)
+	}
+
+	if err := signWithBackend(t, backend, sk.GetPublicKey(), info, []byte("message")); err != nil {
+		t.Fatal("local signer failed after expiry:", err)
+	}
+	if len(backend.sessions) != 0 {
+		t.Fatal("sessions were not ended")
+	}
+}
+
+func TestSocketSigner(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only checked on Linux")
+	}
+
+	dir, err := ioutil.TempDir("", "pblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "signerd.sock")
+	listener, err := ListenSigner(path)
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	defer listener.Close()
+
+	sk, _ := NewSecretKey(elliptic.P384())
+	info, _ := CompressInfo(elliptic.P384(), []byte("info"))
+
+	server := &SignerServer{
+		Backend: NewLocalSigner(func() (KeyBackend, error) { return sk, nil }),
+		Authorize: func(peer PeerCredentials) error {
+			if peer.UID != os.Getuid() || peer.PID != os.Getpid() {
+				return ErrorPeerNotAllowed
+			}
+			return nil
+		},
+	}
+	go server.Serve(listener)
+
+	backend := SocketSigner{Path: path}
+
+	if err = signWithBackend(t, backend, sk.GetPublicKey(), info, []byte("message")); err != nil {
+		t.Fatal("socket signer failed:", err)
+	}
+
+	msg2 := Message2{Curve: elliptic.P384(), E: big.NewInt(1)}
+	if _, err = backend.Respond(make([]byte, sessionIDSize), msg2); err != ErrorUnknownSession {
+		t.Fatal("answered an unknown session:", err)
+	}
+
+	// peers that fail Authorize are refused
+
+	refusingPath := filepath.Join(dir, "refusing.sock")
+	refusing, err := ListenSigner(refusingPath)
+	if err != nil {
+		t.Fatal("failed to listen:", err)
+	}
+	defer refusing.Close()
+
+	go (&SignerServer{
+		Backend:   server.Backend,
+		Authorize: func(peer PeerCredentials) error { return ErrorPeerNotAllowed },
+	}).Serve(refusing)
+
+	if _, _, err = (SocketSigner{Path: refusingPath}).Begin(info); err != ErrorPeerNotAllowed {
+		t.Fatal("served a peer that is not allowed:", err)
+	}
+}
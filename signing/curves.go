@@ -0,0 +1,155 @@
+package signing
+
+import (
+	"crypto/elliptic"
+	"encoding/asn1"
+	"math/big"
+)
+
+type curveEntry struct {
+	ID    byte
+	Name  string
+	OID   asn1.ObjectIdentifier
+	Curve elliptic.Curve
+}
+
+// curveRegistry lists the curves with a stable identifier and name in
+// encoded data. Identifiers and names must never be reused.
+var curveRegistry = []curveEntry{
+	{ID: 1, Name: "P-224", OID: asn1.ObjectIdentifier{1, 3, 132, 0, 33}, Curve: elliptic.P224()},
+	{ID: 2, Name: "P-256", OID: asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}, Curve: elliptic.P256()},
+	{ID: 3, Name: "P-384", OID: asn1.ObjectIdentifier{1, 3, 132, 0, 34}, Curve: elliptic.P384()},
+	{ID: 4, Name: "P-521", OID: asn1.ObjectIdentifier{1, 3, 132, 0, 35}, Curve: elliptic.P521()},
+}
+
+func lookupCurve(curve elliptic.Curve) (*curveEntry, error) {
+	if curve == nil {
+		return nil, ErrorUnknownCurve
+	}
+
+	return lookupCurveName(curve.Params().Name)
+}
+
+func lookupCurveOID(oid asn1.ObjectIdentifier) (*curveEntry, error) {
+	for i := range curveRegistry {
+		if curveRegistry[i].OID.Equal(oid) {
+			return &curveRegistry[i], nil
+		}
+	}
+
+	return nil, ErrorUnknownCurve
+}
+
+func lookupCurveName(name string) (*curveEntry, error) {
+	for i := range curveRegistry {
+		if curveRegistry[i].Name == name {
+			return &curveRegistry[i], nil
+		}
+	}
+
+	return nil, ErrorUnknownCurve
+}
+
+// curveName is the registry name of curve, or "" if curve is nil.
+func curveName(curve elliptic.Curve) (string, error) {
+	if curve == nil {
+		return "", nil
+	}
+
+	entry, err := lookupCurve(curve)
+	if err != nil {
+		return "", err
+	}
+
+	return entry.Name, nil
+}
+
+// curveFromName reverses curveName.
+func curveFromName(name string) (elliptic.Curve, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	entry, err := lookupCurveName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.Curve, nil
+}
+
+func lookupCurveID(id byte) (*curveEntry, error) {
+	for i := range curveRegistry {
+		if curveRegistry[i].ID == id {
+			return &curveRegistry[i], nil
+		}
+	}
+
+	return nil, ErrorUnknownCurve
+}
+
+func scalarSize(curve elliptic.Curve) int {
+	return (curve.Params().N.BitLen() + 7) / 8
+}
+
+func fieldSize(curve elliptic.Curve) int {
+	return (curve.Params().P.BitLen() + 7) / 8
+}
+
+// marshalCompressed encodes a point in SEC1 compressed form.
+func marshalCompressed(curve elliptic.Curve, x, y *big.Int) []byte {
+	size := fieldSize(curve)
+	buff := make([]byte, 1+size)
+	buff[0] = byte(2 + y.Bit(0))
+	putFixed(buff[1:], x)
+	return buff
+}
+
+// putFixed writes x big-endian into buff, left padded with zeros.
+func putFixed(buff []byte, x *big.Int) {
+	raw := x.Bytes()
+	copy(buff[len(buff)-len(raw):], raw)
+}
+
+func fromFixed(buff []byte) *big.Int {
+	return new(big.Int).SetBytes(buff)
+}
+
+// unmarshalCompressed decodes a SEC1 compressed point, rejecting encodings
+// that are not canonical or not on the curve.
+func unmarshalCompressed(curve elliptic.Curve, data []byte) (*big.Int, *big.Int, error) {
+	params := curve.Params()
+
+	if len(data) != 1+fieldSize(curve) || (data[0] != 2 && data[0] != 3) {
+		return nil, nil, ErrorInvalidEncoding
+	}
+
+	x := new(big.Int).SetBytes(data[1:])
+	if x.Cmp(params.P) >= 0 {
+		return nil, nil, ErrorInvalidEncoding
+	}
+
+	// Y^2 = X^3 - 3x + B
+
+	y := new(big.Int).Mul(x, x)
+	y.Mul(y, x)
+	threeX := new(big.Int).Lsh(x, 1)
+	threeX.Add(threeX, x)
+	y.Sub(y, threeX)
+	y.Add(y, params.B)
+	y.Mod(y, params.P)
+
+	if y.ModSqrt(y, params.P) == nil {
+		return nil, nil, ErrorPointNotOnCurve
+	}
+
+	if y.Bit(0) != uint(data[0]&1) {
+		y.Sub(params.P, y)
+	}
+
+	if !curve.IsOnCurve(x, y) {
+		return nil, nil, ErrorPointNotOnCurve
+	}
+
+	return x, y, nil
+}
@@ -0,0 +1,178 @@
+package signing
+
+// Key custody.
+//
+// The signer uses its secret key for a single operation: answering the
+// challenge c of a session with nonce u by r = u - c*sk mod N. A KeyBackend
+// performs that operation, so the key does not have to be stored with the
+// signer states. A *SecretKey is the in-memory KeyBackend.
+//
+// A KeyBackend is not a custody boundary. The caller chooses u, so anyone
+// who can call Respond can recover the key: Respond(0, 1) is N - sk, and
+// any answer gives sk = (u - r)/c. Keeping a key away from a process takes
+// a backend that also generates and keeps the nonces, as the SignerBackend
+// of signerd does.
+//
+// SimulatedToken is a stand-in for a PKCS#11 token that runs locally: keys
+// are generated or imported into it, found by key ID and used only after
+// login with the user PIN. There is no call that exports them, but a caller
+// logged in to the token can compute them through Respond.
+
+import (
+	"crypto/elliptic"
+	"crypto/subtle"
+	"math/big"
+	"sync"
+)
+
+type KeyBackend interface {
+	GetPublicKey() *PublicKey
+
+	// Respond returns r = u - c*sk mod N for the session nonce u and the
+	// challenge c. Whoever supplies u learns sk from the result.
+	Respond(u *big.Int, c *big.Int) (*big.Int, error)
+}
+
+func (sk *SecretKey) Respond(u *big.Int, c *big.Int) (*big.Int, error) {
+	state := sk.state()
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+
+	if sk.Scalar.Sign() == 0 {
+		return nil, ErrorKeyDestroyed
+	}
+
+	field := scalarFieldOf(sk.Curve)
+
+	product := field.mulInt(c, sk.Scalar)
+	defer wipeInt(product)
+
+	return field.subInt(u, product), nil
+}
+
+type SimulatedToken struct {
+	mutex    sync.Mutex
+	pin      []byte
+	loggedIn bool
+	objects  map[string]*SecretKey // by key ID
+}
+
+type tokenKey struct {
+	token *SimulatedToken
+	keyID []byte
+	pk    *PublicKey
+}
+
+func NewSimulatedToken(pin []byte) *SimulatedToken {
+	return &SimulatedToken{pin: append([]byte{}, pin...), objects: make(map[string]*SecretKey)}
+}
+
+func (token *SimulatedToken) Login(pin []byte) error {
+	token.mutex.Lock()
+	defer token.mutex.Unlock()
+
+	if subtle.ConstantTimeCompare(pin, token.pin) != 1 {
+		return ErrorInvalidPIN
+	}
+
+	token.loggedIn = true
+
+	return nil
+}
+
+func (token *SimulatedToken) Logout() {
+	token.mutex.Lock()
+	defer token.mutex.Unlock()
+
+	token.loggedIn = false
+}
+
+// GenerateKey generates a key on the token.
+func (token *SimulatedToken) GenerateKey(curve elliptic.Curve) (KeyBackend, error) {
+	sk, err := NewSecretKey(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return token.store(sk)
+}
+
+// ImportKey copies sk onto the token. The caller should discard sk.
+func (token *SimulatedToken) ImportKey(sk *SecretKey) (KeyBackend, error) {
+	scalar := newSecretInt(sk.Curve).Set(sk.Scalar)
+	return token.store(&SecretKey{Curve: sk.Curve, Scalar: scalar, shared: newKeyState()})
+}
+
+func (token *SimulatedToken) store(sk *SecretKey) (KeyBackend, error) {
+	pk := sk.GetPublicKey()
+
+	keyID, err := pk.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+
+	token.mutex.Lock()
+	defer token.mutex.Unlock()
+
+	if !token.loggedIn {
+		return nil, ErrorNotLoggedIn
+	}
+
+	token.objects[string(keyID)] = sk
+
+	return tokenKey{token: token, keyID: keyID, pk: pk}, nil
+}
+
+// FindKey returns the key on the token with keyID. It can be passed to
+// LoadSigner.
+func (token *SimulatedToken) FindKey(keyID []byte) (KeyBackend, error) {
+	token.mutex.Lock()
+	defer token.mutex.Unlock()
+
+	sk, ok := token.objects[string(keyID)]
+	if !ok {
+		return nil, ErrorUnknownKey
+	}
+
+	return tokenKey{token: token, keyID: keyID, pk: sk.GetPublicKey()}, nil
+}
+
+// DestroyKey deletes the key with keyID from the token.
+func (token *SimulatedToken) DestroyKey(keyID []byte) error {
+	token.mutex.Lock()
+	defer token.mutex.Unlock()
+
+	if !token.loggedIn {
+		return ErrorNotLoggedIn
+	}
+
+	sk, ok := token.objects[string(keyID)]
+	if !ok {
+		return ErrorUnknownKey
+	}
+
+	sk.Destroy()
+	delete(token.objects, string(keyID))
+
+	return nil
+}
+
+func (key tokenKey) GetPublicKey() *PublicKey {
+	return key.pk
+}
+
+func (key tokenKey) Respond(u *big.Int, c *big.Int) (*big.Int, error) {
+	key.token.mutex.Lock()
+	defer key.token.mutex.Unlock()
+
+	if !key.token.loggedIn {
+		return nil, ErrorNotLoggedIn
+	}
+
+	sk, ok := key.token.objects[string(key.keyID)]
+	if !ok {
+		return nil, ErrorUnknownKey
+	}
+
+	return sk.Respond(u, c)
+}
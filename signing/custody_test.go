@@ -0,0 +1,146 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSimulatedToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	signerFile := filepath.Join(dir, "signer")
+
+	token := NewSimulatedToken([]byte("1234"))
+	info, _ := CompressInfo(elliptic.P256(), []byte("info"))
+	message := []byte("message")
+
+	if _, err = token.GenerateKey(elliptic.P256()); err != ErrorNotLoggedIn {
+		t.Fatal("generated a key before login:", err)
+	}
+	if err = token.Login([]byte("0000")); err != ErrorInvalidPIN {
+		t.Fatal("accepted a wrong PIN:", err)
+	}
+	if err = token.Login([]byte("1234")); err != nil {
+		t.Fatal("failed to log in:", err)
+	}
+
+	key, err := token.GenerateKey(elliptic.P256())
+	if err != nil {
+		t.Fatal("failed to generate key:", err)
+	}
+	pk := key.GetPublicKey()
+
+	requester, _ := CreateRequester(pk, info, message)
+	signer, err := CreateSignerWithBackend(key, info)
+	if err != nil {
+		t.Fatal("failed to create signer:", err)
+	}
+
+	msg1, _ := signer.CreateMessage1()
+	requester.ProcessMessage1(msg1)
+	msg2, _ := requester.CreateMessage2()
+	signer.ProcessMessage2(msg2)
+
+	// the saved state holds no key and finds it on the token again
+
+	if err = signer.Save(signerFile); err != nil {
+		t.Fatal("failed to save signer:", err)
+	}
+
+	other, _ := NewSecretKey(elliptic.P256())
+	if _, err = LoadSigner(signerFile, func(keyID []byte) (KeyBackend, error) { return other, nil }); err != ErrorInvalidKeyID {
+		t.Fatal("attached the wrong key:", err)
+	}
+
+	if signer, err = LoadSigner(signerFile, token.FindKey); err != nil {
+		t.Fatal("failed to load signer:", err)
+	}
+
+	token.Logout()
+	if _, err = signer.CreateMessage3(); err != ErrorNotLoggedIn {
+		t.Fatal("used the key after logout:", err)
+	}
+	token.Login([]byte("1234"))
+
+	msg3, err := signer.CreateMessage3()
+	if err != nil {
+		t.Fatal("failed to create msg3:", err)
+	}
+	if err = requester.ProcessMessage3(msg3); err != nil {
+		t.Fatal("failed to process msg3:", err)
+	}
+
+	sig, _ := requester.Signature()
+	if !pk.Check(sig, info, message) {
+		t.Fatal("token signature does not verify")
+	}
+
+	keyID, _ := pk.Fingerprint()
+	if err = token.DestroyKey(keyID); err != nil {
+		t.Fatal("failed to destroy key:", err)
+	}
+	if _, err = token.FindKey(keyID); err != ErrorUnknownKey {
+		t.Fatal("found a destroyed key:", err)
+	}
+}
+
+// savedWithKey encodes a signer state as it was saved before key backends.
+type savedWithKey gobStateSigner
+
+func (st savedWithKey) GobEncode() ([]byte, error) {
+	return gobEncode(gobStateSigner(st))
+}
+
+func TestSignerStateWithoutKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	signerFile := filepath.Join(dir, "signer")
+
+	sk, _ := NewSecretKey(elliptic.P256())
+	info, _ := CompressInfo(elliptic.P256(), []byte("info"))
+	signer, _ := CreateSigner(*sk, info)
+	signer.CreateMessage1()
+
+	if err = signer.Save(signerFile); err != nil {
+		t.Fatal("failed to save signer:", err)
+	}
+
+	data, _ := ioutil.ReadFile(signerFile)
+	if bytes.Contains(data, sk.Scalar.Bytes()) {
+		t.Fatal("signer state holds the secret key")
+	}
+
+	// states saved before key backends still bring their key
+
+	name, _ := curveName(signer.Curve)
+	data, _ = gobEncode(savedWithKey{
+		State: signer.State,
+		Info:  signer.Info,
+		Curve: name,
+		Sk:    sk,
+		U:     signer.U,
+		S:     signer.S,
+		D:     signer.D,
+	})
+	ioutil.WriteFile(signerFile, data, 0600)
+
+	loaded, err := LoadSigner(signerFile, nil)
+	if err != nil {
+		t.Fatal("failed to load old signer state:", err)
+	}
+	if loaded.Key == nil || loaded.Key.GetPublicKey().X.Cmp(sk.GetPublicKey().X) != 0 {
+		t.Fatal("old signer state lost its key")
+	}
+}
@@ -0,0 +1,310 @@
+package signing
+
+// ASN.1 DER profile, defined by the module in schema/pblind.asn.
+//
+// Keys carry the pblind algorithm and curve OIDs. Messages and signatures do
+// not, their curve is that of the signer key, so the parsers take the curve.
+// Parsers accept only DER: the input must re-encode to the same bytes.
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/asn1"
+	"math/big"
+	"reflect"
+)
+
+// oidPblindAO identifies Abe-Okamoto signatures in the provisional pblind arc.
+var oidPblindAO = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 32473, 1, 1}
+
+type derAlgorithm struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.ObjectIdentifier
+}
+
+type derPublicKey struct {
+	Algorithm derAlgorithm
+	PublicKey asn1.BitString
+}
+
+type derPrivateKey struct {
+	Version    int
+	Algorithm  derAlgorithm
+	PrivateKey []byte
+}
+
+type derMessage1 struct {
+	A, B []byte
+}
+
+type derMessage2 struct {
+	E *big.Int
+}
+
+type derMessage3 struct {
+	R, C, S *big.Int
+}
+
+type derSignature struct {
+	P, W, O, G *big.Int
+}
+
+// unmarshalDER decodes data into value, a pointer to a der struct, and
+// rejects trailing data and any encoding other than DER.
+func unmarshalDER(data []byte, value interface{}) error {
+	rest, err := asn1.Unmarshal(data, value)
+	if err != nil || len(rest) != 0 {
+		return ErrorInvalidEncoding
+	}
+
+	again, err := asn1.Marshal(reflect.ValueOf(value).Elem().Interface())
+	if err != nil || !bytes.Equal(again, data) {
+		return ErrorInvalidEncoding
+	}
+
+	return nil
+}
+
+func derAlgorithmFor(curve elliptic.Curve) (derAlgorithm, error) {
+	entry, err := lookupCurve(curve)
+	if err != nil {
+		return derAlgorithm{}, err
+	}
+
+	return derAlgorithm{Algorithm: oidPblindAO, Parameters: entry.OID}, nil
+}
+
+func (algorithm derAlgorithm) curve() (elliptic.Curve, error) {
+	if !algorithm.Algorithm.Equal(oidPblindAO) {
+		return nil, ErrorInvalidEncoding
+	}
+
+	entry, err := lookupCurveOID(algorithm.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.Curve, nil
+}
+
+func checkScalars(curve elliptic.Curve, scalars ...*big.Int) error {
+	for _, scalar := range scalars {
+		if scalar == nil || scalar.Sign() < 0 || scalar.Cmp(curve.Params().N) >= 0 {
+			return ErrorInvalidEncoding
+		}
+	}
+
+	return nil
+}
+
+func (pk PublicKey) MarshalDER() ([]byte, error) {
+	algorithm, err := derAlgorithmFor(pk.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	point, err := appendPoint(nil, pk.Curve, pk.X, pk.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(derPublicKey{
+		Algorithm: algorithm,
+		PublicKey: asn1.BitString{Bytes: point, BitLength: 8 * len(point)},
+	})
+}
+
+func ParsePublicKeyDER(data []byte) (*PublicKey, error) {
+	var decoded derPublicKey
+	if err := unmarshalDER(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	curve, err := decoded.Algorithm.curve()
+	if err != nil {
+		return nil, err
+	}
+
+	if decoded.PublicKey.BitLength != 8*len(decoded.PublicKey.Bytes) {
+		return nil, ErrorInvalidEncoding
+	}
+
+	x, y, err := unmarshalCompressed(curve, decoded.PublicKey.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func (sk SecretKey) MarshalDER() ([]byte, error) {
+	algorithm, err := derAlgorithmFor(sk.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	scalar, err := appendScalar(nil, sk.Curve, sk.Scalar)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(derPrivateKey{Version: 0, Algorithm: algorithm, PrivateKey: scalar})
+}
+
+func ParseSecretKeyDER(data []byte) (*SecretKey, error) {
+	var decoded derPrivateKey
+	if err := unmarshalDER(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	if decoded.Version != 0 {
+		return nil, ErrorInvalidEncoding
+	}
+
+	curve, err := decoded.Algorithm.curve()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(decoded.PrivateKey) != scalarSize(curve) {
+		return nil, ErrorInvalidEncoding
+	}
+
+	scalar, _, err := readScalar(curve, decoded.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	fixSecretInt(curve, scalar)
+	return &SecretKey{Curve: curve, Scalar: scalar, shared: newKeyState()}, nil
+}
+
+func (msg Message1) MarshalDER() ([]byte, error) {
+	if msg.Curve == nil {
+		return nil, ErrorUnknownCurve
+	}
+
+	a, err := appendPoint(nil, msg.Curve, msg.Ax, msg.Ay)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := appendPoint(nil, msg.Curve, msg.Bx, msg.By)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(derMessage1{A: a, B: b})
+}
+
+func ParseMessage1DER(curve elliptic.Curve, data []byte) (*Message1, error) {
+	if curve == nil {
+		return nil, ErrorUnknownCurve
+	}
+
+	var decoded derMessage1
+	if err := unmarshalDER(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	msg := Message1{Curve: curve}
+
+	var err error
+
+	if msg.Ax, msg.Ay, err = unmarshalCompressed(curve, decoded.A); err != nil {
+		return nil, err
+	}
+
+	if msg.Bx, msg.By, err = unmarshalCompressed(curve, decoded.B); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+func (msg Message2) MarshalDER() ([]byte, error) {
+	if msg.Curve == nil {
+		return nil, ErrorUnknownCurve
+	}
+
+	if err := checkScalars(msg.Curve, msg.E); err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(derMessage2{E: msg.E})
+}
+
+func ParseMessage2DER(curve elliptic.Curve, data []byte) (*Message2, error) {
+	if curve == nil {
+		return nil, ErrorUnknownCurve
+	}
+
+	var decoded derMessage2
+	if err := unmarshalDER(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	if err := checkScalars(curve, decoded.E); err != nil {
+		return nil, err
+	}
+
+	return &Message2{Curve: curve, E: decoded.E}, nil
+}
+
+func (msg Message3) MarshalDER() ([]byte, error) {
+	if msg.Curve == nil {
+		return nil, ErrorUnknownCurve
+	}
+
+	if err := checkScalars(msg.Curve, msg.R, msg.C, msg.S); err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(derMessage3{R: msg.R, C: msg.C, S: msg.S})
+}
+
+func ParseMessage3DER(curve elliptic.Curve, data []byte) (*Message3, error) {
+	if curve == nil {
+		return nil, ErrorUnknownCurve
+	}
+
+	var decoded derMessage3
+	if err := unmarshalDER(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	if err := checkScalars(curve, decoded.R, decoded.C, decoded.S); err != nil {
+		return nil, err
+	}
+
+	return &Message3{Curve: curve, R: decoded.R, C: decoded.C, S: decoded.S}, nil
+}
+
+func (sig Signature) MarshalDER() ([]byte, error) {
+	if sig.Curve == nil {
+		return nil, ErrorUnknownCurve
+	}
+
+	if err := checkScalars(sig.Curve, sig.P, sig.W, sig.O, sig.G); err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(derSignature{P: sig.P, W: sig.W, O: sig.O, G: sig.G})
+}
+
+func ParseSignatureDER(curve elliptic.Curve, data []byte) (*Signature, error) {
+	if curve == nil {
+		return nil, ErrorUnknownCurve
+	}
+
+	var decoded derSignature
+	if err := unmarshalDER(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	if err := checkScalars(curve, decoded.P, decoded.W, decoded.O, decoded.G); err != nil {
+		return nil, err
+	}
+
+	return &Signature{Curve: curve, P: decoded.P, W: decoded.W, O: decoded.O, G: decoded.G}, nil
+}
@@ -0,0 +1,155 @@
+package signing
+
+// Deterministic key derivation.
+//
+// DeriveSecretKey is the deterministic counterpart of NewSecretKey: the same
+// seed, curve and path always give the same key, so keys can be rebuilt from
+// a backed-up seed and tests can use fixed keys. Keys for epochs or tenants
+// come from the same seed under different paths, e.g. "tenant/acme/epoch/3".
+//
+// The scalar is HKDF-SHA512 output under a fixed salt, with the curve name
+// and the path as info, 128 bits longer than the curve order and reduced
+// into [1, N-1] as in FIPS 186-4, appendix B.4.1.
+//
+// Seeds can be written down as mnemonics in the style of BIP 39: the seed
+// bytes are the entropy, followed by a checksum of the first bits of their
+// SHA-256 hash, in 11 bit words from the BIP 39 English word list. Unlike
+// BIP 39 the seed is the entropy itself, not a PBKDF2 hash of the words, so
+// a mnemonic gives different keys here than in a wallet.
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"io"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const derivationSalt = "pblind key derivation v1"
+
+const (
+	minSeedSize = 16
+	maxSeedSize = 32
+)
+
+// NewSeed returns a random seed of the largest mnemonic size.
+func NewSeed() ([]byte, error) {
+	seed := make([]byte, maxSeedSize)
+	_, err := rand.Read(seed)
+	return seed, err
+}
+
+func DeriveSecretKey(curve elliptic.Curve, seed []byte, path string) (*SecretKey, error) {
+	if len(seed) < minSeedSize {
+		return nil, ErrorInvalidSeed
+	}
+
+	entry, err := lookupCurve(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	info := appendLengthPrefixed(nil, []byte(entry.Name))
+	info = appendLengthPrefixed(info, []byte(path))
+
+	reader := hkdf.New(sha512.New, seed, []byte(derivationSalt), info)
+
+	buff := make([]byte, scalarSize(curve)+16)
+	defer wipeBytes(buff)
+
+	if _, err = io.ReadFull(reader, buff); err != nil {
+		return nil, err
+	}
+
+	nMinusOne := new(big.Int).Sub(curve.Params().N, big.NewInt(1))
+	reduced := newScalarField(nMinusOne)
+
+	scalar := reduced.int(reduced.reduce(buff))
+	defer wipeInt(scalar)
+
+	scalar = scalarFieldOf(curve).addInt(scalar, big.NewInt(1))
+
+	return &SecretKey{Curve: curve, Scalar: scalar, shared: newKeyState()}, nil
+}
+
+// EncodeMnemonic writes a seed of 16, 20, 24, 28 or 32 bytes as 12 to 24
+// words.
+func EncodeMnemonic(seed []byte) (string, error) {
+	if len(seed) < minSeedSize || len(seed) > maxSeedSize || len(seed)%4 != 0 {
+		return "", ErrorInvalidSeed
+	}
+
+	hash := sha256.Sum256(seed)
+	checksumBits := len(seed) * 8 / 32
+
+	bits := new(big.Int).SetBytes(seed)
+	bits.Lsh(bits, uint(checksumBits))
+	bits.Or(bits, big.NewInt(int64(hash[0]>>(8-uint(checksumBits)))))
+
+	count := (len(seed)*8 + checksumBits) / 11
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+
+	for index := count - 1; index >= 0; index-- {
+		words[index] = mnemonicWords[new(big.Int).And(bits, mask).Int64()]
+		bits.Rsh(bits, 11)
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// DecodeMnemonic returns the seed written as mnemonic, checking its
+// checksum.
+func DecodeMnemonic(mnemonic string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, ErrorInvalidMnemonic
+	}
+
+	bits := new(big.Int)
+	for _, word := range words {
+		index := mnemonicIndex(word)
+		if index < 0 {
+			return nil, ErrorInvalidMnemonic
+		}
+
+		bits.Lsh(bits, 11)
+		bits.Or(bits, big.NewInt(int64(index)))
+	}
+
+	checksumBits := len(words) * 11 / 33
+	size := checksumBits * 4
+
+	checksum := new(big.Int).And(bits, big.NewInt(int64(1)<<uint(checksumBits)-1))
+	bits.Rsh(bits, uint(checksumBits))
+
+	seed := make([]byte, size)
+	putFixed(seed, bits)
+
+	hash := sha256.Sum256(seed)
+	if checksum.Int64() != int64(hash[0]>>(8-uint(checksumBits))) {
+		return nil, ErrorInvalidMnemonic
+	}
+
+	return seed, nil
+}
+
+func mnemonicIndex(word string) int {
+	low, high := 0, len(mnemonicWords)
+	for low < high {
+		middle := (low + high) / 2
+		switch {
+		case mnemonicWords[middle] == word:
+			return middle
+		case mnemonicWords[middle] < word:
+			low = middle + 1
+		default:
+			high = middle
+		}
+	}
+	return -1
+}
@@ -0,0 +1,113 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func TestDeriveSecretKey(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, 32)
+
+	for _, curve := range []elliptic.Curve{elliptic.P224(), elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		a, err := DeriveSecretKey(curve, seed, "epoch/1")
+		if err != nil {
+			t.Fatal("failed to derive key:", err)
+		}
+		b, _ := DeriveSecretKey(curve, seed, "epoch/1")
+		c, _ := DeriveSecretKey(curve, seed, "epoch/2")
+
+		if a.Scalar.Cmp(b.Scalar) != 0 {
+			t.Error("derivation is not deterministic")
+		}
+		if a.Scalar.Cmp(c.Scalar) == 0 {
+			t.Error("paths derive the same key")
+		}
+		if a.Scalar.Sign() <= 0 || a.Scalar.Cmp(curve.Params().N) >= 0 {
+			t.Error("derived scalar out of range")
+		}
+	}
+
+	p256, _ := DeriveSecretKey(elliptic.P256(), seed, "epoch/1")
+	p384, _ := DeriveSecretKey(elliptic.P384(), seed, "epoch/1")
+	if p256.Scalar.Cmp(new(big.Int).Mod(p384.Scalar, elliptic.P256().Params().N)) == 0 {
+		t.Error("curves share derived keys")
+	}
+
+	if _, err := DeriveSecretKey(elliptic.P256(), seed[:8], ""); err != ErrorInvalidSeed {
+		t.Error("accepted short seed:", err)
+	}
+}
+
+func TestDeriveSecretKeyVectors(t *testing.T) {
+	// computed with an independent HKDF-SHA512 implementation from the
+	// construction in derive.go, for the seed 00 01 02 ... 1f
+	vectors := []struct {
+		curve  elliptic.Curve
+		path   string
+		scalar string
+	}{
+		{elliptic.P224(), "", "72a7544d628dffe8cbb32ba725c43901769be4ce34c947cf962e4a2d"},
+		{elliptic.P224(), "tenant/acme/epoch/3", "e9a35a467e9fff960ed65a418397fba6912414fbce6705e113583344"},
+		{elliptic.P256(), "", "a894a018fdb3bda0e4c352b85293d42b9c103d9bcdc79ea33f6488fd86393b42"},
+		{elliptic.P256(), "tenant/acme/epoch/3", "3f1958045512d1c138fcbdb54af490067e7ce7d8722ecdf2e4a2b1873bf537ca"},
+		{elliptic.P384(), "", "ce0abe30fc81fc3a6dbc17cb4cf2d508f6189d234eaf4b388b8ddce511bad0eb1f788f4e99fc44077797c658c48d0214"},
+		{elliptic.P384(), "tenant/acme/epoch/3", "ac3a8d993187c8cc4e9050a2a4624ae2d5c9f46e50ed7a26fe6a1b8a8156c009f0661c2c6cd33b4c9879896944efac98"},
+		{elliptic.P521(), "", "0145a0e0b0f142480c78ac4febb15881ed896ad94cbf76d368c05f1990ff049f573c089ab8bff52aabc71a9b518e19eeec0f3c7c754feee33397da0c7142a4c839e2"},
+		{elliptic.P521(), "tenant/acme/epoch/3", "01e9bae3a318cf8a97b822913306d49b7b303c93b0c758533458341e8da80766aa87adb9095af98f4b2f7bc6d2877305668a1fe12c50c154162a35934438ec58f863"},
+	}
+
+	seed := make([]byte, 32)
+	for index := range seed {
+		seed[index] = byte(index)
+	}
+
+	for _, vector := range vectors {
+		sk, err := DeriveSecretKey(vector.curve, seed, vector.path)
+		if err != nil {
+			t.Fatal("failed to derive key:", err)
+		}
+
+		scalar := hex.EncodeToString(scalarFieldOf(vector.curve).bytesInt(sk.Scalar))
+		if scalar != vector.scalar {
+			t.Errorf("wrong %s key for path %q: %s", vector.curve.Params().Name, vector.path, scalar)
+		}
+	}
+}
+
+func TestMnemonic(t *testing.T) {
+	// test vectors of BIP 39
+	vectors := []struct {
+		entropy  string
+		mnemonic string
+	}{
+		{"00000000000000000000000000000000", "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"},
+		{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f", "legal winner thank year wave sausage worth useful legal winner thank yellow"},
+		{"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", "zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote"},
+		{"9e885d952ad362caeb4efe34a8e91bd2", "ozone drill grab fiber curtain grace pudding thank cruise elder eight picnic"},
+	}
+
+	for _, vector := range vectors {
+		entropy, _ := hex.DecodeString(vector.entropy)
+
+		mnemonic, err := EncodeMnemonic(entropy)
+		if err != nil || mnemonic != vector.mnemonic {
+			t.Error("wrong mnemonic for", vector.entropy, mnemonic, err)
+		}
+
+		decoded, err := DecodeMnemonic(vector.mnemonic)
+		if err != nil || !bytes.Equal(decoded, entropy) {
+			t.Error("wrong seed for", vector.mnemonic, err)
+		}
+	}
+
+	if _, err := DecodeMnemonic("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon"); err != ErrorInvalidMnemonic {
+		t.Error("accepted mnemonic with bad checksum:", err)
+	}
+
+	if _, err := DecodeMnemonic("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandonx"); err != ErrorInvalidMnemonic {
+		t.Error("accepted unknown word:", err)
+	}
+}
@@ -0,0 +1,599 @@
+package signing
+
+// Offline e-cash in the style of Chaum-Fiat-Naor:
+// https://link.springer.com/content/pdf/10.1007/0-387-34799-2_25.pdf
+//
+// A coin is a list of commitment pairs (L_i, R_i) to the values
+// (x_i, x_i XOR identity). Spending a coin opens one side of every pair as
+// selected by a merchant challenge, which reveals nothing about the identity.
+// Two spends of the same coin answer different challenges, so some pair is
+// opened on both sides and XORing the openings yields the identity.
+//
+// The signer cannot see the coin it signs, so withdrawal uses cut-and-choose:
+// the requester blinds several candidate coins, the signer picks one to keep
+// and the requester opens all others, which the signer checks carry the
+// requester's identity before answering the kept session.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"math/big"
+)
+
+const (
+	CoinPairs             = 32 // commitment pairs per coin, one challenge bit each
+	DefaultCoinCandidates = 16 // cut-and-choose candidates per withdrawal
+)
+
+const (
+	coinNonceSize      = 32
+	coinCommitmentSize = sha256.Size
+	coinChallengeSize  = CoinPairs / 8
+)
+
+var coinMagic = []byte("PBLIND-COIN-V1")
+
+const (
+	stateCoinFresh = iota
+	stateCoinMsg1Done
+	stateCoinMsg2Done
+	stateCoinOpened
+	stateCoinMsg3Done
+)
+
+type Coin struct {
+	Left  [][]byte // commitments to the pads
+	Right [][]byte // commitments to the pads XOR identity
+}
+
+type CoinSecret struct {
+	Identity   []byte
+	Left       [][]byte
+	Right      [][]byte
+	LeftNonce  [][]byte
+	RightNonce [][]byte
+}
+
+type CoinReveal struct {
+	Index  int
+	T1     *big.Int
+	T2     *big.Int
+	T3     *big.Int
+	T4     *big.Int
+	Secret CoinSecret
+}
+
+type SpendTranscript struct {
+	Coin      Coin
+	Signature Signature
+	Challenge []byte   // CoinPairs bits, see SpendChallenge
+	Values    [][]byte // opened values, one per pair
+	Nonces    [][]byte // commitment nonces for the opened values
+}
+
+type StateCoinRequester struct {
+	State      int
+	Identity   []byte
+	Secrets    []*CoinSecret
+	Requesters []*StateRequester
+	Kept       int
+}
+
+type StateCoinSigner struct {
+	State    int
+	Identity []byte
+	Signers  []*StateSigner
+	Kept     int
+}
+
+func coinCommit(value []byte, nonce []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("PBLIND-COIN-COMMITMENT"))
+	h.Write(nonce)
+	h.Write(value)
+	return h.Sum(nil)
+}
+
+func randomBytes(size int) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func xorBytes(a []byte, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+func challengeBit(challenge []byte, i int) int {
+	return int(challenge[i/8]>>uint(i%8)) & 1
+}
+
+func CreateCoinSecret(identity []byte) (*CoinSecret, error) {
+	secret := CoinSecret{
+		Identity:   append([]byte{}, identity...),
+		Left:       make([][]byte, CoinPairs),
+		Right:      make([][]byte, CoinPairs),
+		LeftNonce:  make([][]byte, CoinPairs),
+		RightNonce: make([][]byte, CoinPairs),
+	}
+
+	var err error
+
+	for i := 0; i < CoinPairs; i++ {
+		if secret.Left[i], err = randomBytes(len(identity)); err != nil {
+			return nil, err
+		}
+
+		secret.Right[i] = xorBytes(secret.Left[i], identity)
+
+		if secret.LeftNonce[i], err = randomBytes(coinNonceSize); err != nil {
+			return nil, err
+		}
+
+		if secret.RightNonce[i], err = randomBytes(coinNonceSize); err != nil {
+			return nil, err
+		}
+	}
+
+	return &secret, nil
+}
+
+func (secret *CoinSecret) Coin() Coin {
+	coin := Coin{
+		Left:  make([][]byte, CoinPairs),
+		Right: make([][]byte, CoinPairs),
+	}
+
+	for i := 0; i < CoinPairs; i++ {
+		coin.Left[i] = coinCommit(secret.Left[i], secret.LeftNonce[i])
+		coin.Right[i] = coinCommit(secret.Right[i], secret.RightNonce[i])
+	}
+
+	return coin
+}
+
+// validate checks that every pair of the secret carries identity.
+func (secret *CoinSecret) validate(identity []byte) bool {
+	if len(secret.Left) != CoinPairs || len(secret.Right) != CoinPairs ||
+		len(secret.LeftNonce) != CoinPairs || len(secret.RightNonce) != CoinPairs {
+		return false
+	}
+
+	if !bytes.Equal(secret.Identity, identity) {
+		return false
+	}
+
+	for i := 0; i < CoinPairs; i++ {
+		if len(secret.Left[i]) != len(identity) || len(secret.Right[i]) != len(identity) {
+			return false
+		}
+
+		if !bytes.Equal(xorBytes(secret.Left[i], secret.Right[i]), identity) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Spend opens the coin against a merchant challenge, see SpendChallenge.
+func (secret *CoinSecret) Spend(sig Signature, challenge []byte) (SpendTranscript, error) {
+	if len(challenge) != coinChallengeSize {
+		return SpendTranscript{}, ErrorInvalidSpend
+	}
+
+	transcript := SpendTranscript{
+		Coin:      secret.Coin(),
+		Signature: sig,
+		Challenge: append([]byte{}, challenge...),
+		Values:    make([][]byte, CoinPairs),
+		Nonces:    make([][]byte, CoinPairs),
+	}
+
+	for i := 0; i < CoinPairs; i++ {
+		if challengeBit(challenge, i) == 0 {
+			transcript.Values[i] = secret.Left[i]
+			transcript.Nonces[i] = secret.LeftNonce[i]
+		} else {
+			transcript.Values[i] = secret.Right[i]
+			transcript.Nonces[i] = secret.RightNonce[i]
+		}
+	}
+
+	return transcript, nil
+}
+
+func (coin Coin) Bytes() []byte {
+	buff := append([]byte{}, coinMagic...)
+	for i := range coin.Left {
+		buff = append(buff, coin.Left[i]...)
+		buff = append(buff, coin.Right[i]...)
+	}
+	return buff
+}
+
+func CoinFromBytes(data []byte) (*Coin, error) {
+	if len(data) != len(coinMagic)+2*CoinPairs*coinCommitmentSize {
+		return nil, ErrorInvalidCoin
+	}
+
+	if !bytes.Equal(data[:len(coinMagic)], coinMagic) {
+		return nil, ErrorInvalidCoin
+	}
+
+	data = data[len(coinMagic):]
+
+	coin := Coin{
+		Left:  make([][]byte, CoinPairs),
+		Right: make([][]byte, CoinPairs),
+	}
+
+	for i := 0; i < CoinPairs; i++ {
+		coin.Left[i] = append([]byte{}, data[:coinCommitmentSize]...)
+		data = data[coinCommitmentSize:]
+		coin.Right[i] = append([]byte{}, data[:coinCommitmentSize]...)
+		data = data[coinCommitmentSize:]
+	}
+
+	return &coin, nil
+}
+
+// SpendChallenge derives the challenge a merchant presents for a spend.
+// The nonce must be unique per transaction, e.g. a timestamp and a counter,
+// so that a coin spent twice is opened against two different challenges.
+func SpendChallenge(coin Coin, merchant []byte, nonce []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("PBLIND-COIN-CHALLENGE"))
+	h.Write(coin.Bytes())
+	h.Write(merchant)
+	h.Write(nonce)
+	return h.Sum(nil)[:coinChallengeSize]
+}
+
+func (transcript SpendTranscript) checkOpenings() bool {
+	coin := transcript.Coin
+
+	if len(coin.Left) != CoinPairs || len(coin.Right) != CoinPairs {
+		return false
+	}
+
+	if len(transcript.Challenge) != coinChallengeSize {
+		return false
+	}
+
+	if len(transcript.Values) != CoinPairs || len(transcript.Nonces) != CoinPairs {
+		return false
+	}
+
+	for i := 0; i < CoinPairs; i++ {
+		if len(transcript.Values[i]) != len(transcript.Values[0]) {
+			return false
+		}
+
+		commitment := coin.Left[i]
+		if challengeBit(transcript.Challenge, i) == 1 {
+			commitment = coin.Right[i]
+		}
+
+		opened := coinCommit(transcript.Values[i], transcript.Nonces[i])
+		if subtle.ConstantTimeCompare(opened, commitment) != 1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckSpend verifies the bank signature on the coin and the openings.
+// The merchant must also check that the challenge is the one it issued.
+func (pk PublicKey) CheckSpend(transcript SpendTranscript, info Info) error {
+	if !transcript.checkOpenings() {
+		return ErrorInvalidSpend
+	}
+
+	if !pk.Check(transcript.Signature, info, transcript.Coin.Bytes()) {
+		return ErrorInvalidSignature
+	}
+
+	return nil
+}
+
+// DetectDoubleSpend compares two valid spend transcripts of the same coin
+// and returns the identity of the spender if the coin was spent twice.
+func DetectDoubleSpend(first SpendTranscript, second SpendTranscript) ([]byte, error) {
+	if !first.checkOpenings() || !second.checkOpenings() {
+		return nil, ErrorInvalidSpend
+	}
+
+	if !bytes.Equal(first.Coin.Bytes(), second.Coin.Bytes()) {
+		return nil, ErrorNotDoubleSpent
+	}
+
+	for i := 0; i < CoinPairs; i++ {
+		if challengeBit(first.Challenge, i) != challengeBit(second.Challenge, i) {
+			return xorBytes(first.Values[i], second.Values[i]), nil
+		}
+	}
+
+	// same challenge answered twice: a replayed transcript, not a double spend
+
+	return nil, ErrorNotDoubleSpent
+}
+
+func CreateCoinRequester(pk *PublicKey, info Info, identity []byte, candidates int) (*StateCoinRequester, error) {
+	if candidates < 2 {
+		return nil, ErrorInvalidCoin
+	}
+
+	st := StateCoinRequester{
+		State:      stateCoinFresh,
+		Identity:   append([]byte{}, identity...),
+		Secrets:    make([]*CoinSecret, candidates),
+		Requesters: make([]*StateRequester, candidates),
+	}
+
+	for i := 0; i < candidates; i++ {
+		secret, err := CreateCoinSecret(identity)
+		if err != nil {
+			return nil, err
+		}
+
+		coin := secret.Coin()
+
+		requester, err := CreateRequester(pk, info, coin.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		st.Secrets[i] = secret
+		st.Requesters[i] = requester
+	}
+
+	return &st, nil
+}
+
+func (st *StateCoinRequester) ProcessMessage1(msgs []Message1) error {
+	if st.State != stateCoinFresh {
+		return ErrorInvalidRequesterState
+	}
+
+	if len(msgs) != len(st.Requesters) {
+		return ErrorInvalidCoin
+	}
+
+	for i, requester := range st.Requesters {
+		if err := requester.ProcessMessage1(msgs[i]); err != nil {
+			return err
+		}
+	}
+
+	st.State = stateCoinMsg1Done
+
+	return nil
+}
+
+func (st *StateCoinRequester) CreateMessage2() ([]Message2, error) {
+	if st.State != stateCoinMsg1Done {
+		return nil, ErrorInvalidRequesterState
+	}
+
+	msgs := make([]Message2, len(st.Requesters))
+
+	for i, requester := range st.Requesters {
+		msg, err := requester.CreateMessage2()
+		if err != nil {
+			return nil, err
+		}
+		msgs[i] = msg
+	}
+
+	st.State = stateCoinMsg2Done
+
+	return msgs, nil
+}
+
+// Open reveals every candidate except the one the signer chose to keep.
+func (st *StateCoinRequester) Open(kept int) ([]CoinReveal, error) {
+	if st.State != stateCoinMsg2Done {
+		return nil, ErrorInvalidRequesterState
+	}
+
+	if kept < 0 || kept >= len(st.Requesters) {
+		return nil, ErrorInvalidCoin
+	}
+
+	reveals := make([]CoinReveal, 0, len(st.Requesters)-1)
+
+	for i, requester := range st.Requesters {
+		if i == kept {
+			continue
+		}
+
+		reveals = append(reveals, CoinReveal{
+			Index:  i,
+			T1:     requester.T1,
+			T2:     requester.T2,
+			T3:     requester.T3,
+			T4:     requester.T4,
+			Secret: *st.Secrets[i],
+		})
+	}
+
+	st.Kept = kept
+	st.State = stateCoinOpened
+
+	return reveals, nil
+}
+
+func (st *StateCoinRequester) ProcessMessage3(msg Message3) error {
+	if st.State != stateCoinOpened {
+		return ErrorInvalidRequesterState
+	}
+
+	if err := st.Requesters[st.Kept].ProcessMessage3(msg); err != nil {
+		return err
+	}
+
+	st.State = stateCoinMsg3Done
+
+	return nil
+}
+
+// Coin returns the withdrawn coin secret and the signature on its Coin.
+func (st *StateCoinRequester) Coin() (*CoinSecret, Signature, error) {
+	if st.State != stateCoinMsg3Done {
+		return nil, Signature{}, ErrorInvalidRequesterState
+	}
+
+	sig, err := st.Requesters[st.Kept].Signature()
+	if err != nil {
+		return nil, Signature{}, err
+	}
+
+	return st.Secrets[st.Kept], sig, nil
+}
+
+// CreateCoinSigner starts a withdrawal for an authenticated account.
+// The identity is what DetectDoubleSpend reveals for this account.
+func CreateCoinSigner(sk SecretKey, info Info, identity []byte, candidates int) (*StateCoinSigner, error) {
+	if candidates < 2 {
+		return nil, ErrorInvalidCoin
+	}
+
+	st := StateCoinSigner{
+		State:    stateCoinFresh,
+		Identity: append([]byte{}, identity...),
+		Signers:  make([]*StateSigner, candidates),
+	}
+
+	for i := 0; i < candidates; i++ {
+		signer, err := CreateSigner(sk, info)
+		if err != nil {
+			return nil, err
+		}
+		st.Signers[i] = signer
+	}
+
+	return &st, nil
+}
+
+func (st *StateCoinSigner) CreateMessage1() ([]Message1, error) {
+	if st.State != stateCoinFresh {
+		return nil, ErrorInvalidSignerState
+	}
+
+	msgs := make([]Message1, len(st.Signers))
+
+	for i, signer := range st.Signers {
+		msg, err := signer.CreateMessage1()
+		if err != nil {
+			return nil, err
+		}
+		msgs[i] = msg
+	}
+
+	st.State = stateCoinMsg1Done
+
+	return msgs, nil
+}
+
+// ProcessMessage2 records the blinded challenges and returns the index of
+// the candidate the signer will sign; all other candidates must be opened.
+func (st *StateCoinSigner) ProcessMessage2(msgs []Message2) (int, error) {
+	if st.State != stateCoinMsg1Done {
+		return 0, ErrorInvalidSignerState
+	}
+
+	if len(msgs) != len(st.Signers) {
+		return 0, ErrorInvalidCoin
+	}
+
+	for i, signer := range st.Signers {
+		if err := signer.ProcessMessage2(msgs[i]); err != nil {
+			return 0, err
+		}
+	}
+
+	kept, err := rand.Int(rand.Reader, big.NewInt(int64(len(st.Signers))))
+	if err != nil {
+		return 0, err
+	}
+
+	st.Kept = int(kept.Int64())
+	st.State = stateCoinMsg2Done
+
+	return st.Kept, nil
+}
+
+// CheckReveals verifies that every opened candidate was blinded honestly
+// and embeds the account identity.
+func (st *StateCoinSigner) CheckReveals(reveals []CoinReveal) error {
+	if st.State != stateCoinMsg2Done {
+		return ErrorInvalidSignerState
+	}
+
+	if len(reveals) != len(st.Signers)-1 {
+		return ErrorInvalidReveal
+	}
+
+	opened := make(map[int]bool)
+
+	for _, reveal := range reveals {
+		if reveal.Index < 0 || reveal.Index >= len(st.Signers) || reveal.Index == st.Kept || opened[reveal.Index] {
+			return ErrorInvalidReveal
+		}
+		opened[reveal.Index] = true
+
+		if reveal.T1 == nil || reveal.T2 == nil || reveal.T3 == nil || reveal.T4 == nil {
+			return ErrorInvalidReveal
+		}
+
+		if !reveal.Secret.validate(st.Identity) {
+			return ErrorInvalidReveal
+		}
+
+		signer := st.Signers[reveal.Index]
+		curve := signer.Curve
+
+		// recompute the commitments sent in Message1
+
+		ax, ay := scalarBaseMult(curve, signer.U)
+		t1x, t1y := scalarMult(curve, signer.Info.X, signer.Info.Y, signer.D)
+		t2x, t2y := scalarBaseMult(curve, signer.S)
+		bx, by := curve.Add(t1x, t1y, t2x, t2y)
+
+		coin := reveal.Secret.Coin()
+		e := blindChallenge(signer.Key.GetPublicKey(), signer.Info, coin.Bytes(), ax, ay, bx, by, reveal.T1, reveal.T2, reveal.T3, reveal.T4)
+
+		if e.Cmp(signer.E) != 0 {
+			return ErrorInvalidReveal
+		}
+	}
+
+	st.State = stateCoinOpened
+
+	return nil
+}
+
+func (st *StateCoinSigner) CreateMessage3() (Message3, error) {
+	if st.State != stateCoinOpened {
+		return Message3{}, ErrorInvalidSignerState
+	}
+
+	msg, err := st.Signers[st.Kept].CreateMessage3()
+	if err != nil {
+		return Message3{}, err
+	}
+
+	st.State = stateCoinMsg3Done
+
+	return msg, nil
+}
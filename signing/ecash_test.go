@@ -0,0 +1,164 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"testing"
+)
+
+func withdrawCoin(t *testing.T, sk *SecretKey, info Info, identity []byte) (*CoinSecret, Signature) {
+	pk := sk.GetPublicKey()
+
+	requester, err := CreateCoinRequester(pk, info, identity, DefaultCoinCandidates)
+	if err != nil {
+		t.Fatal("failed to create coin requester:", err)
+	}
+
+	signer, err := CreateCoinSigner(*sk, info, identity, DefaultCoinCandidates)
+	if err != nil {
+		t.Fatal("failed to create coin signer:", err)
+	}
+
+	msg1, err := signer.CreateMessage1()
+	if err != nil {
+		t.Fatal("failed to create msg1:", err)
+	}
+
+	if err = requester.ProcessMessage1(msg1); err != nil {
+		t.Fatal("failed to process msg1:", err)
+	}
+
+	msg2, err := requester.CreateMessage2()
+	if err != nil {
+		t.Fatal("failed to create msg2:", err)
+	}
+
+	kept, err := signer.ProcessMessage2(msg2)
+	if err != nil {
+		t.Fatal("failed to process msg2:", err)
+	}
+
+	reveals, err := requester.Open(kept)
+	if err != nil {
+		t.Fatal("failed to open candidates:", err)
+	}
+
+	if err = signer.CheckReveals(reveals); err != nil {
+		t.Fatal("failed to check reveals:", err)
+	}
+
+	msg3, err := signer.CreateMessage3()
+	if err != nil {
+		t.Fatal("failed to create msg3:", err)
+	}
+
+	if err = requester.ProcessMessage3(msg3); err != nil {
+		t.Fatal("failed to process msg3:", err)
+	}
+
+	secret, sig, err := requester.Coin()
+	if err != nil {
+		t.Fatal("failed to obtain coin:", err)
+	}
+
+	return secret, sig
+}
+
+func TestDoubleSpend(t *testing.T) {
+	curve := elliptic.P256()
+	identity := []byte("account-0042")
+
+	sk, err := NewSecretKey(curve)
+	if err != nil {
+		t.Fatal("failed to generate secret key:", err)
+	}
+	pk := sk.GetPublicKey()
+
+	info, err := CompressInfo(curve, []byte("denomination=1"))
+	if err != nil {
+		t.Fatal("failed to compress Info:", err)
+	}
+
+	secret, sig := withdrawCoin(t, sk, info, identity)
+	coin := secret.Coin()
+
+	spend1, err := secret.Spend(sig, SpendChallenge(coin, []byte("merchant-a"), []byte("tx-1")))
+	if err != nil {
+		t.Fatal("failed to spend coin:", err)
+	}
+
+	if err = pk.CheckSpend(spend1, info); err != nil {
+		t.Fatal("failed to check spend:", err)
+	}
+
+	if _, err = DetectDoubleSpend(spend1, spend1); err != ErrorNotDoubleSpent {
+		t.Error("replayed transcript reported as double spend:", err)
+	}
+
+	spend2, err := secret.Spend(sig, SpendChallenge(coin, []byte("merchant-b"), []byte("tx-2")))
+	if err != nil {
+		t.Fatal("failed to spend coin:", err)
+	}
+
+	if err = pk.CheckSpend(spend2, info); err != nil {
+		t.Fatal("failed to check spend:", err)
+	}
+
+	revealed, err := DetectDoubleSpend(spend1, spend2)
+	if err != nil {
+		t.Fatal("failed to detect double spend:", err)
+	}
+
+	if !bytes.Equal(revealed, identity) {
+		t.Error("revealed wrong identity:", revealed)
+	}
+
+	// a single spend must not open both sides of any pair
+
+	for i := 0; i < CoinPairs; i++ {
+		if bytes.Equal(spend1.Values[i], identity) {
+			t.Error("single spend revealed identity")
+		}
+	}
+}
+
+func TestCoinCheating(t *testing.T) {
+	curve := elliptic.P256()
+
+	sk, err := NewSecretKey(curve)
+	if err != nil {
+		t.Fatal("failed to generate secret key:", err)
+	}
+	pk := sk.GetPublicKey()
+
+	info, err := CompressInfo(curve, []byte("denomination=1"))
+	if err != nil {
+		t.Fatal("failed to compress Info:", err)
+	}
+
+	// every candidate embeds an identity other than the account's
+
+	requester, err := CreateCoinRequester(pk, info, []byte("someone-else"), DefaultCoinCandidates)
+	if err != nil {
+		t.Fatal("failed to create coin requester:", err)
+	}
+
+	signer, err := CreateCoinSigner(*sk, info, []byte("account-0042"), DefaultCoinCandidates)
+	if err != nil {
+		t.Fatal("failed to create coin signer:", err)
+	}
+
+	msg1, _ := signer.CreateMessage1()
+	requester.ProcessMessage1(msg1)
+	msg2, _ := requester.CreateMessage2()
+	kept, _ := signer.ProcessMessage2(msg2)
+	reveals, _ := requester.Open(kept)
+
+	if err = signer.CheckReveals(reveals); err != ErrorInvalidReveal {
+		t.Error("cheating requester not detected:", err)
+	}
+
+	if _, err = signer.CreateMessage3(); err != ErrorInvalidSignerState {
+		t.Error("signer answered after failed reveal:", err)
+	}
+}
@@ -0,0 +1,279 @@
+package signing
+
+// Canonical binary encoding.
+//
+// Every value starts with a three byte header:
+//
+//	version (1) || type (1) || curve identifier (1)
+//
+// followed by fixed-width fields: points in SEC1 compressed form and scalars
+// as big-endian integers of the byte length of the curve order. Decoders
+// reject trailing data, scalars not reduced modulo the order and points not
+// on the curve, so every value has exactly one encoding.
+
+import (
+	"crypto/elliptic"
+	"math/big"
+)
+
+const encodingVersion = 1
+
+const (
+	encodingPublicKey byte = 1 + iota
+	encodingSecretKey
+	encodingMessage1
+	encodingMessage2
+	encodingMessage3
+	encodingSignature
+	encodingToken
+)
+
+func encodeHeader(kind byte, curve elliptic.Curve) ([]byte, error) {
+	entry, err := lookupCurve(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte{encodingVersion, kind, entry.ID}, nil
+}
+
+// decodeHeader checks the header and the total length of the value, which
+// is the header plus the given number of points and scalars.
+func decodeHeader(kind byte, data []byte, points int, scalars int) (elliptic.Curve, []byte, error) {
+	if len(data) < 3 || data[0] != encodingVersion || data[1] != kind {
+		return nil, nil, ErrorInvalidEncoding
+	}
+
+	entry, err := lookupCurveID(data[2])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	curve := entry.Curve
+
+	if len(data) != 3+points*(1+fieldSize(curve))+scalars*scalarSize(curve) {
+		return nil, nil, ErrorInvalidEncoding
+	}
+
+	return curve, data[3:], nil
+}
+
+func appendPoint(buff []byte, curve elliptic.Curve, x, y *big.Int) ([]byte, error) {
+	if x == nil || y == nil || !curve.IsOnCurve(x, y) {
+		return nil, ErrorPointNotOnCurve
+	}
+
+	return append(buff, marshalCompressed(curve, x, y)...), nil
+}
+
+func appendScalar(buff []byte, curve elliptic.Curve, x *big.Int) ([]byte, error) {
+	if x == nil || x.Sign() < 0 || x.Cmp(curve.Params().N) >= 0 {
+		return nil, ErrorInvalidEncoding
+	}
+
+	scalar := make([]byte, scalarSize(curve))
+	putFixed(scalar, x)
+
+	return append(buff, scalar...), nil
+}
+
+func readPoint(curve elliptic.Curve, data []byte) (*big.Int, *big.Int, []byte, error) {
+	size := 1 + fieldSize(curve)
+
+	x, y, err := unmarshalCompressed(curve, data[:size])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return x, y, data[size:], nil
+}
+
+func readScalar(curve elliptic.Curve, data []byte) (*big.Int, []byte, error) {
+	size := scalarSize(curve)
+
+	x := new(big.Int).SetBytes(data[:size])
+	if x.Cmp(curve.Params().N) >= 0 {
+		return nil, nil, ErrorInvalidEncoding
+	}
+
+	return x, data[size:], nil
+}
+
+func (pk PublicKey) MarshalBinary() ([]byte, error) {
+	buff, err := encodeHeader(encodingPublicKey, pk.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendPoint(buff, pk.Curve, pk.X, pk.Y)
+}
+
+func (pk *PublicKey) UnmarshalBinary(data []byte) error {
+	curve, rest, err := decodeHeader(encodingPublicKey, data, 1, 0)
+	if err != nil {
+		return err
+	}
+
+	x, y, _, err := readPoint(curve, rest)
+	if err != nil {
+		return err
+	}
+
+	*pk = PublicKey{Curve: curve, X: x, Y: y}
+
+	return nil
+}
+
+func (sk SecretKey) MarshalBinary() ([]byte, error) {
+	buff, err := encodeHeader(encodingSecretKey, sk.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendScalar(buff, sk.Curve, sk.Scalar)
+}
+
+func (sk *SecretKey) UnmarshalBinary(data []byte) error {
+	curve, rest, err := decodeHeader(encodingSecretKey, data, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	scalar, _, err := readScalar(curve, rest)
+	if err != nil {
+		return err
+	}
+
+	fixSecretInt(curve, scalar)
+	*sk = SecretKey{Curve: curve, Scalar: scalar, shared: newKeyState()}
+
+	return nil
+}
+
+func (msg Message1) MarshalBinary() ([]byte, error) {
+	buff, err := encodeHeader(encodingMessage1, msg.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	if buff, err = appendPoint(buff, msg.Curve, msg.Ax, msg.Ay); err != nil {
+		return nil, err
+	}
+
+	return appendPoint(buff, msg.Curve, msg.Bx, msg.By)
+}
+
+func (msg *Message1) UnmarshalBinary(data []byte) error {
+	curve, rest, err := decodeHeader(encodingMessage1, data, 2, 0)
+	if err != nil {
+		return err
+	}
+
+	var decoded Message1
+	decoded.Curve = curve
+
+	if decoded.Ax, decoded.Ay, rest, err = readPoint(curve, rest); err != nil {
+		return err
+	}
+
+	if decoded.Bx, decoded.By, _, err = readPoint(curve, rest); err != nil {
+		return err
+	}
+
+	*msg = decoded
+
+	return nil
+}
+
+func (msg Message2) MarshalBinary() ([]byte, error) {
+	buff, err := encodeHeader(encodingMessage2, msg.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return appendScalar(buff, msg.Curve, msg.E)
+}
+
+func (msg *Message2) UnmarshalBinary(data []byte) error {
+	curve, rest, err := decodeHeader(encodingMessage2, data, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	e, _, err := readScalar(curve, rest)
+	if err != nil {
+		return err
+	}
+
+	*msg = Message2{Curve: curve, E: e}
+
+	return nil
+}
+
+func (msg Message3) MarshalBinary() ([]byte, error) {
+	buff, err := encodeHeader(encodingMessage3, msg.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scalar := range []*big.Int{msg.R, msg.C, msg.S} {
+		if buff, err = appendScalar(buff, msg.Curve, scalar); err != nil {
+			return nil, err
+		}
+	}
+
+	return buff, nil
+}
+
+func (msg *Message3) UnmarshalBinary(data []byte) error {
+	curve, rest, err := decodeHeader(encodingMessage3, data, 0, 3)
+	if err != nil {
+		return err
+	}
+
+	decoded := Message3{Curve: curve}
+
+	for _, scalar := range []**big.Int{&decoded.R, &decoded.C, &decoded.S} {
+		if *scalar, rest, err = readScalar(curve, rest); err != nil {
+			return err
+		}
+	}
+
+	*msg = decoded
+
+	return nil
+}
+
+func (sig Signature) MarshalBinary() ([]byte, error) {
+	buff, err := encodeHeader(encodingSignature, sig.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scalar := range []*big.Int{sig.P, sig.W, sig.O, sig.G} {
+		if buff, err = appendScalar(buff, sig.Curve, scalar); err != nil {
+			return nil, err
+		}
+	}
+
+	return buff, nil
+}
+
+func (sig *Signature) UnmarshalBinary(data []byte) error {
+	curve, rest, err := decodeHeader(encodingSignature, data, 0, 4)
+	if err != nil {
+		return err
+	}
+
+	decoded := Signature{Curve: curve}
+
+	for _, scalar := range []**big.Int{&decoded.P, &decoded.W, &decoded.O, &decoded.G} {
+		if *scalar, rest, err = readScalar(curve, rest); err != nil {
+			return err
+		}
+	}
+
+	*sig = decoded
+
+	return nil
+}
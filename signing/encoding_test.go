@@ -0,0 +1,387 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding"
+	"encoding/asn1"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBinaryEncoding(t *testing.T) {
+
+	curves := []elliptic.Curve{
+		elliptic.P224(),
+		elliptic.P256(),
+		elliptic.P384(),
+		elliptic.P521(),
+	}
+
+	for _, curve := range curves {
+
+		sk, err := NewSecretKey(curve)
+		if err != nil {
+			t.Fatal("failed to generate secret key:", err)
+		}
+		pk := sk.GetPublicKey()
+
+		info, err := CompressInfo(curve, []byte("info"))
+		if err != nil {
+			t.Fatal("failed to compress Info:", err)
+		}
+
+		requester, _ := CreateRequester(pk, info, []byte("message"))
+		signer, _ := CreateSigner(*sk, info)
+		msg1, _ := signer.CreateMessage1()
+		requester.ProcessMessage1(msg1)
+		msg2, _ := requester.CreateMessage2()
+		signer.ProcessMessage2(msg2)
+		msg3, _ := signer.CreateMessage3()
+		requester.ProcessMessage3(msg3)
+		sig, err := requester.Signature()
+		if err != nil {
+			t.Fatal("failed to obtain signature:", err)
+		}
+
+		values := []struct {
+			in  encoding.BinaryMarshaler
+			out encoding.BinaryUnmarshaler
+		}{
+			{pk, &PublicKey{}},
+			{sk, &SecretKey{}},
+			{msg1, &Message1{}},
+			{msg2, &Message2{}},
+			{msg3, &Message3{}},
+			{sig, &Signature{}},
+		}
+
+		for _, value := range values {
+			data, err := value.in.MarshalBinary()
+			if err != nil {
+				t.Fatal("failed to marshal:", err)
+			}
+
+			if err = value.out.UnmarshalBinary(data); err != nil {
+				t.Fatal("failed to unmarshal:", err)
+			}
+
+			again, _ := value.out.(encoding.BinaryMarshaler).MarshalBinary()
+			if !bytes.Equal(data, again) {
+				t.Error("round trip changed encoding")
+			}
+
+			if value.out.UnmarshalBinary(append(data, 0)) == nil {
+				t.Error("accepted trailing data")
+			}
+
+			if value.out.UnmarshalBinary(data[:len(data)-1]) == nil {
+				t.Error("accepted truncated data")
+			}
+		}
+
+		// scalars must be reduced
+
+		data, _ := msg2.MarshalBinary()
+		for i := 3; i < len(data); i++ {
+			data[i] = 0xff
+		}
+		if (&Message2{}).UnmarshalBinary(data) == nil {
+			t.Error("accepted unreduced scalar")
+		}
+
+		// a message of one type does not decode as another
+
+		data, _ = msg2.MarshalBinary()
+		if (&SecretKey{}).UnmarshalBinary(data) == nil {
+			t.Error("accepted wrong type")
+		}
+
+		decoded, err := Message1FromBytes(msg1.Bytes())
+		if err != nil {
+			t.Fatal("failed to decode msg1:", err)
+		}
+
+		if decoded.Ax.Cmp(msg1.Ax) != 0 || decoded.Ay.Cmp(msg1.Ay) != 0 || decoded.By.Cmp(msg1.By) != 0 {
+			t.Error("msg1 point changed in round trip")
+		}
+	}
+}
+
+func TestJSONEncoding(t *testing.T) {
+
+	schemaData, err := ioutil.ReadFile("../schema/pblind.schema.json")
+	if err != nil {
+		t.Fatal("failed to read schema:", err)
+	}
+
+	var schema struct {
+		Defs map[string]struct {
+			Required []string
+			AllOf    []struct {
+				If struct {
+					Properties struct {
+						Curve struct {
+							Const string
+						}
+					}
+				}
+				Then struct {
+					Properties map[string]struct {
+						MinLength int
+					}
+				}
+			}
+		} `json:"$defs"`
+	}
+
+	if err = json.Unmarshal(schemaData, &schema); err != nil {
+		t.Fatal("failed to parse schema:", err)
+	}
+
+	for _, curve := range []elliptic.Curve{elliptic.P224(), elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+
+		sk, _ := NewSecretKey(curve)
+		pk := sk.GetPublicKey()
+		info, _ := CompressInfo(curve, []byte("info"))
+		requester, _ := CreateRequester(pk, info, []byte("message"))
+		signer, _ := CreateSigner(*sk, info)
+		msg1, _ := signer.CreateMessage1()
+		requester.ProcessMessage1(msg1)
+		msg2, _ := requester.CreateMessage2()
+		signer.ProcessMessage2(msg2)
+		msg3, _ := signer.CreateMessage3()
+		requester.ProcessMessage3(msg3)
+		sig, _ := requester.Signature()
+
+		values := map[string]struct {
+			in  interface{}
+			out interface{}
+		}{
+			"PublicKey": {pk, &PublicKey{}},
+			"Info":      {info, &Info{}},
+			"Message1":  {msg1, &Message1{}},
+			"Message2":  {msg2, &Message2{}},
+			"Message3":  {msg3, &Message3{}},
+			"Signature": {sig, &Signature{}},
+		}
+
+		for name, value := range values {
+			data, err := json.Marshal(value.in)
+			if err != nil {
+				t.Fatal("failed to marshal", name, err)
+			}
+
+			if err = json.Unmarshal(data, value.out); err != nil {
+				t.Fatal("failed to unmarshal", name, err)
+			}
+
+			again, _ := json.Marshal(value.out)
+			if !bytes.Equal(data, again) {
+				t.Error("round trip changed encoding of", name)
+			}
+
+			// field lengths agree with the published schema
+
+			var fields map[string]string
+			json.Unmarshal(data, &fields)
+
+			def := schema.Defs[name]
+			if len(fields) != len(def.Required) {
+				t.Error("schema fields differ for", name)
+			}
+
+			for _, rule := range def.AllOf {
+				if rule.If.Properties.Curve.Const != fields["curve"] {
+					continue
+				}
+				for field, length := range rule.Then.Properties {
+					if len(fields[field]) != length.MinLength {
+						t.Error("schema length differs for", name, field)
+					}
+				}
+			}
+
+			// strict decoding
+
+			fields["extra"] = "x"
+			extra, _ := json.Marshal(fields)
+			if json.Unmarshal(extra, value.out) == nil {
+				t.Error("accepted unknown field in", name)
+			}
+
+			delete(fields, "extra")
+			fields["curve"] = "P-192"
+			unknown, _ := json.Marshal(fields)
+			if json.Unmarshal(unknown, value.out) == nil {
+				t.Error("accepted unknown curve in", name)
+			}
+		}
+
+		// scalars must be reduced and fixed-width
+
+		var fields map[string]string
+		data, _ := json.Marshal(msg2)
+		json.Unmarshal(data, &fields)
+
+		fields["e"] = jsonEncoding.EncodeToString(bytes.Repeat([]byte{0xff}, scalarSize(curve)))
+		data, _ = json.Marshal(fields)
+		if json.Unmarshal(data, &Message2{}) == nil {
+			t.Error("accepted unreduced scalar")
+		}
+
+		fields["e"] = jsonEncoding.EncodeToString([]byte{1})
+		data, _ = json.Marshal(fields)
+		if json.Unmarshal(data, &Message2{}) == nil {
+			t.Error("accepted short scalar")
+		}
+	}
+}
+
+func TestDEREncoding(t *testing.T) {
+
+	for _, curve := range []elliptic.Curve{elliptic.P224(), elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+
+		sk, _ := NewSecretKey(curve)
+		pk := sk.GetPublicKey()
+		info, _ := CompressInfo(curve, []byte("info"))
+		requester, _ := CreateRequester(pk, info, []byte("message"))
+		signer, _ := CreateSigner(*sk, info)
+		msg1, _ := signer.CreateMessage1()
+		requester.ProcessMessage1(msg1)
+		msg2, _ := requester.CreateMessage2()
+		signer.ProcessMessage2(msg2)
+		msg3, _ := signer.CreateMessage3()
+		requester.ProcessMessage3(msg3)
+		sig, _ := requester.Signature()
+
+		type derValue interface {
+			MarshalDER() ([]byte, error)
+		}
+
+		values := []struct {
+			in    derValue
+			parse func([]byte) (derValue, error)
+		}{
+			{pk, func(data []byte) (derValue, error) { return ParsePublicKeyDER(data) }},
+			{sk, func(data []byte) (derValue, error) { return ParseSecretKeyDER(data) }},
+			{msg1, func(data []byte) (derValue, error) { return ParseMessage1DER(curve, data) }},
+			{msg2, func(data []byte) (derValue, error) { return ParseMessage2DER(curve, data) }},
+			{msg3, func(data []byte) (derValue, error) { return ParseMessage3DER(curve, data) }},
+			{sig, func(data []byte) (derValue, error) { return ParseSignatureDER(curve, data) }},
+		}
+
+		for _, value := range values {
+			data, err := value.in.MarshalDER()
+			if err != nil {
+				t.Fatal("failed to marshal:", err)
+			}
+
+			parsed, err := value.parse(data)
+			if err != nil {
+				t.Fatal("failed to parse:", err)
+			}
+
+			again, _ := parsed.MarshalDER()
+			if !bytes.Equal(data, again) {
+				t.Error("round trip changed encoding")
+			}
+
+			if _, err = value.parse(append(data, 0)); err == nil {
+				t.Error("accepted trailing data")
+			}
+		}
+
+		// a zero-padded INTEGER is valid BER but not DER
+
+		data, _ := msg2.MarshalDER()
+		padded := append([]byte{0x30, data[1] + 1, 0x02, data[3] + 1, 0x00}, data[4:]...)
+		if _, err := ParseMessage2DER(curve, padded); err == nil {
+			t.Error("accepted non-minimal INTEGER")
+		}
+
+		// keys are bound to the pblind algorithm
+
+		data, _ = asn1.Marshal(derPublicKey{
+			Algorithm: derAlgorithm{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}, Parameters: asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}},
+			PublicKey: asn1.BitString{Bytes: marshalCompressed(elliptic.P256(), elliptic.P256().Params().Gx, elliptic.P256().Params().Gy), BitLength: 264},
+		})
+		if _, err := ParsePublicKeyDER(data); err == nil {
+			t.Error("accepted foreign algorithm OID")
+		}
+	}
+}
+
+func TestKeySet(t *testing.T) {
+	sk1, _ := NewSecretKey(elliptic.P256())
+	sk2, _ := NewSecretKey(elliptic.P521())
+
+	set, err := NewKeySet(sk1.GetPublicKey(), sk2.GetPublicKey())
+	if err != nil {
+		t.Fatal("failed to create key set:", err)
+	}
+
+	server := httptest.NewServer(set)
+	defer server.Close()
+
+	fetched, err := FetchKeySet(server.URL)
+	if err != nil {
+		t.Fatal("failed to fetch key set:", err)
+	}
+
+	if len(fetched.Keys) != 2 {
+		t.Fatal("unexpected number of keys:", len(fetched.Keys))
+	}
+
+	for _, jwk := range set.Keys {
+		pk := fetched.Lookup(jwk.KeyID)
+		if pk == nil || pk.X.Cmp(jwk.Key.X) != 0 || pk.Y.Cmp(jwk.Key.Y) != 0 || pk.Curve != jwk.Key.Curve {
+			t.Error("key changed in key set:", jwk.KeyID)
+		}
+	}
+
+	// keys for other algorithms are skipped, a wrong kid is rejected
+
+	data, _ := json.Marshal(set.Keys[0])
+	mixed := []byte(`{"keys":[{"kty":"RSA","alg":"RS256","n":"AQAB","e":"AQAB"},` + string(data) + `]}`)
+
+	var decoded KeySet
+	if err = json.Unmarshal(mixed, &decoded); err != nil || len(decoded.Keys) != 1 {
+		t.Error("failed to skip foreign key:", err)
+	}
+
+	wrongKid := bytes.Replace(data, []byte(set.Keys[0].KeyID), []byte(set.Keys[1].KeyID), 1)
+	if json.Unmarshal(wrongKid, &JSONWebKey{}) != ErrorInvalidKeyID {
+		t.Error("accepted key with wrong kid")
+	}
+}
+
+func TestDEREncodingWithoutCurve(t *testing.T) {
+	curve := elliptic.P256()
+	sk, _ := NewSecretKey(curve)
+	info, _ := CompressInfo(curve, []byte("info"))
+	signer, _ := CreateSigner(*sk, info)
+	msg1, _ := signer.CreateMessage1()
+
+	data, _ := msg1.MarshalDER()
+
+	msg1.Curve = nil
+	if _, err := msg1.MarshalDER(); err != ErrorUnknownCurve {
+		t.Error("marshaled msg1 without curve:", err)
+	}
+
+	parsers := []func([]byte) error{
+		func(data []byte) error { _, err := ParseMessage1DER(nil, data); return err },
+		func(data []byte) error { _, err := ParseMessage2DER(nil, data); return err },
+		func(data []byte) error { _, err := ParseMessage3DER(nil, data); return err },
+		func(data []byte) error { _, err := ParseSignatureDER(nil, data); return err },
+	}
+
+	for _, parse := range parsers {
+		if err := parse(data); err != ErrorUnknownCurve {
+			t.Error("parsed without curve:", err)
+		}
+	}
+}
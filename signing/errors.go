@@ -8,3 +8,39 @@ var ErrorPointNotOnCurve error = errors.New("Point not on Curve")
 var ErrorInvalidSignerState error = errors.New("Signer is in invalid State")
 var ErrorInvalidRequesterState error = errors.New("Signer is in invalid State")
 var ErrorInvalidSignature error = errors.New("Signature is invalid")
+var ErrorInvalidCoin error = errors.New("Coin is invalid")
+var ErrorInvalidReveal error = errors.New("Opened coin candidate is invalid")
+var ErrorInvalidSpend error = errors.New("Spend transcript is invalid")
+var ErrorNotDoubleSpent error = errors.New("Transcripts do not show a double spend")
+var ErrorAlreadyRedeemed error = errors.New("Token has already been redeemed")
+var ErrorUnknownCurve error = errors.New("Curve is not supported")
+var ErrorInvalidEncoding error = errors.New("Encoding is invalid")
+var ErrorPassphraseRequired error = errors.New("Passphrase required for encrypted key")
+var ErrorUnencryptedSecret error = errors.New("Secret file is not encrypted")
+var ErrorInvalidPassphrase error = errors.New("Passphrase is incorrect or key is corrupted")
+var ErrorInvalidKeyID error = errors.New("Key ID does not match key")
+var ErrorFetchFailed error = errors.New("Fetching remote resource failed")
+var ErrorUnknownKey error = errors.New("Key is not in the keyring")
+var ErrorKeyNotValid error = errors.New("Key is outside its validity period")
+var ErrorKeyRevoked error = errors.New("Key has been revoked")
+var ErrorStaleRevocationList error = errors.New("Revocation list is older than the one in use")
+var ErrorConflictingRevocationList error = errors.New("Revocation list differs from the one in use with the same sequence number")
+var ErrorKeyNotInManifest error = errors.New("Key is not in the key manifest")
+var ErrorManifestExpired error = errors.New("Key manifest has expired")
+var ErrorStaleManifest error = errors.New("Key manifest is older than the one in use")
+var ErrorConflictingManifest error = errors.New("Key manifest differs from the one in use with the same sequence number")
+var ErrorKeyChanged error = errors.New("Key differs from the key pinned for this signer")
+var ErrorKeyMismatch error = errors.New("Key is not confirmed by an independent key source")
+var ErrorInvalidProof error = errors.New("Transparency log proof is invalid")
+var ErrorNotInLog error = errors.New("Entry is not in the transparency log")
+var ErrorNotEnoughShares error = errors.New("Not enough key shares")
+var ErrorInvalidShare error = errors.New("Key share is invalid or belongs to another key")
+var ErrorInvalidSeed error = errors.New("Seed has an invalid length")
+var ErrorInvalidMnemonic error = errors.New("Mnemonic is invalid or has a bad checksum")
+var ErrorUnknownSession error = errors.New("Signing session is unknown or has expired")
+var ErrorPeerNotAllowed error = errors.New("Peer is not allowed to use the signer")
+var ErrorPeerCredentials error = errors.New("Peer credentials are not available on this platform")
+var ErrorInvalidPIN error = errors.New("PIN is incorrect")
+var ErrorNotLoggedIn error = errors.New("Key token requires login")
+var ErrorMemoryLock error = errors.New("Locking memory is not supported on this platform")
+var ErrorKeyDestroyed error = errors.New("Key has been destroyed")
@@ -0,0 +1,73 @@
+package signing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces filename with data so that readers see either the
+// old or the new contents, never a partial file. The file is created with
+// perm before any data is written to it.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	temp, createError := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if createError != nil {
+		return createError
+	}
+
+	tempName := temp.Name()
+	defer os.Remove(tempName)
+
+	if chmodError := temp.Chmod(perm); chmodError != nil {
+		temp.Close()
+		return chmodError
+	}
+
+	if _, writeError := temp.Write(data); writeError != nil {
+		temp.Close()
+		return writeError
+	}
+
+	if syncError := temp.Sync(); syncError != nil {
+		temp.Close()
+		return syncError
+	}
+
+	if closeError := temp.Close(); closeError != nil {
+		return closeError
+	}
+
+	return os.Rename(tempName, filename)
+}
+
+// WipeFile overwrites filename with zeros, flushes it to disk and removes
+// it. Journaling and copy-on-write file systems and flash storage may still
+// keep the old blocks, so it is a best effort on top of encryption.
+func WipeFile(filename string) error {
+	file, openError := os.OpenFile(filename, os.O_WRONLY, 0)
+	if openError != nil {
+		return openError
+	}
+
+	info, statError := file.Stat()
+	if statError != nil {
+		file.Close()
+		return statError
+	}
+
+	if _, writeError := file.Write(make([]byte, info.Size())); writeError != nil {
+		file.Close()
+		return writeError
+	}
+
+	if syncError := file.Sync(); syncError != nil {
+		file.Close()
+		return syncError
+	}
+
+	if closeError := file.Close(); closeError != nil {
+		return closeError
+	}
+
+	return os.Remove(filename)
+}
@@ -3,6 +3,7 @@ package signing
 import (
 	"crypto/elliptic"
 	"crypto/subtle"
+	"encoding/binary"
 	"fmt"
 	"math/big"
 )
@@ -14,7 +15,7 @@ type Info struct {
 }
 
 func (info Info) String() string {
-	return fmt.Sprintf("(%S %S)", info.X, info.Y)
+	return fmt.Sprintf("(%s %s)", info.X, info.Y)
 }
 
 func (info1 Info) Equals(info2 Info) bool {
@@ -24,6 +25,35 @@ func (info1 Info) Equals(info2 Info) bool {
 }
 
 func CompressInfo(curve elliptic.Curve, info []byte) (c Info, err error) {
+	c.Curve = curve
 	c.X, c.Y, err = hashToPoint(curve, info)
 	return c, err
 }
+
+// InfoField is one key/value entry of structured info.
+type InfoField struct {
+	Key   string
+	Value string
+}
+
+// EncodeInfo serializes structured info unambiguously. Fields are encoded in
+// the order given, so signer and requester must agree on the order.
+func EncodeInfo(fields ...InfoField) []byte {
+	buff := []byte("PBLIND-INFO-V1")
+	for _, field := range fields {
+		buff = appendLengthPrefixed(buff, []byte(field.Key))
+		buff = appendLengthPrefixed(buff, []byte(field.Value))
+	}
+	return buff
+}
+
+func CompressInfoFields(curve elliptic.Curve, fields ...InfoField) (Info, error) {
+	return CompressInfo(curve, EncodeInfo(fields...))
+}
+
+func appendLengthPrefixed(buff []byte, value []byte) []byte {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(value)))
+	buff = append(buff, length[:]...)
+	return append(buff, value...)
+}
@@ -56,7 +56,7 @@ func TestInteraction(t *testing.T) {
 					t.Error("failed to create requester:", err)
 				}
 
-				signer, err := CreateSigner(sk, info)
+				signer, err := CreateSigner(*sk, info)
 				if err != nil {
 					t.Error("failed to create signer:", err)
 				}
@@ -136,7 +136,7 @@ func BenchmarkInteraction(b *testing.B) {
 			b.Error("failed to create requester:", err)
 		}
 
-		signer, err := CreateSigner(sk, info)
+		signer, err := CreateSigner(*sk, info)
 		if err != nil {
 			b.Error("failed to create signer:", err)
 		}
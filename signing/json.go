@@ -0,0 +1,379 @@
+package signing
+
+// JSON encoding, described by schema/pblind.schema.json.
+//
+// Points are SEC1 compressed and scalars fixed-width big-endian, both in
+// unpadded base64url, next to the curve name. Decoding rejects unknown or
+// missing fields, encodings of the wrong length, unreduced scalars and
+// points not on the curve.
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+)
+
+type jsonPoint struct {
+	Curve string `json:"curve"`
+	Point string `json:"point"`
+}
+
+type jsonMessage1 struct {
+	Curve string `json:"curve"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+type jsonMessage2 struct {
+	Curve string `json:"curve"`
+	E     string `json:"e"`
+}
+
+type jsonMessage3 struct {
+	Curve string `json:"curve"`
+	R     string `json:"r"`
+	C     string `json:"c"`
+	S     string `json:"s"`
+}
+
+type jsonSignature struct {
+	Curve string `json:"curve"`
+	P     string `json:"p"`
+	W     string `json:"w"`
+	O     string `json:"o"`
+	G     string `json:"g"`
+}
+
+var jsonEncoding = base64.RawURLEncoding.Strict()
+
+func jsonCurveName(curve elliptic.Curve) (string, error) {
+	entry, err := lookupCurve(curve)
+	if err != nil {
+		return "", err
+	}
+
+	return entry.Name, nil
+}
+
+func jsonCurve(name string) (elliptic.Curve, error) {
+	entry, err := lookupCurveName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry.Curve, nil
+}
+
+func encodePointJSON(curve elliptic.Curve, x, y *big.Int) (string, error) {
+	data, err := appendPoint(nil, curve, x, y)
+	if err != nil {
+		return "", err
+	}
+
+	return jsonEncoding.EncodeToString(data), nil
+}
+
+func decodePointJSON(curve elliptic.Curve, value string) (*big.Int, *big.Int, error) {
+	data, err := jsonEncoding.DecodeString(value)
+	if err != nil || len(data) != 1+fieldSize(curve) {
+		return nil, nil, ErrorInvalidEncoding
+	}
+
+	return unmarshalCompressed(curve, data)
+}
+
+func encodeScalarJSON(curve elliptic.Curve, x *big.Int) (string, error) {
+	data, err := appendScalar(nil, curve, x)
+	if err != nil {
+		return "", err
+	}
+
+	return jsonEncoding.EncodeToString(data), nil
+}
+
+func decodeScalarJSON(curve elliptic.Curve, value string) (*big.Int, error) {
+	data, err := jsonEncoding.DecodeString(value)
+	if err != nil || len(data) != scalarSize(curve) {
+		return nil, ErrorInvalidEncoding
+	}
+
+	scalar, _, err := readScalar(curve, data)
+	return scalar, err
+}
+
+// decodeJSONStrict decodes a single object without unknown fields.
+func decodeJSONStrict(data []byte, value interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(value); err != nil {
+		return ErrorInvalidEncoding
+	}
+
+	if decoder.More() {
+		return ErrorInvalidEncoding
+	}
+
+	return nil
+}
+
+func marshalPointJSON(curve elliptic.Curve, x, y *big.Int) ([]byte, error) {
+	name, err := jsonCurveName(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	point, err := encodePointJSON(curve, x, y)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(jsonPoint{Curve: name, Point: point})
+}
+
+func unmarshalPointJSON(data []byte) (elliptic.Curve, *big.Int, *big.Int, error) {
+	var decoded jsonPoint
+	if err := decodeJSONStrict(data, &decoded); err != nil {
+		return nil, nil, nil, err
+	}
+
+	curve, err := jsonCurve(decoded.Curve)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	x, y, err := decodePointJSON(curve, decoded.Point)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return curve, x, y, nil
+}
+
+func (pk PublicKey) MarshalJSON() ([]byte, error) {
+	return marshalPointJSON(pk.Curve, pk.X, pk.Y)
+}
+
+func (pk *PublicKey) UnmarshalJSON(data []byte) error {
+	curve, x, y, err := unmarshalPointJSON(data)
+	if err != nil {
+		return err
+	}
+
+	*pk = PublicKey{Curve: curve, X: x, Y: y}
+
+	return nil
+}
+
+func (info Info) MarshalJSON() ([]byte, error) {
+	return marshalPointJSON(info.Curve, info.X, info.Y)
+}
+
+func (info *Info) UnmarshalJSON(data []byte) error {
+	curve, x, y, err := unmarshalPointJSON(data)
+	if err != nil {
+		return err
+	}
+
+	*info = Info{Curve: curve, X: x, Y: y}
+
+	return nil
+}
+
+func (msg Message1) MarshalJSON() ([]byte, error) {
+	name, err := jsonCurveName(msg.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := jsonMessage1{Curve: name}
+
+	if encoded.A, err = encodePointJSON(msg.Curve, msg.Ax, msg.Ay); err != nil {
+		return nil, err
+	}
+
+	if encoded.B, err = encodePointJSON(msg.Curve, msg.Bx, msg.By); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(encoded)
+}
+
+func (msg *Message1) UnmarshalJSON(data []byte) error {
+	var encoded jsonMessage1
+	if err := decodeJSONStrict(data, &encoded); err != nil {
+		return err
+	}
+
+	curve, err := jsonCurve(encoded.Curve)
+	if err != nil {
+		return err
+	}
+
+	decoded := Message1{Curve: curve}
+
+	if decoded.Ax, decoded.Ay, err = decodePointJSON(curve, encoded.A); err != nil {
+		return err
+	}
+
+	if decoded.Bx, decoded.By, err = decodePointJSON(curve, encoded.B); err != nil {
+		return err
+	}
+
+	*msg = decoded
+
+	return nil
+}
+
+func (msg Message2) MarshalJSON() ([]byte, error) {
+	name, err := jsonCurveName(msg.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := jsonMessage2{Curve: name}
+
+	if encoded.E, err = encodeScalarJSON(msg.Curve, msg.E); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(encoded)
+}
+
+func (msg *Message2) UnmarshalJSON(data []byte) error {
+	var encoded jsonMessage2
+	if err := decodeJSONStrict(data, &encoded); err != nil {
+		return err
+	}
+
+	curve, err := jsonCurve(encoded.Curve)
+	if err != nil {
+		return err
+	}
+
+	decoded := Message2{Curve: curve}
+
+	if decoded.E, err = decodeScalarJSON(curve, encoded.E); err != nil {
+		return err
+	}
+
+	*msg = decoded
+
+	return nil
+}
+
+func (msg Message3) MarshalJSON() ([]byte, error) {
+	name, err := jsonCurveName(msg.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := jsonMessage3{Curve: name}
+
+	if encoded.R, err = encodeScalarJSON(msg.Curve, msg.R); err != nil {
+		return nil, err
+	}
+
+	if encoded.C, err = encodeScalarJSON(msg.Curve, msg.C); err != nil {
+		return nil, err
+	}
+
+	if encoded.S, err = encodeScalarJSON(msg.Curve, msg.S); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(encoded)
+}
+
+func (msg *Message3) UnmarshalJSON(data []byte) error {
+	var encoded jsonMessage3
+	if err := decodeJSONStrict(data, &encoded); err != nil {
+		return err
+	}
+
+	curve, err := jsonCurve(encoded.Curve)
+	if err != nil {
+		return err
+	}
+
+	decoded := Message3{Curve: curve}
+
+	if decoded.R, err = decodeScalarJSON(curve, encoded.R); err != nil {
+		return err
+	}
+
+	if decoded.C, err = decodeScalarJSON(curve, encoded.C); err != nil {
+		return err
+	}
+
+	if decoded.S, err = decodeScalarJSON(curve, encoded.S); err != nil {
+		return err
+	}
+
+	*msg = decoded
+
+	return nil
+}
+
+func (sig Signature) MarshalJSON() ([]byte, error) {
+	name, err := jsonCurveName(sig.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := jsonSignature{Curve: name}
+
+	if encoded.P, err = encodeScalarJSON(sig.Curve, sig.P); err != nil {
+		return nil, err
+	}
+
+	if encoded.W, err = encodeScalarJSON(sig.Curve, sig.W); err != nil {
+		return nil, err
+	}
+
+	if encoded.O, err = encodeScalarJSON(sig.Curve, sig.O); err != nil {
+		return nil, err
+	}
+
+	if encoded.G, err = encodeScalarJSON(sig.Curve, sig.G); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(encoded)
+}
+
+func (sig *Signature) UnmarshalJSON(data []byte) error {
+	var encoded jsonSignature
+	if err := decodeJSONStrict(data, &encoded); err != nil {
+		return err
+	}
+
+	curve, err := jsonCurve(encoded.Curve)
+	if err != nil {
+		return err
+	}
+
+	decoded := Signature{Curve: curve}
+
+	if decoded.P, err = decodeScalarJSON(curve, encoded.P); err != nil {
+		return err
+	}
+
+	if decoded.W, err = decodeScalarJSON(curve, encoded.W); err != nil {
+		return err
+	}
+
+	if decoded.O, err = decodeScalarJSON(curve, encoded.O); err != nil {
+		return err
+	}
+
+	if decoded.G, err = decodeScalarJSON(curve, encoded.G); err != nil {
+		return err
+	}
+
+	*sig = decoded
+
+	return nil
+}
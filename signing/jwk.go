@@ -0,0 +1,268 @@
+package signing
+
+// JSON Web Key (RFC 7517) representation of public keys.
+//
+// A pblind key is an EC key with "alg" set to PBLIND-AO, so generic JWK
+// tooling can parse it but will not mistake it for an ECDSA key. The "kid"
+// is the base64url key fingerprint.
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const JWKAlgorithm = "PBLIND-AO"
+
+const keyIDSize = sha256.Size
+
+type JSONWebKey struct {
+	Key   *PublicKey
+	KeyID string
+}
+
+// KeySet is a JWK Set of pblind public keys.
+type KeySet struct {
+	Keys []JSONWebKey
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+}
+
+type jsonKeySet struct {
+	Keys []json.RawMessage `json:"keys"`
+}
+
+// Fingerprint is the SHA-256 hash of the binary encoding of the key.
+func (pk *PublicKey) Fingerprint() ([]byte, error) {
+	data, err := pk.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(data)
+
+	return hash[:], nil
+}
+
+func (pk *PublicKey) KeyID() (string, error) {
+	fingerprint, err := pk.Fingerprint()
+	if err != nil {
+		return "", err
+	}
+
+	return jsonEncoding.EncodeToString(fingerprint), nil
+}
+
+func NewJSONWebKey(pk *PublicKey) (JSONWebKey, error) {
+	kid, err := pk.KeyID()
+	if err != nil {
+		return JSONWebKey{}, err
+	}
+
+	return JSONWebKey{Key: pk, KeyID: kid}, nil
+}
+
+func (jwk JSONWebKey) MarshalJSON() ([]byte, error) {
+	if jwk.Key == nil {
+		return nil, ErrorInvalidEncoding
+	}
+
+	entry, err := lookupCurve(jwk.Key.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	if !jwk.Key.Curve.IsOnCurve(jwk.Key.X, jwk.Key.Y) {
+		return nil, ErrorPointNotOnCurve
+	}
+
+	size := fieldSize(jwk.Key.Curve)
+	x := make([]byte, size)
+	y := make([]byte, size)
+	putFixed(x, jwk.Key.X)
+	putFixed(y, jwk.Key.Y)
+
+	return json.Marshal(jsonWebKey{
+		Kty: "EC",
+		Crv: entry.Name,
+		X:   jsonEncoding.EncodeToString(x),
+		Y:   jsonEncoding.EncodeToString(y),
+		Alg: JWKAlgorithm,
+		Use: "sig",
+		Kid: jwk.KeyID,
+	})
+}
+
+// UnmarshalJSON accepts only pblind keys whose kid matches the fingerprint.
+func (jwk *JSONWebKey) UnmarshalJSON(data []byte) error {
+	var decoded jsonWebKey
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return ErrorInvalidEncoding
+	}
+
+	if decoded.Kty != "EC" || decoded.Alg != JWKAlgorithm || (decoded.Use != "" && decoded.Use != "sig") {
+		return ErrorInvalidEncoding
+	}
+
+	entry, err := lookupCurveName(decoded.Crv)
+	if err != nil {
+		return err
+	}
+
+	curve := entry.Curve
+	size := fieldSize(curve)
+
+	x, xError := jsonEncoding.DecodeString(decoded.X)
+	y, yError := jsonEncoding.DecodeString(decoded.Y)
+	if xError != nil || yError != nil || len(x) != size || len(y) != size {
+		return ErrorInvalidEncoding
+	}
+
+	pk := PublicKey{Curve: curve}
+	pk.X, pk.Y = fromFixed(x), fromFixed(y)
+
+	if !curve.IsOnCurve(pk.X, pk.Y) {
+		return ErrorPointNotOnCurve
+	}
+
+	kid, err := pk.KeyID()
+	if err != nil {
+		return err
+	}
+
+	if decoded.Kid != kid {
+		return ErrorInvalidKeyID
+	}
+
+	*jwk = JSONWebKey{Key: &pk, KeyID: kid}
+
+	return nil
+}
+
+func NewKeySet(keys ...*PublicKey) (*KeySet, error) {
+	var set KeySet
+
+	for _, pk := range keys {
+		jwk, err := NewJSONWebKey(pk)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return &set, nil
+}
+
+func (set *KeySet) Lookup(kid string) *PublicKey {
+	for _, jwk := range set.Keys {
+		if jwk.KeyID == kid {
+			return jwk.Key
+		}
+	}
+
+	return nil
+}
+
+func (set KeySet) MarshalJSON() ([]byte, error) {
+	encoded := jsonKeySet{Keys: []json.RawMessage{}}
+
+	for _, jwk := range set.Keys {
+		data, err := jwk.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		encoded.Keys = append(encoded.Keys, data)
+	}
+
+	return json.Marshal(encoded)
+}
+
+// UnmarshalJSON keeps the pblind keys of a JWK Set and skips keys of other
+// types, which a shared gateway key set may contain.
+func (set *KeySet) UnmarshalJSON(data []byte) error {
+	var encoded jsonKeySet
+	if err := json.Unmarshal(data, &encoded); err != nil || encoded.Keys == nil {
+		return ErrorInvalidEncoding
+	}
+
+	var decoded KeySet
+
+	for _, raw := range encoded.Keys {
+		var header struct {
+			Alg string `json:"alg"`
+		}
+		if err := json.Unmarshal(raw, &header); err != nil {
+			return ErrorInvalidEncoding
+		}
+
+		if header.Alg != JWKAlgorithm {
+			continue
+		}
+
+		var jwk JSONWebKey
+		if err := jwk.UnmarshalJSON(raw); err != nil {
+			return err
+		}
+		decoded.Keys = append(decoded.Keys, jwk)
+	}
+
+	*set = decoded
+
+	return nil
+}
+
+// ServeHTTP serves the key set, e.g. at /.well-known/jwks.json.
+func (set *KeySet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(set)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	_, _ = w.Write(data)
+}
+
+func FetchKeySet(url string) (*KeySet, error) {
+	data, err := Fetch(url)
+	if err != nil {
+		return nil, err
+	}
+
+	var set KeySet
+	if decodeError := json.Unmarshal(data, &set); decodeError != nil {
+		return nil, decodeError
+	}
+
+	return &set, nil
+}
+
+// Fetch GETs url for the Fetch functions of this package, with a timeout,
+// and fails with ErrorFetchFailed unless the server answers 200 OK. It is
+// exported for callers that need the raw document, e.g. to save it.
+func Fetch(url string) ([]byte, error) {
+	client := http.Client{Timeout: 30 * time.Second}
+
+	response, getError := client.Get(url)
+	if getError != nil {
+		return nil, getError
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, ErrorFetchFailed
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
@@ -0,0 +1,362 @@
+package signing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// Token is a signature together with the ID of the key that made it, the
+// key fingerprint, so a verifier holding several keys can pick the right one.
+type Token struct {
+	KeyID     []byte
+	Signature Signature
+}
+
+type jsonToken struct {
+	KeyID     string          `json:"kid"`
+	Signature json.RawMessage `json:"signature"`
+}
+
+type KeyringEntry struct {
+	Key       *PublicKey
+	KeyID     []byte
+	NotBefore time.Time // zero for no lower bound
+	NotAfter  time.Time // zero for no upper bound
+	State     KeyState
+}
+
+// Keyring holds the public keys a verifier accepts, indexed by key ID.
+type Keyring struct {
+	Now func() time.Time // clock for validity periods, time.Now if nil
+
+	// AcceptBeforeRevocation keeps accepting tokens of a revoked key in
+	// CheckIssued if they were issued before the revocation.
+	AcceptBeforeRevocation bool
+
+	// Log, if set, is the key transparency log every key must be in before
+	// Check accepts it. Each key is checked once, with an inclusion proof
+	// against a tree head consistent with Log.Head.
+	Log *LogClient
+
+	mutex       sync.RWMutex
+	entries     map[string]*KeyringEntry
+	revocations *RevocationList
+
+	logMutex sync.Mutex
+	logged   map[string]bool // key IDs found in Log
+}
+
+func NewToken(pk *PublicKey, sig Signature) (Token, error) {
+	keyID, err := pk.Fingerprint()
+	if err != nil {
+		return Token{}, err
+	}
+
+	return Token{KeyID: keyID, Signature: sig}, nil
+}
+
+func (st *StateRequester) Token() (Token, error) {
+	sig, err := st.Signature()
+	if err != nil {
+		return Token{}, err
+	}
+
+	return NewToken(st.Pk, sig)
+}
+
+// MarshalBinary encodes the token as version || type || key ID followed by
+// the binary encoding of the signature.
+func (token Token) MarshalBinary() ([]byte, error) {
+	if len(token.KeyID) != keyIDSize {
+		return nil, ErrorInvalidKeyID
+	}
+
+	sig, err := token.Signature.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	buff := []byte{encodingVersion, encodingToken}
+	buff = append(buff, token.KeyID...)
+
+	return append(buff, sig...), nil
+}
+
+func (token *Token) UnmarshalBinary(data []byte) error {
+	if len(data) < 2+keyIDSize || data[0] != encodingVersion || data[1] != encodingToken {
+		return ErrorInvalidEncoding
+	}
+
+	var sig Signature
+	if err := sig.UnmarshalBinary(data[2+keyIDSize:]); err != nil {
+		return err
+	}
+
+	*token = Token{
+		KeyID:     append([]byte{}, data[2:2+keyIDSize]...),
+		Signature: sig,
+	}
+
+	return nil
+}
+
+func (token Token) MarshalJSON() ([]byte, error) {
+	if len(token.KeyID) != keyIDSize {
+		return nil, ErrorInvalidKeyID
+	}
+
+	sig, err := token.Signature.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(jsonToken{KeyID: jsonEncoding.EncodeToString(token.KeyID), Signature: sig})
+}
+
+func (token *Token) UnmarshalJSON(data []byte) error {
+	var encoded jsonToken
+	if err := decodeJSONStrict(data, &encoded); err != nil {
+		return err
+	}
+
+	keyID, err := jsonEncoding.DecodeString(encoded.KeyID)
+	if err != nil || len(keyID) != keyIDSize {
+		return ErrorInvalidKeyID
+	}
+
+	var sig Signature
+	if err = sig.UnmarshalJSON(encoded.Signature); err != nil {
+		return err
+	}
+
+	*token = Token{KeyID: keyID, Signature: sig}
+
+	return nil
+}
+
+// LoadToken reads a token in its binary encoding.
+func LoadToken(filename string) (*Token, error) {
+	data, readError := ioutil.ReadFile(filename)
+	if readError != nil {
+		return nil, readError
+	}
+
+	var token Token
+	if decodeError := token.UnmarshalBinary(data); decodeError != nil {
+		return nil, decodeError
+	}
+
+	return &token, nil
+}
+
+// Save writes the token in its binary encoding.
+func (token Token) Save(filename string) error {
+	data, encodingError := token.MarshalBinary()
+	if encodingError != nil {
+		return encodingError
+	}
+
+	return writeFileAtomic(filename, data, 0644)
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{entries: make(map[string]*KeyringEntry)}
+}
+
+func (ring *Keyring) now() time.Time {
+	if ring.Now != nil {
+		return ring.Now()
+	}
+	return time.Now()
+}
+
+// Add accepts signatures by pk between notBefore and notAfter; zero times
+// leave the period open on that side.
+func (ring *Keyring) Add(pk *PublicKey, notBefore time.Time, notAfter time.Time) error {
+	keyID, err := pk.Fingerprint()
+	if err != nil {
+		return err
+	}
+
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	ring.entries[string(keyID)] = &KeyringEntry{
+		Key:       pk,
+		KeyID:     keyID,
+		NotBefore: notBefore,
+		NotAfter:  notAfter,
+		State:     KeyActive,
+	}
+
+	return nil
+}
+
+// SetState moves a key through the rotation states. Only active and
+// verify-only keys are accepted by Check.
+func (ring *Keyring) SetState(keyID []byte, state KeyState) error {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	entry, ok := ring.entries[string(keyID)]
+	if !ok {
+		return ErrorUnknownKey
+	}
+
+	entry.State = state
+
+	return nil
+}
+
+func (ring *Keyring) Remove(keyID []byte) {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	delete(ring.entries, string(keyID))
+}
+
+// Keys lists the keyring entries in no particular order.
+func (ring *Keyring) Keys() []KeyringEntry {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+
+	entries := make([]KeyringEntry, 0, len(ring.entries))
+	for _, entry := range ring.entries {
+		entries = append(entries, *entry)
+	}
+
+	return entries
+}
+
+// SetRevocations makes the keyring reject the keys revoked in list. A list
+// older than the one in use is refused, and so is a list with the same
+// sequence number that differs from it.
+func (ring *Keyring) SetRevocations(list *RevocationList) error {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+
+	if ring.revocations != nil && list.Sequence < ring.revocations.Sequence {
+		return ErrorStaleRevocationList
+	}
+
+	if ring.revocations != nil && list.Sequence == ring.revocations.Sequence && !list.equal(ring.revocations) {
+		return ErrorConflictingRevocationList
+	}
+
+	ring.revocations = list
+
+	return nil
+}
+
+// Lookup returns the key with keyID if it verifies signatures now.
+func (ring *Keyring) Lookup(keyID []byte) (*PublicKey, error) {
+	return ring.lookup(keyID, time.Time{})
+}
+
+// lookup is Lookup for a token issued at issued, zero if unknown.
+func (ring *Keyring) lookup(keyID []byte, issued time.Time) (*PublicKey, error) {
+	ring.mutex.RLock()
+	entry, ok := ring.entries[string(keyID)]
+	revocations := ring.revocations
+	ring.mutex.RUnlock()
+
+	if !ok {
+		return nil, ErrorUnknownKey
+	}
+
+	if !entry.State.Verifies() || !entry.validAt(ring.now()) {
+		return nil, ErrorKeyNotValid
+	}
+
+	if revocations != nil {
+		if revocation := revocations.Lookup(keyID); revocation != nil {
+			if !ring.AcceptBeforeRevocation || issued.IsZero() || !issued.Before(revocation.RevokedAt) {
+				return nil, ErrorKeyRevoked
+			}
+		}
+	}
+
+	if err := ring.checkLog(entry); err != nil {
+		return nil, err
+	}
+
+	return entry.Key, nil
+}
+
+// checkLog makes sure the key of entry is in Log, if there is one.
+func (ring *Keyring) checkLog(entry *KeyringEntry) error {
+	if ring.Log == nil {
+		return nil
+	}
+
+	ring.logMutex.Lock()
+	defer ring.logMutex.Unlock()
+
+	if ring.logged[string(entry.KeyID)] {
+		return nil
+	}
+
+	if err := ring.Log.Check(entry.Key); err != nil {
+		return err
+	}
+
+	if ring.logged == nil {
+		ring.logged = make(map[string]bool)
+	}
+	ring.logged[string(entry.KeyID)] = true
+
+	return nil
+}
+
+func (entry *KeyringEntry) validAt(now time.Time) bool {
+	if !entry.NotBefore.IsZero() && now.Before(entry.NotBefore) {
+		return false
+	}
+
+	if !entry.NotAfter.IsZero() && !now.Before(entry.NotAfter) {
+		return false
+	}
+
+	return true
+}
+
+// Check verifies the token signature on msg with the key it names.
+func (ring *Keyring) Check(token Token, info Info, msg []byte) error {
+	return ring.CheckIssued(token, info, msg, time.Time{})
+}
+
+// CheckIssued is Check for a token the caller knows was issued at issued,
+// for example because it recorded when it was handed out. With
+// AcceptBeforeRevocation such tokens stay valid after their key is revoked.
+func (ring *Keyring) CheckIssued(token Token, info Info, msg []byte, issued time.Time) error {
+	pk, err := ring.lookup(token.KeyID, issued)
+	if err != nil {
+		return err
+	}
+
+	return checkSignature(pk, token.Signature, info, msg)
+}
+
+// checkSignature is PublicKey.Check for signatures from untrusted input,
+// which may be incomplete or for another curve.
+func checkSignature(pk *PublicKey, sig Signature, info Info, msg []byte) error {
+	if sig.P == nil || sig.W == nil || sig.O == nil || sig.G == nil {
+		return ErrorInvalidSignature
+	}
+
+	if sig.Curve != nil && sig.Curve.Params().Name != pk.Curve.Params().Name {
+		return ErrorInvalidSignature
+	}
+
+	if info.X == nil || info.Y == nil || !pk.Curve.IsOnCurve(info.X, info.Y) {
+		return ErrorPointNotOnCurve
+	}
+
+	if !pk.Check(sig, info, msg) {
+		return ErrorInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package signing
+
+import (
+	"crypto/elliptic"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func issueToken(t *testing.T, sk *SecretKey, info Info, message []byte) Token {
+	requester, _ := CreateRequester(sk.GetPublicKey(), info, message)
+	signer, _ := CreateSigner(*sk, info)
+
+	msg1, _ := signer.CreateMessage1()
+	if err := requester.ProcessMessage1(msg1); err != nil {
+		t.Fatal("failed to process msg1:", err)
+	}
+	msg2, _ := requester.CreateMessage2()
+	if err := signer.ProcessMessage2(msg2); err != nil {
+		t.Fatal("failed to process msg2:", err)
+	}
+	msg3, _ := signer.CreateMessage3()
+	if err := requester.ProcessMessage3(msg3); err != nil {
+		t.Fatal("failed to process msg3:", err)
+	}
+
+	token, err := requester.Token()
+	if err != nil {
+		t.Fatal("failed to obtain token:", err)
+	}
+
+	return token
+}
+
+func TestKeyring(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ring := NewKeyring()
+	ring.Now = func() time.Time { return now }
+
+	current, _ := NewSecretKey(elliptic.P256())
+	previous, _ := NewSecretKey(elliptic.P384())
+	unknown, _ := NewSecretKey(elliptic.P256())
+
+	ring.Add(current.GetPublicKey(), now.Add(-time.Hour), now.Add(time.Hour))
+	ring.Add(previous.GetPublicKey(), time.Time{}, now)
+
+	message := []byte("message")
+	info, _ := CompressInfo(elliptic.P256(), []byte("info"))
+	token := issueToken(t, current, info, message)
+
+	// tokens survive both encodings
+
+	data, err := token.MarshalBinary()
+	if err != nil {
+		t.Fatal("failed to encode token:", err)
+	}
+	var decoded Token
+	if err = decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal("failed to decode token:", err)
+	}
+
+	data, err = json.Marshal(decoded)
+	if err != nil {
+		t.Fatal("failed to encode token:", err)
+	}
+	decoded = Token{}
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal("failed to decode token:", err)
+	}
+
+	if err = ring.Check(decoded, info, message); err != nil {
+		t.Error("failed to check token:", err)
+	}
+
+	if err = ring.Check(decoded, info, []byte("other")); err != ErrorInvalidSignature {
+		t.Error("accepted token for other message:", err)
+	}
+
+	// a signature presented under another key ID fails
+
+	wrongKey := decoded
+	wrongKey.KeyID, _ = previous.GetPublicKey().Fingerprint()
+	now = now.Add(-time.Minute)
+	if err = ring.Check(wrongKey, info, message); err != ErrorInvalidSignature {
+		t.Error("accepted token under wrong key:", err)
+	}
+	now = now.Add(time.Minute)
+
+	// the previous key expired at now
+
+	previousInfo, _ := CompressInfo(elliptic.P384(), []byte("info"))
+	expired := issueToken(t, previous, previousInfo, message)
+	if err = ring.Check(expired, previousInfo, message); err != ErrorKeyNotValid {
+		t.Error("accepted token of expired key:", err)
+	}
+
+	if err = ring.Check(issueToken(t, unknown, info, message), info, message); err != ErrorUnknownKey {
+		t.Error("accepted token of unknown key:", err)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err = ring.Check(token, info, message); err != ErrorKeyNotValid {
+		t.Error("accepted token after key expiry:", err)
+	}
+}
@@ -1,10 +1,7 @@
 package signing
 
 import (
-	"bytes"
 	"crypto/elliptic"
-	"crypto/rand"
-	"encoding/gob"
 	"fmt"
 	"io/ioutil"
 	"math/big"
@@ -18,37 +15,49 @@ type PublicKey struct {
 type SecretKey struct {
 	Curve  elliptic.Curve
 	Scalar *big.Int
+
+	shared *keyState // shared by copies, see Lock and Destroy
 }
 
 func (pk *PublicKey) String() string {
-	return fmt.Sprintf("%S-Pk: (X = %S, Y = %S)", pk.Curve.Params().Name, pk.X, pk.Y)
+	return fmt.Sprintf("%s-Pk: (X = %s, Y = %s)", pk.Curve.Params().Name, pk.X, pk.Y)
 }
 
 func (sk *SecretKey) String() string {
-	return fmt.Sprintf("%S-Sk: (S = %S)", sk.Curve.Params().Name, sk.Scalar)
+	return fmt.Sprintf("%s-Sk: (S = %s)", sk.Curve.Params().Name, sk.Scalar)
 }
 
-func NewSecretKey(curve elliptic.Curve) (*SecretKey, error) {
+func NewSecretKey(curve elliptic.Curve, opts ...Option) (*SecretKey, error) {
 	var err error
-	var sk SecretKey
+	sk := SecretKey{shared: newKeyState()}
 	sk.Curve = curve
-	sk.Scalar, err = rand.Int(rand.Reader, curve.Params().N)
+	sk.Scalar, err = randomScalar(applyOptions(opts).random, curve)
 	return &sk, err
 }
 
-func LoadSecretKey(filename string) (*SecretKey, error) {
+// LoadSecretKey reads a PEM secret key, asking passphrase for the
+// passphrase if it is encrypted. Binary and gob files written by earlier
+// versions are still accepted.
+func LoadSecretKey(filename string, passphrase PassphraseFunc) (*SecretKey, error) {
 	data, readError := ioutil.ReadFile(filename)
 	if readError != nil {
 		return nil, readError
 	}
 
+	if isPEM(data) {
+		return ParseSecretKeyPEM(data, passphrase)
+	}
+
 	var sk SecretKey
-	var buffer bytes.Buffer
-	buffer.Write(data)
-	decoder := gob.NewDecoder(&buffer)
-	decodeError := decoder.Decode(&sk)
+	decodeError := sk.UnmarshalBinary(data)
 	if decodeError != nil {
-		return nil, decodeError
+		// files written before the binary encoding was introduced
+		legacy, legacyError := loadLegacySecretKey(data)
+		if legacyError != nil {
+			return nil, decodeError
+		}
+
+		return legacy, nil
 	}
 
 	return &sk, nil
@@ -60,23 +69,31 @@ func LoadPublicKey(filename string) (*PublicKey, error) {
 		return nil, readError
 	}
 
+	if isPEM(data) {
+		return ParsePublicKeyPEM(data)
+	}
+
 	var pk PublicKey
-	var buffer bytes.Buffer
-	buffer.Write(data)
-	decoder := gob.NewDecoder(&buffer)
-	decodeError := decoder.Decode(&pk)
+	decodeError := pk.UnmarshalBinary(data)
 	if decodeError != nil {
-		return nil, decodeError
+		// files written before the binary encoding was introduced
+		legacy, legacyError := loadLegacyPublicKey(data)
+		if legacyError != nil {
+			return nil, decodeError
+		}
+
+		return legacy, nil
 	}
 
 	return &pk, nil
 }
 
 func SecretKeyFromBytes(curve elliptic.Curve, val []byte) *SecretKey {
-	var sk SecretKey
+	sk := SecretKey{shared: newKeyState()}
 	sk.Scalar = big.NewInt(0)
 	sk.Scalar.SetBytes(val)
 	sk.Curve = curve
+	fixSecretInt(curve, sk.Scalar)
 	return &sk
 }
 
@@ -85,40 +102,32 @@ func (sk *SecretKey) Bytes() []byte {
 }
 
 func (sk *SecretKey) GetPublicKey() *PublicKey {
+	state := sk.state()
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+
 	var pk PublicKey
-	pk.X, pk.Y = sk.Curve.ScalarBaseMult(sk.Bytes())
+	pk.X, pk.Y = scalarBaseMult(sk.Curve, sk.Scalar)
 	pk.Curve = sk.Curve
 	return &pk
 }
 
-func (sk *SecretKey) Save(filename string) error {
-	var buffer bytes.Buffer        // Stand-in for a network connection
-	encoder := gob.NewEncoder(&buffer)
-	encodingError := encoder.Encode(sk)
+// Save writes the secret key as PEM encrypted under passphrase, readable
+// only by the owner.
+func (sk *SecretKey) Save(filename string, passphrase []byte) error {
+	data, encodingError := sk.MarshalPEM(passphrase)
 	if encodingError != nil {
 		return encodingError
 	}
 
-	requestWriteError := ioutil.WriteFile(filename, buffer.Bytes(), 0644)
-	if requestWriteError != nil {
-		return requestWriteError
-	}
-
-	return nil
+	return writeFileAtomic(filename, data, 0600)
 }
 
 func (pk *PublicKey) Save(filename string) error {
-	var buffer bytes.Buffer        // Stand-in for a network connection
-	encoder := gob.NewEncoder(&buffer)
-	encodingError := encoder.Encode(pk)
+	data, encodingError := pk.MarshalPEM()
 	if encodingError != nil {
 		return encodingError
 	}
 
-	requestWriteError := ioutil.WriteFile(filename, buffer.Bytes(), 0644)
-	if requestWriteError != nil {
-		return requestWriteError
-	}
-
-	return nil
-}
\ No newline at end of file
+	return writeFileAtomic(filename, data, 0644)
+}
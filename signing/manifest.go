@@ -0,0 +1,292 @@
+package signing
+
+// Key manifests.
+//
+// A signer could hand every requester a different public key and later tell
+// users apart by the key their token verifies under. A key manifest lists
+// the keys the signer uses, with their validity windows and fingerprints,
+// signed with the root key; requesters only run issuance under keys in a
+// valid manifest. Manifests are meant to be public, so that everyone can
+// compare the same document.
+//
+// Only KeyManifest.CreateRequester enforces the manifest, the package level
+// CreateRequester accepts any key. A signer could also replay an older
+// manifest that still lists a key dropped since, so requesters keep the
+// newest manifest they have seen and refuse older ones, or different ones
+// with the same sequence number, with CheckSequence, as keyrings do with
+// revocation lists.
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"time"
+)
+
+const documentKeyManifest = "pblind-key-manifest"
+
+type ManifestKey struct {
+	Key       *PublicKey
+	KeyID     []byte
+	NotBefore time.Time // zero for no lower bound
+	NotAfter  time.Time // zero for no upper bound
+}
+
+type KeyManifest struct {
+	Sequence uint64
+	Issued   time.Time
+	Expires  time.Time
+	Keys     []ManifestKey
+
+	Now func() time.Time // clock for validity checks, time.Now if nil
+}
+
+type jsonManifestKey struct {
+	KeyID     string     `json:"kid"`
+	Key       string     `json:"key"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	NotAfter  *time.Time `json:"not_after,omitempty"`
+}
+
+type jsonKeyManifest struct {
+	Sequence uint64            `json:"sequence"`
+	Issued   time.Time         `json:"issued"`
+	Expires  time.Time         `json:"expires"`
+	Keys     []jsonManifestKey `json:"keys"`
+}
+
+func (manifest *KeyManifest) now() time.Time {
+	if manifest.Now != nil {
+		return manifest.Now()
+	}
+	return time.Now()
+}
+
+func (manifest *KeyManifest) Add(pk *PublicKey, notBefore time.Time, notAfter time.Time) error {
+	keyID, err := pk.Fingerprint()
+	if err != nil {
+		return err
+	}
+
+	manifest.Keys = append(manifest.Keys, ManifestKey{Key: pk, KeyID: keyID, NotBefore: notBefore, NotAfter: notAfter})
+
+	return nil
+}
+
+// Sign encodes the manifest as a document signed by root.
+func (manifest *KeyManifest) Sign(root ed25519.PrivateKey) ([]byte, error) {
+	encoded, err := manifest.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return signDocument(documentKeyManifest, encoded, root)
+}
+
+func (manifest *KeyManifest) encode() (*jsonKeyManifest, error) {
+	encoded := &jsonKeyManifest{
+		Sequence: manifest.Sequence,
+		Issued:   manifest.Issued,
+		Expires:  manifest.Expires,
+		Keys:     []jsonManifestKey{},
+	}
+
+	for _, key := range manifest.Keys {
+		data, err := key.Key.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		entry := jsonManifestKey{
+			KeyID: jsonEncoding.EncodeToString(key.KeyID),
+			Key:   jsonEncoding.EncodeToString(data),
+		}
+		if notBefore := key.NotBefore; !notBefore.IsZero() {
+			entry.NotBefore = &notBefore
+		}
+		if notAfter := key.NotAfter; !notAfter.IsZero() {
+			entry.NotAfter = &notAfter
+		}
+
+		encoded.Keys = append(encoded.Keys, entry)
+	}
+
+	return encoded, nil
+}
+
+// equal tells if manifest and other encode to the same bytes.
+func (manifest *KeyManifest) equal(other *KeyManifest) bool {
+	encoded, err := manifest.encode()
+	if err != nil {
+		return false
+	}
+	otherEncoded, err := other.encode()
+	if err != nil {
+		return false
+	}
+
+	data, err := json.Marshal(encoded)
+	if err != nil {
+		return false
+	}
+	otherData, err := json.Marshal(otherEncoded)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(data, otherData)
+}
+
+// VerifyManifest decodes a manifest after checking its root signature and
+// that every key matches its fingerprint. Whether the manifest is still
+// valid is checked when it is used, and whether it is older than one seen
+// before with CheckSequence.
+func VerifyManifest(data []byte, root ed25519.PublicKey) (*KeyManifest, error) {
+	var encoded jsonKeyManifest
+	if err := openDocument(documentKeyManifest, data, root, &encoded); err != nil {
+		return nil, err
+	}
+
+	manifest := &KeyManifest{Sequence: encoded.Sequence, Issued: encoded.Issued, Expires: encoded.Expires}
+
+	for _, entry := range encoded.Keys {
+		keyID, kidError := jsonEncoding.DecodeString(entry.KeyID)
+		keyData, keyError := jsonEncoding.DecodeString(entry.Key)
+		if kidError != nil || keyError != nil {
+			return nil, ErrorInvalidEncoding
+		}
+
+		var pk PublicKey
+		if err := pk.UnmarshalBinary(keyData); err != nil {
+			return nil, err
+		}
+
+		fingerprint, err := pk.Fingerprint()
+		if err != nil || string(fingerprint) != string(keyID) {
+			return nil, ErrorInvalidKeyID
+		}
+
+		key := ManifestKey{Key: &pk, KeyID: keyID}
+		if entry.NotBefore != nil {
+			key.NotBefore = *entry.NotBefore
+		}
+		if entry.NotAfter != nil {
+			key.NotAfter = *entry.NotAfter
+		}
+
+		manifest.Keys = append(manifest.Keys, key)
+	}
+
+	return manifest, nil
+}
+
+// SaveManifest writes a manifest signed with Sign atomically, so a crash
+// never leaves a torn manifest that stops all issuance.
+func SaveManifest(filename string, data []byte) error {
+	return writeFileAtomic(filename, data, 0644)
+}
+
+func FetchManifest(url string, root ed25519.PublicKey) (*KeyManifest, error) {
+	data, err := Fetch(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return VerifyManifest(data, root)
+}
+
+// CheckSequence refuses the manifest if it is older than previous, the
+// newest manifest seen so far, which may be nil, or if it has the same
+// sequence number but differs from it: a signer handing out different
+// manifests under one number could tell their users apart.
+func (manifest *KeyManifest) CheckSequence(previous *KeyManifest) error {
+	if previous == nil {
+		return nil
+	}
+
+	if manifest.Sequence < previous.Sequence {
+		return ErrorStaleManifest
+	}
+
+	if manifest.Sequence == previous.Sequence && !manifest.equal(previous) {
+		return ErrorConflictingManifest
+	}
+
+	return nil
+}
+
+// Check tells if pk is listed in the manifest and both are valid now.
+func (manifest *KeyManifest) Check(pk *PublicKey) error {
+	now := manifest.now()
+
+	if now.Before(manifest.Issued) || !now.Before(manifest.Expires) {
+		return ErrorManifestExpired
+	}
+
+	keyID, err := pk.Fingerprint()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range manifest.Keys {
+		if string(key.KeyID) != string(keyID) {
+			continue
+		}
+
+		entry := KeyringEntry{NotBefore: key.NotBefore, NotAfter: key.NotAfter}
+		if !entry.validAt(now) {
+			return ErrorKeyNotValid
+		}
+
+		return nil
+	}
+
+	return ErrorKeyNotInManifest
+}
+
+// CreateRequester is CreateRequester for keys listed in the manifest only.
+func (manifest *KeyManifest) CreateRequester(pk *PublicKey, info Info, message []byte) (*StateRequester, error) {
+	if err := manifest.Check(pk); err != nil {
+		return nil, err
+	}
+
+	return CreateRequester(pk, info, message)
+}
+
+// Keyring returns a keyring with the keys of the manifest.
+func (manifest *KeyManifest) Keyring() (*Keyring, error) {
+	ring := NewKeyring()
+	ring.Now = manifest.Now
+
+	for _, key := range manifest.Keys {
+		if err := ring.Add(key.Key, key.NotBefore, key.NotAfter); err != nil {
+			return nil, err
+		}
+	}
+
+	return ring, nil
+}
+
+// Manifest lists the keys that are not retired, valid until expires. The
+// pending key is included, so the manifest stays good across the next
+// rotation; a verify-only key is listed until its overlap ends.
+func (rotator *KeyRotator) Manifest(sequence uint64, expires time.Time) (*KeyManifest, error) {
+	manifest := &KeyManifest{Sequence: sequence, Issued: rotator.now(), Expires: expires}
+
+	for _, key := range rotator.Keys() {
+		var notAfter time.Time
+
+		switch key.State {
+		case KeyRetired:
+			continue
+		case KeyVerifyOnly:
+			notAfter = key.Deactivated.Add(rotator.Policy.Overlap)
+		}
+
+		if err := manifest.Add(key.Pk, key.Created, notAfter); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest, nil
+}
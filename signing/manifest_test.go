@@ -0,0 +1,91 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"testing"
+	"time"
+)
+
+func TestKeyManifest(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	root, _ := NewRootKey()
+	rootPublic := root.Public().(ed25519.PublicKey)
+
+	rotator, _ := NewKeyRotator(RotationPolicy{Overlap: time.Hour}, nil, clock)
+	rotator.Rotate()
+
+	signed, _ := rotator.Manifest(1, now.Add(7*24*time.Hour))
+	data, err := signed.Sign(root)
+	if err != nil {
+		t.Fatal("failed to sign manifest:", err)
+	}
+
+	manifest, err := VerifyManifest(data, rootPublic)
+	if err != nil {
+		t.Fatal("failed to verify manifest:", err)
+	}
+	manifest.Now = clock
+
+	if len(manifest.Keys) != 3 {
+		t.Fatal("manifest lists", len(manifest.Keys), "keys")
+	}
+
+	info, _ := CompressInfo(elliptic.P256(), []byte("info"))
+
+	if _, err = manifest.CreateRequester(rotator.Active(), info, []byte("message")); err != nil {
+		t.Error("refused key in manifest:", err)
+	}
+
+	// a key handed to one user only is refused
+
+	sk, _ := NewSecretKey(elliptic.P256())
+	if _, err = manifest.CreateRequester(sk.GetPublicKey(), info, []byte("message")); err != ErrorKeyNotInManifest {
+		t.Error("accepted key not in manifest:", err)
+	}
+
+	// the replaced key leaves the manifest after the overlap
+
+	replaced := rotator.Keys()[0]
+	now = now.Add(time.Hour)
+	if err = manifest.Check(replaced.Pk); err != ErrorKeyNotValid {
+		t.Error("accepted replaced key after overlap:", err)
+	}
+
+	now = now.Add(7 * 24 * time.Hour)
+	if err = manifest.Check(rotator.Active()); err != ErrorManifestExpired {
+		t.Error("accepted expired manifest:", err)
+	}
+
+	// an older manifest cannot replace a newer one
+
+	newer, _ := rotator.Manifest(2, now.Add(7*24*time.Hour))
+	if err = manifest.CheckSequence(newer); err != ErrorStaleManifest {
+		t.Error("accepted manifest older than the one in use:", err)
+	}
+	if err = newer.CheckSequence(manifest); err != nil {
+		t.Error("refused newer manifest:", err)
+	}
+	if err = manifest.CheckSequence(nil); err != nil {
+		t.Error("refused first manifest:", err)
+	}
+
+	// and one with the same sequence number must be the same manifest
+
+	if err = manifest.CheckSequence(signed); err != nil {
+		t.Error("refused the manifest in use:", err)
+	}
+
+	tagged := *signed
+	tagged.Keys = tagged.Keys[1:]
+	if err = tagged.CheckSequence(manifest); err != ErrorConflictingManifest {
+		t.Error("accepted a different manifest with the same sequence:", err)
+	}
+
+	otherRoot, _ := NewRootKey()
+	if _, err = VerifyManifest(data, otherRoot.Public().(ed25519.PublicKey)); err != ErrorInvalidSignature {
+		t.Error("accepted manifest signed by another root:", err)
+	}
+}
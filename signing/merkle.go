@@ -0,0 +1,173 @@
+package signing
+
+// Merkle tree hashes and proofs as defined in RFC 6962, section 2.1.
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+func merkleLeafHash(entry []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{0})
+	hash.Write(entry)
+	return hash.Sum(nil)
+}
+
+func merkleNodeHash(left []byte, right []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{1})
+	hash.Write(left)
+	hash.Write(right)
+	return hash.Sum(nil)
+}
+
+// largestPowerOfTwoBelow returns the largest power of two smaller than n,
+// for n > 1.
+func largestPowerOfTwoBelow(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// merkleRoot is MTH over the leaf hashes.
+func merkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		hash := sha256.Sum256(nil)
+		return hash[:]
+	case 1:
+		return leaves[0]
+	}
+
+	k := largestPowerOfTwoBelow(uint64(len(leaves)))
+	return merkleNodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+// merkleInclusionProof is PATH(m, D[n]) over the leaf hashes.
+func merkleInclusionProof(m uint64, leaves [][]byte) [][]byte {
+	n := uint64(len(leaves))
+	if n <= 1 {
+		return nil
+	}
+
+	k := largestPowerOfTwoBelow(n)
+	if m < k {
+		return append(merkleInclusionProof(m, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+	return append(merkleInclusionProof(m-k, leaves[k:]), merkleRoot(leaves[:k]))
+}
+
+// merkleConsistencyProof is SUBPROOF(m, D[n], complete) over the leaf hashes.
+func merkleConsistencyProof(m uint64, leaves [][]byte, complete bool) [][]byte {
+	n := uint64(len(leaves))
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{merkleRoot(leaves)}
+	}
+
+	k := largestPowerOfTwoBelow(n)
+	if m <= k {
+		return append(merkleConsistencyProof(m, leaves[:k], complete), merkleRoot(leaves[k:]))
+	}
+	return append(merkleConsistencyProof(m-k, leaves[k:], false), merkleRoot(leaves[:k]))
+}
+
+// VerifyInclusion checks that the leaf with leafHash is at index in the tree
+// of the given size and root.
+func VerifyInclusion(leafHash []byte, index uint64, size uint64, root []byte, proof [][]byte) error {
+	if index >= size {
+		return ErrorInvalidProof
+	}
+
+	fn, sn := index, size-1
+	hash := leafHash
+
+	for _, node := range proof {
+		if sn == 0 {
+			return ErrorInvalidProof
+		}
+
+		if fn&1 == 1 || fn == sn {
+			hash = merkleNodeHash(node, hash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			hash = merkleNodeHash(hash, node)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(hash, root) {
+		return ErrorInvalidProof
+	}
+
+	return nil
+}
+
+// VerifyConsistency checks that the tree of size first and root firstRoot
+// is a prefix of the tree of size second and root secondRoot.
+func VerifyConsistency(first uint64, second uint64, firstRoot []byte, secondRoot []byte, proof [][]byte) error {
+	switch {
+	case first > second:
+		return ErrorInvalidProof
+	case first == second:
+		if len(proof) != 0 || !bytes.Equal(firstRoot, secondRoot) {
+			return ErrorInvalidProof
+		}
+		return nil
+	case first == 0:
+		if len(proof) != 0 {
+			return ErrorInvalidProof
+		}
+		return nil
+	case len(proof) == 0:
+		return ErrorInvalidProof
+	}
+
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	firstHash, secondHash := proof[0], proof[0]
+
+	for _, node := range proof[1:] {
+		if sn == 0 {
+			return ErrorInvalidProof
+		}
+
+		if fn&1 == 1 || fn == sn {
+			firstHash = merkleNodeHash(node, firstHash)
+			secondHash = merkleNodeHash(node, secondHash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			secondHash = merkleNodeHash(secondHash, node)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 || !bytes.Equal(firstHash, firstRoot) || !bytes.Equal(secondHash, secondRoot) {
+		return ErrorInvalidProof
+	}
+
+	return nil
+}
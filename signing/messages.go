@@ -1,32 +1,35 @@
 package signing
 
 import (
-	"bytes"
-	"encoding/gob"
+	"crypto/elliptic"
 	"io/ioutil"
 	"math/big"
 )
 
 type Signature struct {
-	P *big.Int
-	W *big.Int
-	O *big.Int
-	G *big.Int
+	Curve elliptic.Curve
+	P     *big.Int
+	W     *big.Int
+	O     *big.Int
+	G     *big.Int
 }
 
 type Message1 struct {
+	Curve  elliptic.Curve
 	Ax, Ay *big.Int
 	Bx, By *big.Int
 }
 
 type Message2 struct {
-	E *big.Int
+	Curve elliptic.Curve
+	E     *big.Int
 }
 
 type Message3 struct {
-	R *big.Int
-	C *big.Int
-	S *big.Int
+	Curve elliptic.Curve
+	R     *big.Int
+	C     *big.Int
+	S     *big.Int
 }
 
 func LoadSignature(filename string) (*Signature, error) {
@@ -36,89 +39,76 @@ func LoadSignature(filename string) (*Signature, error) {
 	}
 
 	var sig Signature
-	var buffer bytes.Buffer
-	buffer.Write(data)
-	decoder := gob.NewDecoder(&buffer)
-	decoder.Decode(&sig)
+	decodeError := sig.UnmarshalBinary(data)
+	if decodeError != nil {
+		return nil, decodeError
+	}
 
 	return &sig, nil
 }
 
 func (sig Signature) Save(filename string) error {
-	var buffer bytes.Buffer        // Stand-in for a network connection
-	encoder := gob.NewEncoder(&buffer)
-	encodingError := encoder.Encode(sig)
+	data, encodingError := sig.MarshalBinary()
 	if encodingError != nil {
 		return encodingError
 	}
 
-	requestWriteError := ioutil.WriteFile(filename, buffer.Bytes(), 0644)
-	if requestWriteError != nil {
-		return requestWriteError
-	}
-
-	return nil
+	return ioutil.WriteFile(filename, data, 0644)
 }
 
 func Message1FromBytes(data []byte) (*Message1, error) {
 	var msg1 Message1
-	var buffer bytes.Buffer
-	buffer.Write(data)
-	decoder := gob.NewDecoder(&buffer)
-	decoder.Decode(&msg1)
+	decodeError := msg1.UnmarshalBinary(data)
+	if decodeError != nil {
+		return nil, decodeError
+	}
 
 	return &msg1, nil
 }
 
 func (sig *Message1) Bytes() []byte {
-	var buffer bytes.Buffer        // Stand-in for a network connection
-	encoder := gob.NewEncoder(&buffer)
-	encodingError := encoder.Encode(sig)
+	data, encodingError := sig.MarshalBinary()
 	if encodingError != nil {
 		return nil
 	}
 
-	return buffer.Bytes()
+	return data
 }
 
 func Message2FromBytes(data []byte) (*Message2, error) {
 	var msg2 Message2
-	var buffer bytes.Buffer
-	buffer.Write(data)
-	decoder := gob.NewDecoder(&buffer)
-	decoder.Decode(&msg2)
+	decodeError := msg2.UnmarshalBinary(data)
+	if decodeError != nil {
+		return nil, decodeError
+	}
 
 	return &msg2, nil
 }
 
 func (sig *Message2) Bytes() []byte {
-	var buffer bytes.Buffer        // Stand-in for a network connection
-	encoder := gob.NewEncoder(&buffer)
-	encodingError := encoder.Encode(sig)
+	data, encodingError := sig.MarshalBinary()
 	if encodingError != nil {
 		return nil
 	}
 
-	return buffer.Bytes()
+	return data
 }
 
 func Message3FromBytes(data []byte) (*Message3, error) {
 	var msg3 Message3
-	var buffer bytes.Buffer
-	buffer.Write(data)
-	decoder := gob.NewDecoder(&buffer)
-	decoder.Decode(&msg3)
+	decodeError := msg3.UnmarshalBinary(data)
+	if decodeError != nil {
+		return nil, decodeError
+	}
 
 	return &msg3, nil
 }
 
 func (sig *Message3) Bytes() []byte {
-	var buffer bytes.Buffer        // Stand-in for a network connection
-	encoder := gob.NewEncoder(&buffer)
-	encodingError := encoder.Encode(sig)
+	data, encodingError := sig.MarshalBinary()
 	if encodingError != nil {
 		return nil
 	}
 
-	return buffer.Bytes()
+	return data
 }
@@ -0,0 +1,207 @@
+package signing
+
+// Migration of files written before curves were persisted by name.
+//
+// Those files gob-encoded elliptic.Curve interface values, which only
+// decoded if the caller had called gob.Register for the curve, and only with
+// Go 1.18 or earlier: since then the standard library curves have no
+// exported fields and cannot be gob-encoded at all. The types below are
+// registered under the names those Go versions used, so the old files decode
+// and the curve is then replaced by the registry curve of the same name.
+
+import (
+	"crypto/elliptic"
+	"encoding/gob"
+	"math/big"
+)
+
+type legacyP224Curve struct {
+	*elliptic.CurveParams
+}
+
+type legacyP256Curve struct {
+	*elliptic.CurveParams
+}
+
+func init() {
+	gob.RegisterName("crypto/elliptic.p224Curve", legacyP224Curve{})
+	gob.RegisterName("crypto/elliptic.p256Curve", legacyP256Curve{})
+	gob.RegisterName("*crypto/elliptic.CurveParams", &elliptic.CurveParams{})
+}
+
+type legacyPublicKey struct {
+	Curve elliptic.Curve
+	X, Y  *big.Int
+}
+
+type legacySecretKey struct {
+	Curve  elliptic.Curve
+	Scalar *big.Int
+}
+
+type legacyInfo struct {
+	Curve elliptic.Curve
+	X, Y  *big.Int
+}
+
+type legacySignature struct {
+	P, W, O, G *big.Int
+}
+
+type legacyStateSigner struct {
+	State      int
+	Info       legacyInfo
+	Curve      elliptic.Curve
+	Sk         legacySecretKey
+	U, S, D, E *big.Int
+}
+
+type legacyStateRequester struct {
+	State             int
+	Info              legacyInfo
+	Message           []byte
+	Curve             elliptic.Curve
+	Pk                *legacyPublicKey
+	T1, T2, T3, T4, E *big.Int
+	Sig               legacySignature
+}
+
+func migrateCurve(curve elliptic.Curve) (elliptic.Curve, error) {
+	if curve == nil {
+		return nil, nil
+	}
+
+	return curveFromName(curve.Params().Name)
+}
+
+func (legacy legacyPublicKey) migrate() (*PublicKey, error) {
+	curve, err := migrateCurve(legacy.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PublicKey{Curve: curve, X: legacy.X, Y: legacy.Y}, nil
+}
+
+func (legacy legacySecretKey) migrate() (*SecretKey, error) {
+	curve, err := migrateCurve(legacy.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretKey{Curve: curve, Scalar: legacy.Scalar, shared: newKeyState()}, nil
+}
+
+// migrate converts legacy info, which was stored without a curve before
+// CompressInfo recorded one, taking the curve of the enclosing state.
+func (legacy legacyInfo) migrate(curve elliptic.Curve) (Info, error) {
+	if legacy.Curve != nil {
+		var err error
+		if curve, err = migrateCurve(legacy.Curve); err != nil {
+			return Info{}, err
+		}
+	}
+
+	return Info{Curve: curve, X: legacy.X, Y: legacy.Y}, nil
+}
+
+func loadLegacyPublicKey(data []byte) (*PublicKey, error) {
+	var legacy legacyPublicKey
+	if err := gobDecode(data, &legacy); err != nil {
+		return nil, err
+	}
+
+	return legacy.migrate()
+}
+
+func loadLegacySecretKey(data []byte) (*SecretKey, error) {
+	var legacy legacySecretKey
+	if err := gobDecode(data, &legacy); err != nil {
+		return nil, err
+	}
+
+	return legacy.migrate()
+}
+
+func loadLegacySigner(data []byte) (*StateSigner, error) {
+	var legacy legacyStateSigner
+	if err := gobDecode(data, &legacy); err != nil {
+		return nil, err
+	}
+
+	curve, err := migrateCurve(legacy.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := legacy.Info.migrate(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	sk, err := legacy.Sk.migrate()
+	if err != nil {
+		return nil, err
+	}
+
+	signer := &StateSigner{
+		State: legacy.State,
+		Info:  info,
+		Curve: curve,
+		U:     legacy.U,
+		S:     legacy.S,
+		D:     legacy.D,
+		E:     legacy.E,
+	}
+
+	if err = signer.attachSecretKey(sk); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+func loadLegacyRequester(data []byte) (*StateRequester, error) {
+	var legacy legacyStateRequester
+	if err := gobDecode(data, &legacy); err != nil {
+		return nil, err
+	}
+
+	curve, err := migrateCurve(legacy.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := legacy.Info.migrate(curve)
+	if err != nil {
+		return nil, err
+	}
+
+	st := StateRequester{
+		State:   legacy.State,
+		Info:    info,
+		Message: legacy.Message,
+		Curve:   curve,
+		T1:      legacy.T1,
+		T2:      legacy.T2,
+		T3:      legacy.T3,
+		T4:      legacy.T4,
+		E:       legacy.E,
+	}
+
+	if legacy.Pk != nil {
+		if st.Pk, err = legacy.Pk.migrate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if legacy.Sig.P != nil {
+		st.Sig = Signature{
+			P: legacy.Sig.P, W: legacy.Sig.W,
+			O: legacy.Sig.O, G: legacy.Sig.G,
+			Curve: curve,
+		}
+	}
+
+	return &st, nil
+}
@@ -0,0 +1,36 @@
+//go:build linux
+// +build linux
+
+package signing
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockMemory maps at least size bytes of fresh pages and locks them. mlock
+// works on whole pages and does not nest, so every locked secret gets pages
+// of its own that nothing else unlocks.
+func lockMemory(size int) ([]byte, error) {
+	pageSize := os.Getpagesize()
+	size = (size + pageSize - 1) / pageSize * pageSize
+
+	memory, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = syscall.Mlock(memory); err != nil {
+		syscall.Munmap(memory)
+		return nil, err
+	}
+
+	return memory, nil
+}
+
+// unlockMemory wipes and unmaps pages from lockMemory, which unlocks them.
+func unlockMemory(memory []byte) error {
+	wipeBytes(memory)
+
+	return syscall.Munmap(memory)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package signing
+
+// Memory locking is only implemented on Linux.
+
+func lockMemory(size int) ([]byte, error) {
+	return nil, ErrorMemoryLock
+}
+
+func unlockMemory(memory []byte) error {
+	return nil
+}
@@ -0,0 +1,47 @@
+package signing
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"sync"
+)
+
+// NullifierStore remembers redeemed tokens so each can be used only once.
+type NullifierStore interface {
+	// Redeem records the nullifier, failing with ErrorAlreadyRedeemed if it
+	// has been seen before.
+	Redeem(nullifier []byte) error
+}
+
+type MemoryNullifierStore struct {
+	mutex sync.Mutex
+	seen  map[string]bool
+}
+
+func NewMemoryNullifierStore() *MemoryNullifierStore {
+	return &MemoryNullifierStore{seen: make(map[string]bool)}
+}
+
+func (store *MemoryNullifierStore) Redeem(nullifier []byte) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.seen[string(nullifier)] {
+		return ErrorAlreadyRedeemed
+	}
+
+	store.seen[string(nullifier)] = true
+
+	return nil
+}
+
+// Nullifier identifies a signed message under info. It depends only on what
+// the signature covers, so a requester who obtains several signatures on the
+// same message still redeems it once.
+func Nullifier(curve elliptic.Curve, info Info, message []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("PBLIND-NULLIFIER"))
+	h.Write(elliptic.Marshal(curve, info.X, info.Y))
+	h.Write(message)
+	return h.Sum(nil)
+}
@@ -0,0 +1,35 @@
+package signing
+
+// Options.
+//
+// NewSecretKey, CreateSigner and CreateRequester take functional options.
+// WithRandom replaces crypto/rand.Reader as the source of the key, the
+// signer nonces and the blinding factors, so tests can use fixed values and
+// compare every intermediate value with known answers. Anything but a
+// cryptographically secure reader breaks the security of the protocol.
+
+import (
+	"crypto/rand"
+	"io"
+)
+
+type Option func(*options)
+
+type options struct {
+	random io.Reader
+}
+
+// WithRandom reads random scalars from random instead of crypto/rand.
+func WithRandom(random io.Reader) Option {
+	return func(opts *options) {
+		opts.random = random
+	}
+}
+
+func applyOptions(opts []Option) options {
+	result := options{random: rand.Reader}
+	for _, opt := range opts {
+		opt(&result)
+	}
+	return result
+}
@@ -0,0 +1,33 @@
+//go:build linux
+// +build linux
+
+package signing
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials reads the credentials of the peer process with
+// SO_PEERCRED, as they were when it connected.
+func peerCredentials(connection *net.UnixConn) (PeerCredentials, error) {
+	raw, err := connection.SyscallConn()
+	if err != nil {
+		return PeerCredentials{}, err
+	}
+
+	var ucred *syscall.Ucred
+	var credError error
+
+	err = raw.Control(func(fd uintptr) {
+		ucred, credError = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return PeerCredentials{}, err
+	}
+	if credError != nil {
+		return PeerCredentials{}, credError
+	}
+
+	return PeerCredentials{PID: int(ucred.Pid), UID: int(ucred.Uid), GID: int(ucred.Gid)}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package signing
+
+import (
+	"net"
+)
+
+// peerCredentials is only implemented on Linux. Elsewhere every peer is
+// refused.
+func peerCredentials(connection *net.UnixConn) (PeerCredentials, error) {
+	return PeerCredentials{}, ErrorPeerCredentials
+}
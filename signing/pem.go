@@ -0,0 +1,199 @@
+package signing
+
+// PEM key files.
+//
+// Public keys are the DER PblindPublicKeyInfo in a "PBLIND PUBLIC KEY"
+// block. Secret keys are the DER PblindPrivateKeyInfo in a
+// "PBLIND PRIVATE KEY" block, sealed with ChaCha20-Poly1305 under a key
+// derived from a passphrase with scrypt. The KDF parameters, salt and nonce
+// travel in the PEM headers, which are also authenticated.
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	pemPublicKey  = "PBLIND PUBLIC KEY"
+	pemPrivateKey = "PBLIND PRIVATE KEY"
+)
+
+// Requester states are sealed the same way in a "PBLIND REQUESTER STATE"
+// block holding their gob encoding. The blinding factors in them link a
+// signature to its issuance session.
+const pemRequesterState = "PBLIND REQUESTER STATE"
+
+const (
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	scryptMaxP    = 4
+	scryptMaxMem  = 256 << 20 // bytes, 128*N*r
+	scryptSaltLen = 16
+)
+
+// PassphraseFunc is asked for the passphrase of an encrypted secret key.
+type PassphraseFunc func() ([]byte, error)
+
+func (pk *PublicKey) MarshalPEM() ([]byte, error) {
+	der, err := pk.MarshalDER()
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemPublicKey, Bytes: der}), nil
+}
+
+func ParsePublicKeyPEM(data []byte) (*PublicKey, error) {
+	block, rest := pem.Decode(data)
+	if block == nil || block.Type != pemPublicKey || len(block.Headers) != 0 || len(strings.TrimSpace(string(rest))) != 0 {
+		return nil, ErrorInvalidEncoding
+	}
+
+	return ParsePublicKeyDER(block.Bytes)
+}
+
+// MarshalPEM encodes the secret key encrypted under passphrase, which must
+// not be empty.
+func (sk *SecretKey) MarshalPEM(passphrase []byte) ([]byte, error) {
+	der, err := sk.MarshalDER()
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(der)
+
+	return sealPEM(pemPrivateKey, der, passphrase)
+}
+
+// ParseSecretKeyPEM decodes a secret key encrypted by MarshalPEM, calling
+// passphrase for its passphrase. Unencrypted keys are refused.
+func ParseSecretKeyPEM(data []byte, passphrase PassphraseFunc) (*SecretKey, error) {
+	der, err := openPEM(pemPrivateKey, data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(der)
+
+	return ParseSecretKeyDER(der)
+}
+
+// sealPEM encodes der in a block of the given type, encrypted under
+// passphrase. Secrets are never written unencrypted, so an empty
+// passphrase is refused.
+func sealPEM(blockType string, der []byte, passphrase []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrorPassphraseRequired
+	}
+
+	salt := make([]byte, scryptSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{
+		"KDF":        "scrypt",
+		"KDF-Params": fmt.Sprintf("N=%d,r=%d,p=%d", scryptN, scryptR, scryptP),
+		"Salt":       hex.EncodeToString(salt),
+		"Cipher":     "chacha20poly1305",
+		"Nonce":      hex.EncodeToString(nonce),
+	}
+
+	aead, err := pemCipher(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := aead.Seal(nil, nonce, der, pemAdditionalData(blockType, headers))
+
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Headers: headers, Bytes: sealed}), nil
+}
+
+// openPEM decodes a block of the given type written by sealPEM. A block
+// without encryption headers is refused rather than taken as plaintext, so
+// a secret file that was swapped for an unencrypted one is noticed.
+func openPEM(blockType string, data []byte, passphrase PassphraseFunc) ([]byte, error) {
+	block, rest := pem.Decode(data)
+	if block == nil || block.Type != blockType || len(strings.TrimSpace(string(rest))) != 0 {
+		return nil, ErrorInvalidEncoding
+	}
+
+	if len(block.Headers) == 0 {
+		return nil, ErrorUnencryptedSecret
+	}
+
+	if len(block.Headers) != 5 || block.Headers["KDF"] != "scrypt" || block.Headers["Cipher"] != "chacha20poly1305" {
+		return nil, ErrorInvalidEncoding
+	}
+
+	var n, r, p int
+	if _, err := fmt.Sscanf(block.Headers["KDF-Params"], "N=%d,r=%d,p=%d", &n, &r, &p); err != nil {
+		return nil, ErrorInvalidEncoding
+	}
+
+	// the file is untrusted, so it must not ask for unbounded work
+	if n < 2 || n&(n-1) != 0 || r < 1 || p < 1 || p > scryptMaxP || n > scryptMaxMem/128/r {
+		return nil, ErrorInvalidEncoding
+	}
+
+	salt, saltError := hex.DecodeString(block.Headers["Salt"])
+	nonce, nonceError := hex.DecodeString(block.Headers["Nonce"])
+	if saltError != nil || nonceError != nil || len(salt) != scryptSaltLen || len(nonce) != chacha20poly1305.NonceSize {
+		return nil, ErrorInvalidEncoding
+	}
+
+	if passphrase == nil {
+		return nil, ErrorPassphraseRequired
+	}
+
+	secret, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := pemCipher(secret, salt, n, r, p)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := aead.Open(nil, nonce, block.Bytes, pemAdditionalData(blockType, block.Headers))
+	if err != nil {
+		return nil, ErrorInvalidPassphrase
+	}
+
+	return der, nil
+}
+
+func pemCipher(passphrase []byte, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, n, r, p, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return chacha20poly1305.New(key)
+}
+
+// pemAdditionalData binds the block type and headers to the ciphertext.
+func pemAdditionalData(blockType string, headers map[string]string) []byte {
+	ad := []byte(blockType)
+	for _, key := range []string{"KDF", "KDF-Params", "Salt", "Cipher", "Nonce"} {
+		ad = appendLengthPrefixed(ad, []byte(key))
+		ad = appendLengthPrefixed(ad, []byte(headers[key]))
+	}
+	return ad
+}
+
+func isPEM(data []byte) bool {
+	return strings.HasPrefix(strings.TrimSpace(string(data)), "-----BEGIN ")
+}
@@ -0,0 +1,284 @@
+package signing
+
+// Gob encoding for the persisted types. Curves are written by their registry
+// name instead of as interface values, so files do not depend on
+// gob.Register or on the concrete types the standard library uses.
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math/big"
+)
+
+type gobPublicKey struct {
+	Curve string
+	X, Y  *big.Int
+}
+
+type gobSecretKey struct {
+	Curve  string
+	Scalar *big.Int
+}
+
+type gobInfo struct {
+	Curve string
+	X, Y  *big.Int
+}
+
+type gobSignature struct {
+	Curve      string
+	P, W, O, G *big.Int
+}
+
+type gobStateSigner struct {
+	State      int
+	Info       Info
+	Curve      string
+	Sk         *SecretKey // only in states saved before key backends
+	KeyID      []byte
+	U, S, D, E *big.Int
+}
+
+type gobStateRequester struct {
+	State             int
+	Info              Info
+	Message           []byte
+	Curve             string
+	Pk                *PublicKey
+	T1, T2, T3, T4, E *big.Int
+	Sig               Signature
+	Seed              []byte // replaces T1..T4 of seeded requesters
+}
+
+func gobEncode(value interface{}) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	encodingError := encoder.Encode(value)
+	if encodingError != nil {
+		return nil, encodingError
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func gobDecode(data []byte, value interface{}) error {
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	return decoder.Decode(value)
+}
+
+func (pk PublicKey) GobEncode() ([]byte, error) {
+	name, err := curveName(pk.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return gobEncode(gobPublicKey{Curve: name, X: pk.X, Y: pk.Y})
+}
+
+func (pk *PublicKey) GobDecode(data []byte) error {
+	var decoded gobPublicKey
+	if err := gobDecode(data, &decoded); err != nil {
+		return err
+	}
+
+	curve, err := curveFromName(decoded.Curve)
+	if err != nil {
+		return err
+	}
+
+	*pk = PublicKey{Curve: curve, X: decoded.X, Y: decoded.Y}
+
+	return nil
+}
+
+func (sk SecretKey) GobEncode() ([]byte, error) {
+	name, err := curveName(sk.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return gobEncode(gobSecretKey{Curve: name, Scalar: sk.Scalar})
+}
+
+func (sk *SecretKey) GobDecode(data []byte) error {
+	var decoded gobSecretKey
+	if err := gobDecode(data, &decoded); err != nil {
+		return err
+	}
+
+	curve, err := curveFromName(decoded.Curve)
+	if err != nil {
+		return err
+	}
+
+	*sk = SecretKey{Curve: curve, Scalar: decoded.Scalar, shared: newKeyState()}
+
+	return nil
+}
+
+func (info Info) GobEncode() ([]byte, error) {
+	name, err := curveName(info.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return gobEncode(gobInfo{Curve: name, X: info.X, Y: info.Y})
+}
+
+func (info *Info) GobDecode(data []byte) error {
+	var decoded gobInfo
+	if err := gobDecode(data, &decoded); err != nil {
+		return err
+	}
+
+	curve, err := curveFromName(decoded.Curve)
+	if err != nil {
+		return err
+	}
+
+	*info = Info{Curve: curve, X: decoded.X, Y: decoded.Y}
+
+	return nil
+}
+
+func (sig Signature) GobEncode() ([]byte, error) {
+	name, err := curveName(sig.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return gobEncode(gobSignature{Curve: name, P: sig.P, W: sig.W, O: sig.O, G: sig.G})
+}
+
+func (sig *Signature) GobDecode(data []byte) error {
+	var decoded gobSignature
+	if err := gobDecode(data, &decoded); err != nil {
+		return err
+	}
+
+	curve, err := curveFromName(decoded.Curve)
+	if err != nil {
+		return err
+	}
+
+	*sig = Signature{Curve: curve, P: decoded.P, W: decoded.W, O: decoded.O, G: decoded.G}
+
+	return nil
+}
+
+func (st StateSigner) GobEncode() ([]byte, error) {
+	name, err := curveName(st.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	return gobEncode(gobStateSigner{
+		State: st.State,
+		Info:  st.Info,
+		Curve: name,
+		KeyID: st.keyID,
+		U:     st.U,
+		S:     st.S,
+		D:     st.D,
+		E:     st.E,
+	})
+}
+
+func (st *StateSigner) GobDecode(data []byte) error {
+	var decoded gobStateSigner
+	if err := gobDecode(data, &decoded); err != nil {
+		return err
+	}
+
+	curve, err := curveFromName(decoded.Curve)
+	if err != nil {
+		return err
+	}
+
+	*st = StateSigner{
+		State: decoded.State,
+		Info:  decoded.Info,
+		Curve: curve,
+		U:     decoded.U,
+		S:     decoded.S,
+		D:     decoded.D,
+		E:     decoded.E,
+		keyID: decoded.KeyID,
+	}
+
+	if decoded.Sk != nil {
+		return st.attachSecretKey(decoded.Sk)
+	}
+
+	return nil
+}
+
+// attachSecretKey makes sk, read from an old state file, the key of st.
+func (st *StateSigner) attachSecretKey(sk *SecretKey) error {
+	keyID, err := sk.GetPublicKey().Fingerprint()
+	if err != nil {
+		return err
+	}
+
+	st.Key = sk
+	st.keyID = keyID
+
+	return nil
+}
+
+func (st StateRequester) GobEncode() ([]byte, error) {
+	name, err := curveName(st.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := gobStateRequester{
+		State:   st.State,
+		Info:    st.Info,
+		Message: st.Message,
+		Curve:   name,
+		Pk:      st.Pk,
+		E:       st.E,
+		Sig:     st.Sig,
+		Seed:    st.seed,
+	}
+
+	if st.seed == nil {
+		encoded.T1, encoded.T2, encoded.T3, encoded.T4 = st.T1, st.T2, st.T3, st.T4
+	}
+
+	return gobEncode(encoded)
+}
+
+func (st *StateRequester) GobDecode(data []byte) error {
+	var decoded gobStateRequester
+	if err := gobDecode(data, &decoded); err != nil {
+		return err
+	}
+
+	curve, err := curveFromName(decoded.Curve)
+	if err != nil {
+		return err
+	}
+
+	*st = StateRequester{
+		State:   decoded.State,
+		Info:    decoded.Info,
+		Message: decoded.Message,
+		Curve:   curve,
+		Pk:      decoded.Pk,
+		T1:      decoded.T1,
+		T2:      decoded.T2,
+		T3:      decoded.T3,
+		T4:      decoded.T4,
+		E:       decoded.E,
+		Sig:     decoded.Sig,
+		seed:    decoded.Seed,
+	}
+
+	if st.seed != nil {
+		return st.deriveBlindingFactors()
+	}
+
+	return nil
+}
@@ -0,0 +1,334 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPersistedInteraction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	requesterFile := filepath.Join(dir, "requester")
+	signerFile := filepath.Join(dir, "signer")
+
+	for _, curve := range []elliptic.Curve{elliptic.P224(), elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		sk, _ := NewSecretKey(curve)
+		pk := sk.GetPublicKey()
+		info, _ := CompressInfo(curve, []byte("info"))
+
+		requester, _ := CreateRequester(pk, info, []byte("message"))
+		signer, _ := CreateSigner(*sk, info)
+
+		keys := func(keyID []byte) (KeyBackend, error) { return sk, nil }
+		passphrase := func() ([]byte, error) { return []byte("passphrase"), nil }
+
+		// every state is saved and reloaded between protocol steps
+
+		reload := func() {
+			if err := requester.Save(requesterFile, []byte("passphrase")); err != nil {
+				t.Fatal("failed to save requester:", err)
+			}
+			if err := signer.Save(signerFile); err != nil {
+				t.Fatal("failed to save signer:", err)
+			}
+			if requester, err = LoadRequester(requesterFile, passphrase); err != nil {
+				t.Fatal("failed to load requester:", err)
+			}
+			if signer, err = LoadSigner(signerFile, keys); err != nil {
+				t.Fatal("failed to load signer:", err)
+			}
+		}
+
+		reload()
+		msg1, _ := signer.CreateMessage1()
+		reload()
+		if err = requester.ProcessMessage1(msg1); err != nil {
+			t.Fatal("failed to process msg1:", err)
+		}
+		reload()
+		msg2, _ := requester.CreateMessage2()
+		if err = signer.ProcessMessage2(msg2); err != nil {
+			t.Fatal("failed to process msg2:", err)
+		}
+		reload()
+		msg3, _ := signer.CreateMessage3()
+		if err = requester.ProcessMessage3(msg3); err != nil {
+			t.Fatal("failed to process msg3:", err)
+		}
+		reload()
+
+		sig, err := requester.Signature()
+		if err != nil {
+			t.Fatal("failed to obtain signature:", err)
+		}
+
+		if requester.Curve != curve || requester.Info.Curve != curve || requester.Sig.Curve != curve {
+			t.Error("curve not restored from registry")
+		}
+
+		if !pk.Check(sig, info, []byte("message")) {
+			t.Error("failed to validate signature")
+		}
+	}
+}
+
+func TestLegacyMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	curve := elliptic.P256()
+	legacyCurve := legacyP256Curve{curve.Params()}
+
+	sk, _ := NewSecretKey(curve)
+	pk := sk.GetPublicKey()
+	info, _ := CompressInfo(curve, []byte("info"))
+	requester, _ := CreateRequester(pk, info, []byte("message"))
+
+	// encode as Go 1.18 did after gob.Register(elliptic.P256())
+
+	legacyKey := legacyPublicKey{Curve: legacyCurve, X: pk.X, Y: pk.Y}
+	legacyState := legacyStateRequester{
+		State:   requester.State,
+		Info:    legacyInfo{X: info.X, Y: info.Y},
+		Message: requester.Message,
+		Curve:   legacyCurve,
+		Pk:      &legacyKey,
+		T1:      requester.T1,
+		T2:      requester.T2,
+		T3:      requester.T3,
+		T4:      requester.T4,
+	}
+
+	keyData, err := gobEncode(&legacyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateData, err := gobEncode(&legacyState)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "signer.public"), keyData, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "request.0"), stateData, 0644)
+
+	loadedKey, err := LoadPublicKey(filepath.Join(dir, "signer.public"))
+	if err != nil {
+		t.Fatal("failed to migrate public key:", err)
+	}
+
+	if loadedKey.Curve != curve || loadedKey.X.Cmp(pk.X) != 0 {
+		t.Error("public key migrated incorrectly")
+	}
+
+	loaded, err := LoadRequester(filepath.Join(dir, "request.0"), nil)
+	if err != nil {
+		t.Fatal("failed to migrate requester:", err)
+	}
+
+	if loaded.Curve != curve || loaded.Info.Curve != curve || loaded.Pk.Curve != curve {
+		t.Error("requester curves not migrated")
+	}
+
+	// the migrated state completes the protocol
+
+	signer, _ := CreateSigner(*sk, info)
+	msg1, _ := signer.CreateMessage1()
+	if err = loaded.ProcessMessage1(msg1); err != nil {
+		t.Fatal("failed to process msg1:", err)
+	}
+	msg2, _ := loaded.CreateMessage2()
+	signer.ProcessMessage2(msg2)
+	msg3, _ := signer.CreateMessage3()
+	if err = loaded.ProcessMessage3(msg3); err != nil {
+		t.Fatal("failed to process msg3:", err)
+	}
+}
+
+func TestPEMKeyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "signer.secret")
+	publicFile := filepath.Join(dir, "signer.public")
+
+	sk, _ := NewSecretKey(elliptic.P384())
+	pk := sk.GetPublicKey()
+
+	if err = sk.Save(secretFile, []byte("correct horse")); err != nil {
+		t.Fatal("failed to save secret key:", err)
+	}
+
+	if err = pk.Save(publicFile); err != nil {
+		t.Fatal("failed to save public key:", err)
+	}
+
+	stat, err := os.Stat(secretFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Mode().Perm() != 0600 {
+		t.Error("secret key file has mode", stat.Mode().Perm())
+	}
+
+	loadedPk, err := LoadPublicKey(publicFile)
+	if err != nil {
+		t.Fatal("failed to load public key:", err)
+	}
+
+	if loadedPk.X.Cmp(pk.X) != 0 || loadedPk.Y.Cmp(pk.Y) != 0 {
+		t.Error("public key changed")
+	}
+
+	passphrase := func(value string) PassphraseFunc {
+		return func() ([]byte, error) { return []byte(value), nil }
+	}
+
+	if _, err = LoadSecretKey(secretFile, passphrase("wrong")); err != ErrorInvalidPassphrase {
+		t.Error("wrong passphrase accepted:", err)
+	}
+
+	if _, err = LoadSecretKey(secretFile, nil); err != ErrorPassphraseRequired {
+		t.Error("loaded encrypted key without passphrase:", err)
+	}
+
+	loadedSk, err := LoadSecretKey(secretFile, passphrase("correct horse"))
+	if err != nil {
+		t.Fatal("failed to load secret key:", err)
+	}
+
+	if loadedSk.Scalar.Cmp(sk.Scalar) != 0 || loadedSk.Curve != sk.Curve {
+		t.Error("secret key changed")
+	}
+
+	// the headers are authenticated
+
+	data, _ := ioutil.ReadFile(secretFile)
+	tampered := bytes.Replace(data, []byte("N=32768"), []byte("N=16384"), 1)
+	if _, err = ParseSecretKeyPEM(tampered, passphrase("correct horse")); err == nil {
+		t.Error("accepted tampered KDF parameters")
+	}
+
+	// untrusted files cannot ask for unbounded scrypt work
+
+	asked := false
+	counting := func() ([]byte, error) {
+		asked = true
+		return []byte("correct horse"), nil
+	}
+
+	for _, params := range []string{"N=1048576,r=32,p=1", "N=32768,r=8,p=16", "N=30000,r=8,p=1"} {
+		costly := bytes.Replace(data, []byte("N=32768,r=8,p=1"), []byte(params), 1)
+		if _, err = ParseSecretKeyPEM(costly, counting); err != ErrorInvalidEncoding || asked {
+			t.Error("accepted KDF parameters", params, err)
+		}
+	}
+
+	// secret keys are never written or read unencrypted
+
+	if err = sk.Save(secretFile, nil); err != ErrorPassphraseRequired {
+		t.Error("saved secret key without passphrase:", err)
+	}
+
+	der, err := sk.MarshalDER()
+	if err != nil {
+		t.Fatal("failed to encode secret key:", err)
+	}
+
+	plain := pem.EncodeToMemory(&pem.Block{Type: pemPrivateKey, Bytes: der})
+	if _, err = ParseSecretKeyPEM(plain, counting); err != ErrorUnencryptedSecret {
+		t.Error("accepted unencrypted secret key:", err)
+	}
+}
+
+func TestSealedRequester(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	requesterFile := filepath.Join(dir, "request.0")
+
+	sk, _ := NewSecretKey(elliptic.P256())
+	info, _ := CompressInfo(elliptic.P256(), []byte("info"))
+	requester, _ := CreateRequester(sk.GetPublicKey(), info, []byte("a memorable message"))
+
+	if err = requester.Save(requesterFile, nil); err != ErrorPassphraseRequired {
+		t.Fatal("saved requester without passphrase:", err)
+	}
+
+	if err = requester.Save(requesterFile, []byte("correct horse")); err != nil {
+		t.Fatal("failed to save requester:", err)
+	}
+
+	stat, err := os.Stat(requesterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stat.Mode().Perm() != 0600 {
+		t.Error("requester file has mode", stat.Mode().Perm())
+	}
+
+	data, _ := ioutil.ReadFile(requesterFile)
+	if bytes.Contains(data, []byte("a memorable message")) || bytes.Contains(data, requester.T1.Bytes()) {
+		t.Error("requester state not sealed")
+	}
+
+	passphrase := func(value string) PassphraseFunc {
+		return func() ([]byte, error) { return []byte(value), nil }
+	}
+
+	if _, err = LoadRequester(requesterFile, passphrase("wrong")); err != ErrorInvalidPassphrase {
+		t.Error("wrong passphrase accepted:", err)
+	}
+
+	loaded, err := LoadRequester(requesterFile, passphrase("correct horse"))
+	if err != nil {
+		t.Fatal("failed to load requester:", err)
+	}
+
+	if loaded.T4.Cmp(requester.T4) != 0 || !bytes.Equal(loaded.Message, requester.Message) {
+		t.Error("requester state changed")
+	}
+
+	state, err := gobEncode(requester)
+	if err != nil {
+		t.Fatal("failed to encode requester:", err)
+	}
+
+	plain := pem.EncodeToMemory(&pem.Block{Type: pemRequesterState, Bytes: state})
+	if err = ioutil.WriteFile(requesterFile, plain, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = LoadRequester(requesterFile, passphrase("correct horse")); err != ErrorUnencryptedSecret {
+		t.Error("accepted unsealed requester state in a PEM block:", err)
+	}
+
+	// stage files are wiped once they are no longer needed
+
+	if err = WipeFile(requesterFile); err != nil {
+		t.Fatal("failed to wipe requester:", err)
+	}
+
+	if _, err = os.Stat(requesterFile); !os.IsNotExist(err) {
+		t.Error("requester file not removed:", err)
+	}
+}
@@ -0,0 +1,224 @@
+package signing
+
+// Requester-side key pinning.
+//
+// A signer can tag users by handing each of them a different key. A
+// PinStore remembers the first key seen for every signer endpoint and
+// refuses a different one later, and it can cross-check keys with other
+// sources of the signer keys, such as a mirror of the key set or a manifest
+// fetched over another channel. A pinned key only changes when every source
+// confirms the new key, as after a rotation.
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// KeySource is an independent view of the keys of a signer endpoint.
+type KeySource interface {
+	EndpointKeys(endpoint string) ([]*PublicKey, error)
+}
+
+// MirrorSource fetches the key set of an endpoint from a mirror.
+type MirrorSource struct {
+	URLs map[string]string // key set URL by endpoint
+}
+
+type Pin struct {
+	KeyID     []byte
+	FirstSeen time.Time
+}
+
+type PinStore struct {
+	Sources  []KeySource
+	OnChange func(endpoint string, pinned []byte, seen []byte) // alert when the key of an endpoint changes
+	Now      func() time.Time                                  // time.Now if nil
+
+	mutex    sync.Mutex
+	filename string
+	pins     map[string]Pin
+}
+
+type jsonPin struct {
+	KeyID     string    `json:"kid"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+func (mirror MirrorSource) EndpointKeys(endpoint string) ([]*PublicKey, error) {
+	url, ok := mirror.URLs[endpoint]
+	if !ok {
+		return nil, ErrorUnknownKey
+	}
+
+	set, err := FetchKeySet(url)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*PublicKey, len(set.Keys))
+	for index, jwk := range set.Keys {
+		keys[index] = jwk.Key
+	}
+
+	return keys, nil
+}
+
+// EndpointKeys makes a manifest a KeySource for any endpoint. An expired
+// manifest fails.
+func (manifest *KeyManifest) EndpointKeys(endpoint string) ([]*PublicKey, error) {
+	var keys []*PublicKey
+
+	for _, key := range manifest.Keys {
+		if err := manifest.Check(key.Key); err == nil {
+			keys = append(keys, key.Key)
+		} else if err == ErrorManifestExpired {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+func NewPinStore() *PinStore {
+	return &PinStore{pins: make(map[string]Pin)}
+}
+
+// OpenPinStore returns a pin store kept in filename, which is created on
+// the first pin.
+func OpenPinStore(filename string) (*PinStore, error) {
+	store := NewPinStore()
+	store.filename = filename
+
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var encoded map[string]jsonPin
+	if err = json.Unmarshal(data, &encoded); err != nil {
+		return nil, ErrorInvalidEncoding
+	}
+
+	for endpoint, pin := range encoded {
+		keyID, err := jsonEncoding.DecodeString(pin.KeyID)
+		if err != nil || len(keyID) != keyIDSize {
+			return nil, ErrorInvalidKeyID
+		}
+
+		store.pins[endpoint] = Pin{KeyID: keyID, FirstSeen: pin.FirstSeen}
+	}
+
+	return store, nil
+}
+
+func (store *PinStore) now() time.Time {
+	if store.Now != nil {
+		return store.Now()
+	}
+	return time.Now()
+}
+
+func (store *PinStore) Lookup(endpoint string) (Pin, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	pin, ok := store.pins[endpoint]
+	return pin, ok
+}
+
+// Unpin forgets the key of endpoint, so the next key seen is trusted.
+func (store *PinStore) Unpin(endpoint string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.pins, endpoint)
+
+	return store.save()
+}
+
+// Check cross-checks pk with every source, then pins it for endpoint if no
+// key is pinned yet. A key other than the pinned one is reported to
+// OnChange and refused unless sources confirmed it.
+func (store *PinStore) Check(endpoint string, pk *PublicKey) error {
+	keyID, err := pk.Fingerprint()
+	if err != nil {
+		return err
+	}
+
+	for _, source := range store.Sources {
+		keys, err := source.EndpointKeys(endpoint)
+		if err != nil {
+			return err
+		}
+
+		if !containsKey(keys, keyID) {
+			return ErrorKeyMismatch
+		}
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	pin, ok := store.pins[endpoint]
+	if ok && string(pin.KeyID) == string(keyID) {
+		return nil
+	}
+
+	if ok {
+		if store.OnChange != nil {
+			store.OnChange(endpoint, pin.KeyID, keyID)
+		}
+
+		if len(store.Sources) == 0 {
+			return ErrorKeyChanged
+		}
+	}
+
+	store.pins[endpoint] = Pin{KeyID: keyID, FirstSeen: store.now()}
+
+	return store.save()
+}
+
+// CreateRequester is CreateRequester for a key that passes Check.
+func (store *PinStore) CreateRequester(endpoint string, pk *PublicKey, info Info, message []byte) (*StateRequester, error) {
+	if err := store.Check(endpoint, pk); err != nil {
+		return nil, err
+	}
+
+	return CreateRequester(pk, info, message)
+}
+
+func (store *PinStore) save() error {
+	if store.filename == "" {
+		return nil
+	}
+
+	encoded := make(map[string]jsonPin)
+	for endpoint, pin := range store.pins {
+		encoded[endpoint] = jsonPin{KeyID: jsonEncoding.EncodeToString(pin.KeyID), FirstSeen: pin.FirstSeen}
+	}
+
+	data, err := json.MarshalIndent(encoded, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(store.filename, data, 0600)
+}
+
+func containsKey(keys []*PublicKey, keyID []byte) bool {
+	for _, key := range keys {
+		fingerprint, err := key.Fingerprint()
+		if err == nil && string(fingerprint) == string(keyID) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,92 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPinStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pinFile := filepath.Join(dir, "pins.json")
+	endpoint := "signer.example:1234"
+
+	first, _ := NewSecretKey(elliptic.P256())
+	second, _ := NewSecretKey(elliptic.P256())
+	info, _ := CompressInfo(elliptic.P256(), []byte("info"))
+
+	store, err := OpenPinStore(pinFile)
+	if err != nil {
+		t.Fatal("failed to open pin store:", err)
+	}
+
+	if _, err = store.CreateRequester(endpoint, first.GetPublicKey(), info, []byte("message")); err != nil {
+		t.Fatal("refused first key:", err)
+	}
+
+	// the pin survives reopening and a changed key raises an alert
+
+	store, err = OpenPinStore(pinFile)
+	if err != nil {
+		t.Fatal("failed to reopen pin store:", err)
+	}
+
+	alerts := 0
+	store.OnChange = func(string, []byte, []byte) { alerts++ }
+
+	if err = store.Check(endpoint, first.GetPublicKey()); err != nil {
+		t.Error("refused pinned key:", err)
+	}
+
+	if _, err = store.CreateRequester(endpoint, second.GetPublicKey(), info, []byte("message")); err != ErrorKeyChanged {
+		t.Error("accepted changed key:", err)
+	}
+
+	if alerts != 1 {
+		t.Error("key change not reported")
+	}
+
+	// a mirror that sees another key refuses the key
+
+	set, _ := NewKeySet(first.GetPublicKey())
+	mirror := httptest.NewServer(set)
+	defer mirror.Close()
+
+	store.Sources = []KeySource{MirrorSource{URLs: map[string]string{endpoint: mirror.URL}}}
+
+	if err = store.Check(endpoint, second.GetPublicKey()); err != ErrorKeyMismatch {
+		t.Error("accepted key the mirror does not see:", err)
+	}
+
+	// a rotation confirmed by a manifest moves the pin
+
+	root, _ := NewRootKey()
+	signed := KeyManifest{Issued: time.Now(), Expires: time.Now().Add(time.Hour)}
+	signed.Add(second.GetPublicKey(), time.Time{}, time.Time{})
+	data, _ := signed.Sign(root)
+	manifest, err := VerifyManifest(data, root.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal("failed to verify manifest:", err)
+	}
+
+	store.Sources = []KeySource{manifest}
+
+	if err = store.Check(endpoint, second.GetPublicKey()); err != nil {
+		t.Error("refused confirmed key change:", err)
+	}
+
+	secondID, _ := second.GetPublicKey().Fingerprint()
+	if pin, _ := store.Lookup(endpoint); string(pin.KeyID) != string(secondID) || alerts != 2 {
+		t.Error("pin not moved to confirmed key")
+	}
+}
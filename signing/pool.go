@@ -0,0 +1,321 @@
+package signing
+
+// Commitment pool.
+//
+// The first message of the signer, a = U*g and b = S*g + D*z, costs three
+// scalar multiplications, none of which depend on the requester. A
+// CommitmentPool computes them ahead of time in background goroutines, so
+// Message1 is served with little latency: complete (U, S, D, a, b) entries
+// for each info registered with Add, and (U, S, a, S*g) entries for any
+// other info, which leave only D*z to compute online. If a pool is empty,
+// the commitment is computed inline.
+//
+// Entries are taken from channels, so each one is handed to exactly one
+// signer. The signer owns the nonces from then on and wipes them with
+// Destroy; the pool wipes everything else of an entry when it is taken,
+// and all entries still queued when it is closed or their info removed.
+
+import (
+	"crypto/elliptic"
+	"io"
+	"math/big"
+	"sync"
+)
+
+const defaultPoolSize = 16
+
+type CommitmentPool struct {
+	curve  elliptic.Curve
+	size   int
+	random io.Reader
+
+	randomMutex sync.Mutex // random need not be safe for concurrent use
+
+	mutex   sync.Mutex
+	generic chan *commitment
+	infos   map[string]*poolInfo // by compressed info point
+	done    chan struct{}
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// poolInfo holds the entries for an info until it is removed.
+type poolInfo struct {
+	entries chan *commitment
+	removed chan struct{}
+}
+
+// commitment is a pool entry. Entries for an info have D and the whole of
+// msg; generic entries have no D, only A in msg and S*g in sx, sy.
+type commitment struct {
+	U, S, D *big.Int
+	msg     Message1
+	sx, sy  *big.Int
+}
+
+// NewCommitmentPool starts precomputing commitments on curve, keeping size
+// entries for each info and as many generic ones. A size of 0 keeps 16.
+func NewCommitmentPool(curve elliptic.Curve, size int, opts ...Option) *CommitmentPool {
+	if size <= 0 {
+		size = defaultPoolSize
+	}
+
+	pool := &CommitmentPool{
+		curve:   curve,
+		size:    size,
+		random:  applyOptions(opts).random,
+		generic: make(chan *commitment, size),
+		infos:   make(map[string]*poolInfo),
+		done:    make(chan struct{}),
+	}
+
+	pool.workers.Add(1)
+	go pool.fill(pool.generic, nil, pool.createGeneric)
+
+	return pool
+}
+
+// Add starts precomputing complete commitments for info. Each info keeps
+// a goroutine and size entries until it is removed or the pool closed.
+func (pool *CommitmentPool) Add(info Info) error {
+	if info.Curve == nil || info.Curve.Params().Name != pool.curve.Params().Name || !pool.curve.IsOnCurve(info.X, info.Y) {
+		return ErrorPointNotOnCurve
+	}
+
+	name := string(marshalCompressed(pool.curve, info.X, info.Y))
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if pool.closed || pool.infos[name] != nil {
+		return nil
+	}
+
+	entries := &poolInfo{entries: make(chan *commitment, pool.size), removed: make(chan struct{})}
+	pool.infos[name] = entries
+
+	pool.workers.Add(1)
+	go pool.fill(entries.entries, entries.removed, func() (*commitment, error) { return pool.createForInfo(info) })
+
+	return nil
+}
+
+// Remove stops precomputing commitments for info and wipes its entries.
+// Signers for info then use generic entries.
+func (pool *CommitmentPool) Remove(info Info) {
+	if info.X == nil || info.Y == nil || !pool.curve.IsOnCurve(info.X, info.Y) {
+		return
+	}
+
+	name := string(marshalCompressed(pool.curve, info.X, info.Y))
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	entries := pool.infos[name]
+	if entries == nil {
+		return
+	}
+
+	delete(pool.infos, name)
+	close(entries.removed)
+
+	// the filler wipes what it queues later
+	drain(entries.entries)
+}
+
+// CreateSigner returns a signer for key and info that has created its first
+// message, and that message.
+func (pool *CommitmentPool) CreateSigner(key KeyBackend, info Info) (*StateSigner, Message1, error) {
+	signer, err := newStateSigner(key, info)
+	if err != nil {
+		return nil, Message1{}, err
+	}
+
+	entry := pool.take(signer.Curve, info)
+	if entry == nil {
+		// nothing precomputed, commit inline
+		if signer.U, err = pool.randomScalar(signer.Curve); err == nil {
+			if signer.S, err = pool.randomScalar(signer.Curve); err == nil {
+				signer.D, err = pool.randomScalar(signer.Curve)
+			}
+		}
+		if err != nil {
+			signer.Destroy()
+			return nil, Message1{}, err
+		}
+
+		msg, err := signer.CreateMessage1()
+		return signer, msg, err
+	}
+	defer entry.wipe()
+
+	if entry.D == nil {
+		if entry.D, err = pool.randomScalar(pool.curve); err != nil {
+			entry.destroy()
+			return nil, Message1{}, err
+		}
+
+		t1x, t1y := scalarMult(pool.curve, info.X, info.Y, entry.D)
+		entry.msg.Bx, entry.msg.By = pool.curve.Add(t1x, t1y, entry.sx, entry.sy)
+	}
+
+	signer.U, signer.S, signer.D = entry.U, entry.S, entry.D
+	signer.State = stateSignerMsg1Created
+
+	return signer, entry.msg, nil
+}
+
+// Close stops the precomputation and wipes the entries left in the pool.
+// Signers can still be created, inline.
+func (pool *CommitmentPool) Close() {
+	pool.mutex.Lock()
+	if pool.closed {
+		pool.mutex.Unlock()
+		return
+	}
+	pool.closed = true
+	close(pool.done)
+	pool.mutex.Unlock()
+
+	pool.workers.Wait()
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	drain(pool.generic)
+	for _, entries := range pool.infos {
+		drain(entries.entries)
+	}
+}
+
+// take removes an entry for info from the pool, or returns nil if there is
+// none.
+func (pool *CommitmentPool) take(curve elliptic.Curve, info Info) *commitment {
+	if curve.Params().Name != pool.curve.Params().Name {
+		return nil
+	}
+
+	var entries chan *commitment
+	if info.X != nil && info.Y != nil && pool.curve.IsOnCurve(info.X, info.Y) {
+		pool.mutex.Lock()
+		if infoEntries := pool.infos[string(marshalCompressed(pool.curve, info.X, info.Y))]; infoEntries != nil {
+			entries = infoEntries.entries
+		}
+		pool.mutex.Unlock()
+	}
+
+	select {
+	case entry := <-entries:
+		return entry
+	default:
+	}
+
+	select {
+	case entry := <-pool.generic:
+		return entry
+	default:
+		return nil
+	}
+}
+
+// fill keeps entries full until the pool is closed, or until removed is
+// closed, which wipes the entries. removed is nil for generic entries.
+func (pool *CommitmentPool) fill(entries chan *commitment, removed chan struct{}, create func() (*commitment, error)) {
+	defer pool.workers.Done()
+
+	for {
+		select {
+		case <-removed:
+			drain(entries)
+			return
+		default:
+		}
+
+		entry, err := create()
+		if err != nil {
+			// the pool runs dry and signers commit inline
+			return
+		}
+
+		select {
+		case entries <- entry:
+		case <-pool.done:
+			entry.destroy()
+			return
+		case <-removed:
+			entry.destroy()
+			drain(entries)
+			return
+		}
+	}
+}
+
+func (pool *CommitmentPool) createGeneric() (*commitment, error) {
+	entry := &commitment{msg: Message1{Curve: pool.curve}}
+
+	var err error
+	if entry.U, err = pool.randomScalar(pool.curve); err != nil {
+		return nil, err
+	}
+	if entry.S, err = pool.randomScalar(pool.curve); err != nil {
+		wipeInt(entry.U)
+		return nil, err
+	}
+
+	entry.msg.Ax, entry.msg.Ay = scalarBaseMult(pool.curve, entry.U)
+	entry.sx, entry.sy = scalarBaseMult(pool.curve, entry.S)
+
+	return entry, nil
+}
+
+func (pool *CommitmentPool) createForInfo(info Info) (*commitment, error) {
+	entry, err := pool.createGeneric()
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.D, err = pool.randomScalar(pool.curve); err != nil {
+		entry.destroy()
+		return nil, err
+	}
+
+	t1x, t1y := scalarMult(pool.curve, info.X, info.Y, entry.D)
+	entry.msg.Bx, entry.msg.By = pool.curve.Add(t1x, t1y, entry.sx, entry.sy)
+
+	return entry, nil
+}
+
+func (pool *CommitmentPool) randomScalar(curve elliptic.Curve) (*big.Int, error) {
+	pool.randomMutex.Lock()
+	defer pool.randomMutex.Unlock()
+
+	return randomScalar(pool.random, curve)
+}
+
+// wipe clears an entry whose nonces have been handed to a signer. S*g is
+// wiped because with b it would give D*z.
+func (entry *commitment) wipe() {
+	wipeInt(entry.sx)
+	wipeInt(entry.sy)
+	*entry = commitment{}
+}
+
+// destroy clears an entry that was never used.
+func (entry *commitment) destroy() {
+	for _, secret := range []*big.Int{entry.U, entry.S, entry.D} {
+		wipeInt(secret)
+	}
+	entry.wipe()
+}
+
+func drain(entries chan *commitment) {
+	for {
+		select {
+		case entry := <-entries:
+			entry.destroy()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package signing
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFull waits until entries holds size commitments.
+func waitFull(t *testing.T, entries chan *commitment, size int) {
+	deadline := time.Now().Add(10 * time.Second)
+	for len(entries) < size {
+		if time.Now().After(deadline) {
+			t.Fatal("pool not filled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCommitmentPool(t *testing.T) {
+	curve := elliptic.P256()
+	sk, _ := NewSecretKey(curve)
+	pk := sk.GetPublicKey()
+	info, _ := CompressInfo(curve, []byte("info"))
+	other, _ := CompressInfo(curve, []byte("other info"))
+
+	pool := NewCommitmentPool(curve, 4)
+	defer pool.Close()
+
+	if err := pool.Add(info); err != nil {
+		t.Fatal("failed to add info:", err)
+	}
+
+	p384, _ := CompressInfo(elliptic.P384(), []byte("info"))
+	if err := pool.Add(p384); err != ErrorPointNotOnCurve {
+		t.Error("added info on another curve:", err)
+	}
+
+	entries := pool.infos[string(marshalCompressed(curve, info.X, info.Y))].entries
+	waitFull(t, entries, 4)
+	waitFull(t, pool.generic, 4)
+
+	// complete entries for the info, generic ones for any other
+
+	backend := NewLocalSigner(func() (KeyBackend, error) { return sk, nil })
+	backend.Pool = pool
+
+	for _, sessionInfo := range []Info{info, other} {
+		if err := signWithBackend(t, backend, pk, sessionInfo, []byte("message")); err != nil {
+			t.Fatal("pooled signer failed:", err)
+		}
+	}
+
+	// entries are single use, also when taken concurrently
+
+	var mutex sync.Mutex
+	seen := make(map[string]bool)
+
+	var group sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+
+			signer, msg1, err := pool.CreateSigner(sk, info)
+			if err != nil {
+				t.Error("failed to create signer:", err)
+				return
+			}
+			defer signer.Destroy()
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			a := string(marshalCompressed(curve, msg1.Ax, msg1.Ay))
+			if seen[a] {
+				t.Error("commitment served twice")
+			}
+			seen[a] = true
+		}()
+	}
+	group.Wait()
+
+	// closing wipes the remaining entries, and signers are created inline
+
+	pool.Close()
+
+	if len(pool.generic) != 0 || len(entries) != 0 {
+		t.Error("entries left after close")
+	}
+
+	if err := signWithBackend(t, backend, pk, info, []byte("message")); err != nil {
+		t.Fatal("closed pool failed:", err)
+	}
+
+	// sessions for the info take its entries: a closed pool does not refill,
+	// so queue them by hand
+
+	stopped := NewCommitmentPool(curve, 4)
+	stopped.Close()
+
+	ready := make(chan *commitment, 4)
+	for i := 0; i < 4; i++ {
+		entry, err := stopped.createForInfo(info)
+		if err != nil {
+			t.Fatal("failed to create entry:", err)
+		}
+		ready <- entry
+	}
+	stopped.infos[string(marshalCompressed(curve, info.X, info.Y))] = &poolInfo{entries: ready}
+
+	backend.Pool = stopped
+	if err := signWithBackend(t, backend, pk, info, []byte("message")); err != nil {
+		t.Fatal("pooled signer failed:", err)
+	}
+	if len(ready) != 3 {
+		t.Error("info entry not taken:", len(ready))
+	}
+	if err := signWithBackend(t, backend, pk, other, []byte("message")); err != nil {
+		t.Fatal("inline signer failed:", err)
+	}
+	if len(ready) != 3 {
+		t.Error("info entry taken for another info:", len(ready))
+	}
+
+	// removing an info stops its goroutine and wipes its entries
+
+	removable := NewCommitmentPool(curve, 4)
+	defer removable.Close()
+
+	removable.Add(info)
+	removed := removable.infos[string(marshalCompressed(curve, info.X, info.Y))].entries
+	waitFull(t, removed, 4)
+
+	removable.Remove(info)
+	if len(removable.infos) != 0 {
+		t.Error("info not removed")
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for len(removed) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("entries of removed info not wiped")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a pool whose randomness runs out keeps the entries it has
+
+	dry := NewCommitmentPool(curve, 4, WithRandom(io.LimitReader(rand.Reader, 4*32)))
+	waitFull(t, dry.generic, 2)
+
+	queued := <-dry.generic
+	u := queued.U
+	dry.generic <- queued
+
+	dry.Close()
+
+	if len(dry.generic) != 0 || u.Sign() != 0 {
+		t.Error("queued nonce not wiped")
+	}
+}
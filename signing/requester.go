@@ -1,10 +1,7 @@
 package signing
 
 import (
-	"bytes"
 	"crypto/elliptic"
-	"crypto/rand"
-	"encoding/gob"
 	"io/ioutil"
 	"math/big"
 )
@@ -28,9 +25,12 @@ type StateRequester struct {
 	T4      *big.Int  // Scalar
 	E       *big.Int  // Scalar
 	Sig     Signature // final signature
+
+	seed []byte // session seed of T1..T4, if seeded
 }
 
-func CreateRequester(pk *PublicKey, info Info, message []byte) (*StateRequester, error) {
+func CreateRequester(pk *PublicKey, info Info, message []byte, opts ...Option) (*StateRequester, error) {
+	random := applyOptions(opts).random
 
 	st := StateRequester{
 		State:   stateRequesterFresh,
@@ -40,40 +40,61 @@ func CreateRequester(pk *PublicKey, info Info, message []byte) (*StateRequester,
 		Message: message,
 	}
 
-	order := st.Curve.Params().N
-
 	var err error
 
-	if st.T1, err = rand.Int(rand.Reader, order); err != nil {
+	if st.T1, err = randomScalar(random, st.Curve); err != nil {
 		return nil, err
 	}
 
-	if st.T2, err = rand.Int(rand.Reader, order); err != nil {
+	if st.T2, err = randomScalar(random, st.Curve); err != nil {
 		return nil, err
 	}
 
-	if st.T3, err = rand.Int(rand.Reader, order); err != nil {
+	if st.T3, err = randomScalar(random, st.Curve); err != nil {
 		return nil, err
 	}
 
-	if st.T4, err = rand.Int(rand.Reader, order); err != nil {
+	if st.T4, err = randomScalar(random, st.Curve); err != nil {
 		return nil, err
 	}
 
 	return &st, nil
 }
 
-func LoadRequester(filename string) (*StateRequester, error) {
+// LoadRequester reads a requester state sealed by Save, asking passphrase
+// for its passphrase. Unsealed states written by earlier versions are still
+// accepted.
+func LoadRequester(filename string, passphrase PassphraseFunc) (*StateRequester, error) {
 	data, readError := ioutil.ReadFile(filename)
 	if readError != nil {
 		return nil, readError
 	}
 
+	if isPEM(data) {
+		opened, openError := openPEM(pemRequesterState, data, passphrase)
+		if openError != nil {
+			return nil, openError
+		}
+		defer wipeBytes(opened)
+
+		var requester StateRequester
+		if decodeError := gobDecode(opened, &requester); decodeError != nil {
+			return nil, decodeError
+		}
+
+		return &requester, nil
+	}
+
 	var requester StateRequester
-	var buffer bytes.Buffer
-	buffer.Write(data)
-	decoder := gob.NewDecoder(&buffer)
-	decoder.Decode(&requester)
+	decodeError := gobDecode(data, &requester)
+	if decodeError != nil {
+		legacy, legacyError := loadLegacyRequester(data)
+		if legacyError != nil {
+			return nil, decodeError
+		}
+
+		return legacy, nil
+	}
 
 	return &requester, nil
 }
@@ -92,45 +113,52 @@ func (st *StateRequester) ProcessMessage1(msg Message1) error {
 		return ErrorPointNotOnCurve
 	}
 
-	st.E = func() *big.Int {
-
-		// alpha = a + T1 * g + T2 * Y
+	st.E = blindChallenge(st.Pk, st.Info, st.Message, msg.Ax, msg.Ay, msg.Bx, msg.By, st.T1, st.T2, st.T3, st.T4)
 
-		alphax, alphay := func() (*big.Int, *big.Int) {
-			t1x, t1y := st.Curve.ScalarBaseMult(st.T1.Bytes())
-			t2x, t2y := st.Curve.ScalarMult(st.Pk.X, st.Pk.Y, st.T2.Bytes())
-			alx, aly := st.Curve.Add(msg.Ax, msg.Ay, t1x, t1y)
-			return st.Curve.Add(alx, aly, t2x, t2y)
-		}()
+	st.State = stateRequesterMsg1Processed
 
-		// beta = b + T3 * g + T4 * z
+	return nil
+}
 
-		betax, betay := func() (*big.Int, *big.Int) {
-			t3x, t3y := st.Curve.ScalarBaseMult(st.T3.Bytes())
-			t4x, t4y := st.Curve.ScalarMult(st.Info.X, st.Info.Y, st.T4.Bytes())
-			bex, bey := st.Curve.Add(msg.Bx, msg.By, t3x, t3y)
-			return st.Curve.Add(bex, bey, t4x, t4y)
-		}()
+// blindChallenge derives the challenge e sent in Message2 from the signer's
+// commitments (a, b) and the requester's blinding factors. It is shared by
+// the requester and by a signer auditing opened cut-and-choose candidates.
+func blindChallenge(pk *PublicKey, info Info, message []byte, ax, ay, bx, by, t1, t2, t3, t4 *big.Int) *big.Int {
+	curve := pk.Curve
 
-		// hash to Scalar
+	// alpha = a + T1 * g + T2 * Y
 
-		var buff []byte
+	alphax, alphay := func() (*big.Int, *big.Int) {
+		t1x, t1y := scalarBaseMult(curve, t1)
+		t2x, t2y := scalarMult(curve, pk.X, pk.Y, t2)
+		alx, aly := curve.Add(ax, ay, t1x, t1y)
+		return curve.Add(alx, aly, t2x, t2y)
+	}()
 
-		buff = elliptic.Marshal(st.Curve, alphax, alphay)
-		buff = append(buff, elliptic.Marshal(st.Curve, betax, betay)...)
-		buff = append(buff, elliptic.Marshal(st.Curve, st.Info.X, st.Info.Y)...)
-		buff = append(buff, st.Message...)
+	// beta = b + T3 * g + T4 * z
 
-		return hashToScalar(st.Curve, buff)
+	betax, betay := func() (*big.Int, *big.Int) {
+		t3x, t3y := scalarBaseMult(curve, t3)
+		t4x, t4y := scalarMult(curve, info.X, info.Y, t4)
+		bex, bey := curve.Add(bx, by, t3x, t3y)
+		return curve.Add(bex, bey, t4x, t4y)
 	}()
 
-	st.E.Sub(st.E, st.T2)
-	st.E.Sub(st.E, st.T4)
-	st.E.Mod(st.E, st.Curve.Params().N)
+	// hash to Scalar
 
-	st.State = stateRequesterMsg1Processed
+	var buff []byte
 
-	return nil
+	buff = elliptic.Marshal(curve, alphax, alphay)
+	buff = append(buff, elliptic.Marshal(curve, betax, betay)...)
+	buff = append(buff, elliptic.Marshal(curve, info.X, info.Y)...)
+	buff = append(buff, message...)
+
+	field := scalarFieldOf(curve)
+
+	e := field.subInt(hashToScalar(curve, buff), t2)
+	defer wipeInt(e)
+
+	return field.subInt(e, t4)
 }
 
 func (st *StateRequester) CreateMessage2() (Message2, error) {
@@ -138,7 +166,7 @@ func (st *StateRequester) CreateMessage2() (Message2, error) {
 		return Message2{}, ErrorInvalidRequesterState
 	}
 	st.State = stateRequesterMsg2Created
-	return Message2{E: st.E}, nil
+	return Message2{Curve: st.Curve, E: st.E}, nil
 }
 
 func (st *StateRequester) ProcessMessage3(msg Message3) error {
@@ -147,35 +175,23 @@ func (st *StateRequester) ProcessMessage3(msg Message3) error {
 		return ErrorInvalidRequesterState
 	}
 
-	params := st.Curve.Params()
+	field := scalarFieldOf(st.Curve)
 
 	// infer D
 
-	d := big.NewInt(0)
-	d.Sub(st.E, msg.C)
-	d.Mod(d, params.N)
+	d := field.subInt(st.E, msg.C)
 
 	// calculate signature
 
-	p := big.NewInt(0)
-	p.Add(msg.R, st.T1)
-	p.Mod(p, params.N)
-
-	w := big.NewInt(0)
-	w.Add(msg.C, st.T2)
-	w.Mod(w, params.N)
-
-	o := big.NewInt(0)
-	o.Add(msg.S, st.T3)
-	o.Mod(o, params.N)
-
-	g := big.NewInt(0)
-	g.Add(d, st.T4)
-	g.Mod(g, params.N)
+	p := field.addInt(msg.R, st.T1)
+	w := field.addInt(msg.C, st.T2)
+	o := field.addInt(msg.S, st.T3)
+	g := field.addInt(d, st.T4)
 
 	st.Sig = Signature{
 		P: p, W: w,
 		O: o, G: g,
+		Curve: st.Curve,
 	}
 
 	// validate signature
@@ -198,18 +214,23 @@ func (st *StateRequester) Signature() (Signature, error) {
 	return st.Sig, nil
 }
 
-func (st *StateRequester) Save(filename string) error {
-	var buffer bytes.Buffer        // Stand-in for a network connection
-	encoder := gob.NewEncoder(&buffer)
-	encodingError := encoder.Encode(st)
+// Save writes the state sealed under passphrase, readable only by the
+// owner. The passphrase must not be empty.
+func (st *StateRequester) Save(filename string, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return ErrorPassphraseRequired
+	}
+
+	data, encodingError := gobEncode(st)
 	if encodingError != nil {
 		return encodingError
 	}
+	defer wipeBytes(data)
 
-	requestWriteError := ioutil.WriteFile(filename, buffer.Bytes(), 0644)
-	if requestWriteError != nil {
-		return requestWriteError
+	sealed, sealError := sealPEM(pemRequesterState, data, passphrase)
+	if sealError != nil {
+		return sealError
 	}
 
-	return nil
+	return writeFileAtomic(filename, sealed, 0600)
 }
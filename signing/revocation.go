@@ -0,0 +1,161 @@
+package signing
+
+// Revocation lists.
+//
+// A revocation list names signer keys that must no longer be trusted, each
+// with the time it was revoked, and is signed with the root key. Lists carry
+// a sequence number so a verifier never goes back to an older list, and
+// never swaps a list for a different one with the same number.
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+const documentRevocationList = "pblind-revocation-list"
+
+type Revocation struct {
+	KeyID     []byte
+	RevokedAt time.Time
+	Reason    string
+}
+
+type RevocationList struct {
+	Sequence    uint64
+	Issued      time.Time
+	Revocations []Revocation
+}
+
+type jsonRevocation struct {
+	KeyID     string    `json:"kid"`
+	RevokedAt time.Time `json:"revoked_at"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+type jsonRevocationList struct {
+	Sequence    uint64           `json:"sequence"`
+	Issued      time.Time        `json:"issued"`
+	Revocations []jsonRevocation `json:"revocations"`
+}
+
+// Revoke adds keyID to the list, or changes the time and reason of an
+// earlier revocation of it.
+func (list *RevocationList) Revoke(keyID []byte, at time.Time, reason string) {
+	for index := range list.Revocations {
+		if string(list.Revocations[index].KeyID) == string(keyID) {
+			list.Revocations[index].RevokedAt = at
+			list.Revocations[index].Reason = reason
+			return
+		}
+	}
+
+	list.Revocations = append(list.Revocations, Revocation{KeyID: keyID, RevokedAt: at, Reason: reason})
+}
+
+func (list *RevocationList) Lookup(keyID []byte) *Revocation {
+	for index := range list.Revocations {
+		if string(list.Revocations[index].KeyID) == string(keyID) {
+			return &list.Revocations[index]
+		}
+	}
+
+	return nil
+}
+
+// Sign encodes the list as a document signed by root.
+func (list *RevocationList) Sign(root ed25519.PrivateKey) ([]byte, error) {
+	encoded, err := list.encode()
+	if err != nil {
+		return nil, err
+	}
+
+	return signDocument(documentRevocationList, encoded, root)
+}
+
+func (list *RevocationList) encode() (*jsonRevocationList, error) {
+	encoded := &jsonRevocationList{
+		Sequence:    list.Sequence,
+		Issued:      list.Issued,
+		Revocations: []jsonRevocation{},
+	}
+
+	for _, revocation := range list.Revocations {
+		if len(revocation.KeyID) != keyIDSize {
+			return nil, ErrorInvalidKeyID
+		}
+
+		encoded.Revocations = append(encoded.Revocations, jsonRevocation{
+			KeyID:     jsonEncoding.EncodeToString(revocation.KeyID),
+			RevokedAt: revocation.RevokedAt,
+			Reason:    revocation.Reason,
+		})
+	}
+
+	return encoded, nil
+}
+
+// equal tells if list and other encode to the same bytes.
+func (list *RevocationList) equal(other *RevocationList) bool {
+	encoded, err := list.encode()
+	if err != nil {
+		return false
+	}
+	otherEncoded, err := other.encode()
+	if err != nil {
+		return false
+	}
+
+	data, err := json.Marshal(encoded)
+	if err != nil {
+		return false
+	}
+	otherData, err := json.Marshal(otherEncoded)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(data, otherData)
+}
+
+// ParseRevocationList decodes a list after checking its root signature.
+func ParseRevocationList(data []byte, root ed25519.PublicKey) (*RevocationList, error) {
+	var encoded jsonRevocationList
+	if err := openDocument(documentRevocationList, data, root, &encoded); err != nil {
+		return nil, err
+	}
+
+	list := &RevocationList{Sequence: encoded.Sequence, Issued: encoded.Issued}
+
+	for _, revocation := range encoded.Revocations {
+		keyID, err := jsonEncoding.DecodeString(revocation.KeyID)
+		if err != nil || len(keyID) != keyIDSize {
+			return nil, ErrorInvalidKeyID
+		}
+
+		list.Revocations = append(list.Revocations, Revocation{
+			KeyID:     keyID,
+			RevokedAt: revocation.RevokedAt,
+			Reason:    revocation.Reason,
+		})
+	}
+
+	return list, nil
+}
+
+// SaveRevocationList writes a list signed with Sign atomically, so a crash
+// never leaves a torn list that would lose revocations.
+func SaveRevocationList(filename string, data []byte) error {
+	return writeFileAtomic(filename, data, 0644)
+}
+
+func LoadRevocationList(filename string, root ed25519.PublicKey) (*RevocationList, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseRevocationList(data, root)
+}
@@ -0,0 +1,110 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRevocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root, _ := NewRootKey()
+	rootFile := filepath.Join(dir, "root.secret")
+	if err = SaveRootKey(rootFile, root, []byte("passphrase")); err != nil {
+		t.Fatal("failed to save root key:", err)
+	}
+	if err = SaveRootPublicKey(filepath.Join(dir, "root.public"), root.Public().(ed25519.PublicKey)); err != nil {
+		t.Fatal("failed to save root public key:", err)
+	}
+
+	root, err = LoadRootKey(rootFile, func() ([]byte, error) { return []byte("passphrase"), nil })
+	if err != nil {
+		t.Fatal("failed to load root key:", err)
+	}
+	rootPublic, err := LoadRootPublicKey(filepath.Join(dir, "root.public"))
+	if err != nil {
+		t.Fatal("failed to load root public key:", err)
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	sk, _ := NewSecretKey(elliptic.P256())
+	pk := sk.GetPublicKey()
+	keyID, _ := pk.Fingerprint()
+
+	ring := NewKeyring()
+	ring.Now = func() time.Time { return now }
+	ring.Add(pk, time.Time{}, time.Time{})
+
+	message := []byte("message")
+	info, _ := CompressInfo(elliptic.P256(), []byte("info"))
+	token := issueToken(t, sk, info, message)
+
+	list := RevocationList{Sequence: 2, Issued: now}
+	list.Revoke(keyID, now.Add(-time.Hour), "key compromise")
+
+	data, err := list.Sign(root)
+	if err != nil {
+		t.Fatal("failed to sign revocation list:", err)
+	}
+
+	if _, err = ParseRevocationList(bytes.Replace(data, []byte(`"pblind-revocation-list"`), []byte(`"pblind-key-manifest"`), 1), rootPublic); err == nil {
+		t.Error("accepted revocation list as another document type")
+	}
+
+	otherRoot, _ := NewRootKey()
+	if _, err = ParseRevocationList(data, otherRoot.Public().(ed25519.PublicKey)); err != ErrorInvalidSignature {
+		t.Error("accepted revocation list signed by another root:", err)
+	}
+
+	parsed, err := ParseRevocationList(data, rootPublic)
+	if err != nil {
+		t.Fatal("failed to parse revocation list:", err)
+	}
+
+	if err = ring.SetRevocations(parsed); err != nil {
+		t.Fatal("failed to set revocations:", err)
+	}
+
+	if err = ring.Check(token, info, message); err != ErrorKeyRevoked {
+		t.Error("accepted token of revoked key:", err)
+	}
+
+	// tokens known to predate the revocation stay valid on request
+
+	if err = ring.CheckIssued(token, info, message, now.Add(-2*time.Hour)); err != ErrorKeyRevoked {
+		t.Error("accepted early token without AcceptBeforeRevocation:", err)
+	}
+
+	ring.AcceptBeforeRevocation = true
+
+	if err = ring.CheckIssued(token, info, message, now.Add(-2*time.Hour)); err != nil {
+		t.Error("rejected token issued before revocation:", err)
+	}
+	if err = ring.CheckIssued(token, info, message, now); err != ErrorKeyRevoked {
+		t.Error("accepted token issued after revocation:", err)
+	}
+
+	if err = ring.SetRevocations(&RevocationList{Sequence: 1}); err != ErrorStaleRevocationList {
+		t.Error("rolled back to older revocation list:", err)
+	}
+
+	// a list with the same sequence number must be the same list
+
+	if err = ring.SetRevocations(&list); err != nil {
+		t.Error("refused the revocation list in use:", err)
+	}
+	if err = ring.SetRevocations(&RevocationList{Sequence: 2, Issued: now}); err != ErrorConflictingRevocationList {
+		t.Error("replaced revocation list with a different one of the same sequence:", err)
+	}
+}
@@ -0,0 +1,160 @@
+package signing
+
+// Root keys and signed documents.
+//
+// Documents that tell verifiers and requesters which signer keys to trust,
+// such as revocation lists, are signed with a long-term Ed25519 root key
+// that the signer operator keeps offline. Root keys are stored as standard
+// PKIX "PUBLIC KEY" and PKCS #8 "PRIVATE KEY" PEM blocks, the private key
+// encrypted like pblind secret keys.
+//
+// A signed document is the JSON object
+//
+//	{"type": ..., "payload": ..., "signature": ...}
+//
+// with the payload JSON in base64url, signed together with its type so a
+// signature for one kind of document cannot be passed off as another.
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	pemRootPublicKey  = "PUBLIC KEY"
+	pemRootPrivateKey = "PRIVATE KEY"
+)
+
+type jsonSignedDocument struct {
+	Type      string `json:"type"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+func NewRootKey() (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	return key, err
+}
+
+// SaveRootKey writes the root key encrypted under passphrase, which must
+// not be empty.
+func SaveRootKey(filename string, key ed25519.PrivateKey, passphrase []byte) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	data, err := sealPEM(pemRootPrivateKey, der, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, data, 0600)
+}
+
+func LoadRootKey(filename string, passphrase PassphraseFunc) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := openPEM(pemRootPrivateKey, data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, ErrorInvalidEncoding
+	}
+
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrorInvalidEncoding
+	}
+
+	return key, nil
+}
+
+func SaveRootPublicKey(filename string, key ed25519.PublicKey) error {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, pem.EncodeToMemory(&pem.Block{Type: pemRootPublicKey, Bytes: der}), 0644)
+}
+
+func LoadRootPublicKey(filename string) (ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	block, rest := pem.Decode(data)
+	if block == nil || block.Type != pemRootPublicKey || len(block.Headers) != 0 || len(strings.TrimSpace(string(rest))) != 0 {
+		return nil, ErrorInvalidEncoding
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, ErrorInvalidEncoding
+	}
+
+	key, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, ErrorInvalidEncoding
+	}
+
+	return key, nil
+}
+
+func signDocument(kind string, payload interface{}, root ed25519.PrivateKey) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	signature := ed25519.Sign(root, documentSigningInput(kind, data))
+
+	return json.MarshalIndent(jsonSignedDocument{
+		Type:      kind,
+		Payload:   jsonEncoding.EncodeToString(data),
+		Signature: jsonEncoding.EncodeToString(signature),
+	}, "", "  ")
+}
+
+// openDocument verifies a signed document of the given kind and decodes its
+// payload.
+func openDocument(kind string, data []byte, root ed25519.PublicKey, payload interface{}) error {
+	var document jsonSignedDocument
+	if err := decodeJSONStrict(data, &document); err != nil || document.Type != kind {
+		return ErrorInvalidEncoding
+	}
+
+	content, contentError := jsonEncoding.DecodeString(document.Payload)
+	signature, signatureError := jsonEncoding.DecodeString(document.Signature)
+	if contentError != nil || signatureError != nil {
+		return ErrorInvalidEncoding
+	}
+
+	if len(root) != ed25519.PublicKeySize || !ed25519.Verify(root, documentSigningInput(kind, content), signature) {
+		return ErrorInvalidSignature
+	}
+
+	if err := decodeJSONStrict(content, payload); err != nil {
+		return ErrorInvalidEncoding
+	}
+
+	return nil
+}
+
+func documentSigningInput(kind string, payload []byte) []byte {
+	input := appendLengthPrefixed([]byte("pblind signed document"), []byte(kind))
+	return appendLengthPrefixed(input, payload)
+}
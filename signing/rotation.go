@@ -0,0 +1,612 @@
+package signing
+
+// Key rotation.
+//
+// A KeyRotator takes signer keys through their life cycle. The successor of
+// the active key is generated and published as pending as soon as its
+// predecessor becomes active, so clients learn it well ahead of activation.
+// It becomes active once the active key has been in use for the policy
+// lifetime or has issued the policy number of signatures. The replaced key
+// then only verifies for the overlap window, which should outlast tokens in
+// flight, and is retired after that.
+
+import (
+	"crypto/elliptic"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type KeyState int
+
+const (
+	KeyPending KeyState = iota
+	KeyActive
+	KeyVerifyOnly
+	KeyRetired
+)
+
+var keyStateNames = []string{"pending", "active", "verify-only", "retired"}
+
+const rotationFile = "rotation.json"
+
+type RotationPolicy struct {
+	Curve       elliptic.Curve // curve of new keys, that of the active key if nil
+	Lifetime    time.Duration  // activate the successor after this long, 0 for no schedule
+	MaxIssuance uint64         // or after this many signatures, 0 for no limit
+	Overlap     time.Duration  // how long a replaced key still verifies
+}
+
+type RotatingKey struct {
+	Sk          *SecretKey // nil once retired
+	Pk          *PublicKey
+	KeyID       []byte
+	State       KeyState
+	Created     time.Time
+	Activated   time.Time
+	Deactivated time.Time
+	Issued      uint64
+}
+
+type KeyRotator struct {
+	Policy RotationPolicy
+	Now    func() time.Time // time.Now if nil
+
+	mutex  sync.Mutex
+	keys   []*RotatingKey // oldest first
+	locked bool           // lock new keys in memory
+}
+
+type jsonRotatingKey struct {
+	KeyID       string    `json:"kid"`
+	Key         string    `json:"key"`
+	State       string    `json:"state"`
+	Created     time.Time `json:"created"`
+	Activated   time.Time `json:"activated"`
+	Deactivated time.Time `json:"deactivated"`
+	Issued      uint64    `json:"issued"`
+}
+
+type jsonRotation struct {
+	Keys []jsonRotatingKey `json:"keys"`
+}
+
+func (state KeyState) String() string {
+	if state < 0 || int(state) >= len(keyStateNames) {
+		return "unknown"
+	}
+	return keyStateNames[state]
+}
+
+func ParseKeyState(name string) (KeyState, error) {
+	for state, stateName := range keyStateNames {
+		if name == stateName {
+			return KeyState(state), nil
+		}
+	}
+	return 0, ErrorInvalidEncoding
+}
+
+// Verifies tells if signatures by a key in this state are accepted.
+func (state KeyState) Verifies() bool {
+	return state == KeyActive || state == KeyVerifyOnly
+}
+
+// NewKeyRotator starts rotation with active as the active key, or a new key
+// if active is nil, and generates its pending successor.
+func NewKeyRotator(policy RotationPolicy, active *SecretKey, now func() time.Time) (*KeyRotator, error) {
+	rotator := &KeyRotator{Policy: policy, Now: now}
+
+	if active == nil {
+		curve := policy.Curve
+		if curve == nil {
+			curve = elliptic.P256()
+		}
+
+		var err error
+		if active, err = NewSecretKey(curve); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := newRotatingKey(active, rotator.now())
+	if err != nil {
+		return nil, err
+	}
+
+	key.State = KeyActive
+	key.Activated = key.Created
+	rotator.keys = append(rotator.keys, key)
+
+	if err = rotator.addSuccessor(); err != nil {
+		return nil, err
+	}
+
+	return rotator, nil
+}
+
+func newRotatingKey(sk *SecretKey, now time.Time) (*RotatingKey, error) {
+	pk := sk.GetPublicKey()
+
+	keyID, err := pk.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+
+	return &RotatingKey{Sk: sk, Pk: pk, KeyID: keyID, State: KeyPending, Created: now}, nil
+}
+
+func (rotator *KeyRotator) now() time.Time {
+	if rotator.Now != nil {
+		return rotator.Now()
+	}
+	return time.Now()
+}
+
+func (rotator *KeyRotator) find(state KeyState) *RotatingKey {
+	for _, key := range rotator.keys {
+		if key.State == state {
+			return key
+		}
+	}
+	return nil
+}
+
+func (rotator *KeyRotator) addSuccessor() error {
+	curve := rotator.Policy.Curve
+	if curve == nil {
+		curve = rotator.find(KeyActive).Sk.Curve
+	}
+
+	sk, err := NewSecretKey(curve)
+	if err != nil {
+		return err
+	}
+
+	if rotator.locked {
+		if err = sk.Lock(); err != nil {
+			sk.Destroy()
+			return err
+		}
+	}
+
+	key, err := newRotatingKey(sk, rotator.now())
+	if err != nil {
+		return err
+	}
+
+	rotator.keys = append(rotator.keys, key)
+
+	return nil
+}
+
+func (rotator *KeyRotator) due(key *RotatingKey, now time.Time) bool {
+	if rotator.Policy.Lifetime > 0 && !now.Before(key.Activated.Add(rotator.Policy.Lifetime)) {
+		return true
+	}
+
+	return rotator.Policy.MaxIssuance > 0 && key.Issued >= rotator.Policy.MaxIssuance
+}
+
+// promote activates the pending key, makes the active key verify-only and
+// generates the next pending key.
+func (rotator *KeyRotator) promote(now time.Time) error {
+	active := rotator.find(KeyActive)
+	pending := rotator.find(KeyPending)
+
+	active.State = KeyVerifyOnly
+	active.Deactivated = now
+	pending.State = KeyActive
+	pending.Activated = now
+
+	return rotator.addSuccessor()
+}
+
+func (rotator *KeyRotator) update(now time.Time) (bool, error) {
+	changed := false
+
+	if rotator.due(rotator.find(KeyActive), now) {
+		if err := rotator.promote(now); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+
+	for _, key := range rotator.keys {
+		if key.State == KeyVerifyOnly && !now.Before(key.Deactivated.Add(rotator.Policy.Overlap)) {
+			key.State = KeyRetired
+			key.Sk.Destroy()
+			key.Sk = nil
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+// Update applies the rotation policy and reports whether any key changed
+// state.
+func (rotator *KeyRotator) Update() (bool, error) {
+	rotator.mutex.Lock()
+	defer rotator.mutex.Unlock()
+
+	return rotator.update(rotator.now())
+}
+
+// Rotate activates the pending key now, regardless of the policy.
+func (rotator *KeyRotator) Rotate() error {
+	rotator.mutex.Lock()
+	defer rotator.mutex.Unlock()
+
+	now := rotator.now()
+	if err := rotator.promote(now); err != nil {
+		return err
+	}
+
+	_, err := rotator.update(now)
+
+	return err
+}
+
+// Retire takes a key out of use at once, for example because it has been
+// revoked. An active key is first replaced by the pending key, a pending
+// key by a new one. The secret key is wiped, and sessions still using it
+// fail with ErrorKeyDestroyed.
+func (rotator *KeyRotator) Retire(keyID []byte) error {
+	rotator.mutex.Lock()
+	defer rotator.mutex.Unlock()
+
+	var key *RotatingKey
+	for _, candidate := range rotator.keys {
+		if string(candidate.KeyID) == string(keyID) {
+			key = candidate
+		}
+	}
+
+	if key == nil {
+		return ErrorUnknownKey
+	}
+
+	now := rotator.now()
+	state := key.State
+
+	if state == KeyActive {
+		if err := rotator.promote(now); err != nil {
+			return err
+		}
+	}
+
+	if key.Deactivated.IsZero() {
+		key.Deactivated = now
+	}
+	key.State = KeyRetired
+	if key.Sk != nil {
+		key.Sk.Destroy()
+		key.Sk = nil
+	}
+
+	if state == KeyPending {
+		return rotator.addSuccessor()
+	}
+
+	return nil
+}
+
+// Lock locks the secret keys in memory, and every key generated later.
+// See SecretKey.Lock.
+func (rotator *KeyRotator) Lock() error {
+	rotator.mutex.Lock()
+	defer rotator.mutex.Unlock()
+
+	for _, key := range rotator.keys {
+		if key.Sk != nil {
+			if err := key.Sk.Lock(); err != nil {
+				return err
+			}
+		}
+	}
+
+	rotator.locked = true
+
+	return nil
+}
+
+// Issue returns the key to sign the next request with and counts the
+// issuance against it.
+func (rotator *KeyRotator) Issue() (*SecretKey, error) {
+	rotator.mutex.Lock()
+	defer rotator.mutex.Unlock()
+
+	if _, err := rotator.update(rotator.now()); err != nil {
+		return nil, err
+	}
+
+	active := rotator.find(KeyActive)
+	active.Issued++
+
+	return active.Sk, nil
+}
+
+// Key returns the secret key with keyID unless it is retired. It can be
+// passed to LoadSigner.
+func (rotator *KeyRotator) Key(keyID []byte) (KeyBackend, error) {
+	rotator.mutex.Lock()
+	defer rotator.mutex.Unlock()
+
+	for _, key := range rotator.keys {
+		if string(key.KeyID) == string(keyID) && key.Sk != nil {
+			return key.Sk, nil
+		}
+	}
+
+	return nil, ErrorUnknownKey
+}
+
+func (rotator *KeyRotator) Active() *PublicKey {
+	rotator.mutex.Lock()
+	defer rotator.mutex.Unlock()
+
+	return rotator.find(KeyActive).Pk
+}
+
+// Keys lists copies of all keys, oldest first.
+func (rotator *KeyRotator) Keys() []RotatingKey {
+	rotator.mutex.Lock()
+	defer rotator.mutex.Unlock()
+
+	keys := make([]RotatingKey, len(rotator.keys))
+	for index, key := range rotator.keys {
+		keys[index] = *key
+	}
+
+	return keys
+}
+
+// Keyring returns a keyring with every key that is not retired in its
+// current state.
+func (rotator *KeyRotator) Keyring() (*Keyring, error) {
+	ring, err := keyringOf(rotator.Keys())
+	if err != nil {
+		return nil, err
+	}
+
+	ring.Now = rotator.Now
+
+	return ring, nil
+}
+
+func keyringOf(keys []RotatingKey) (*Keyring, error) {
+	ring := NewKeyring()
+
+	for _, key := range keys {
+		if key.State == KeyRetired {
+			continue
+		}
+
+		if err := ring.Add(key.Pk, time.Time{}, time.Time{}); err != nil {
+			return nil, err
+		}
+
+		if err := ring.SetState(key.KeyID, key.State); err != nil {
+			return nil, err
+		}
+	}
+
+	return ring, nil
+}
+
+// KeySet returns the keys to publish: the active key first, then the
+// pending and the verify-only keys.
+func (rotator *KeyRotator) KeySet() (*KeySet, error) {
+	return publishKeys(rotator.Keys())
+}
+
+func publishKeys(keys []RotatingKey) (*KeySet, error) {
+	var published []*PublicKey
+	for _, state := range []KeyState{KeyActive, KeyPending, KeyVerifyOnly} {
+		for _, key := range keys {
+			if key.State == state {
+				published = append(published, key.Pk)
+			}
+		}
+	}
+
+	return NewKeySet(published...)
+}
+
+// ServeHTTP serves the current key set.
+func (rotator *KeyRotator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	set, err := rotator.KeySet()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	set.ServeHTTP(w, r)
+}
+
+// Save writes the rotation state to dir, together with a secret key file
+// for every key that is not retired. Secret keys of retired keys are
+// deleted.
+func (rotator *KeyRotator) Save(dir string, passphrase []byte) error {
+	rotator.mutex.Lock()
+	defer rotator.mutex.Unlock()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	var encoded jsonRotation
+
+	for _, key := range rotator.keys {
+		kid := jsonEncoding.EncodeToString(key.KeyID)
+		secretFile := filepath.Join(dir, kid+".secret")
+
+		if key.Sk == nil {
+			if err := os.Remove(secretFile); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		} else if _, err := os.Stat(secretFile); os.IsNotExist(err) {
+			if err = key.Sk.Save(secretFile, passphrase); err != nil {
+				return err
+			}
+		}
+
+		pk, err := key.Pk.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		encoded.Keys = append(encoded.Keys, jsonRotatingKey{
+			KeyID:       kid,
+			Key:         jsonEncoding.EncodeToString(pk),
+			State:       key.State.String(),
+			Created:     key.Created,
+			Activated:   key.Activated,
+			Deactivated: key.Deactivated,
+			Issued:      key.Issued,
+		})
+	}
+
+	data, err := json.MarshalIndent(encoded, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(dir, rotationFile), data, 0644)
+}
+
+// LoadKeyRotator reads the rotation state saved in dir. The passphrase is
+// asked for at most once.
+func LoadKeyRotator(dir string, policy RotationPolicy, passphrase PassphraseFunc, now func() time.Time) (*KeyRotator, error) {
+	keys, err := readRotation(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase = cachePassphrase(passphrase)
+	rotator := &KeyRotator{Policy: policy, Now: now, keys: keys}
+
+	for _, key := range keys {
+		if key.State == KeyRetired {
+			continue
+		}
+
+		kid := jsonEncoding.EncodeToString(key.KeyID)
+		key.Sk, err = LoadSecretKey(filepath.Join(dir, kid+".secret"), passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		derived := key.Sk.GetPublicKey()
+		if derived.X.Cmp(key.Pk.X) != 0 || derived.Y.Cmp(key.Pk.Y) != 0 {
+			return nil, ErrorInvalidKeyID
+		}
+	}
+
+	return rotator, nil
+}
+
+// LoadKeySet returns the key set of the rotation state saved in dir without
+// reading any secret key, for servers that sign through a SignerBackend.
+func LoadKeySet(dir string) (*KeySet, error) {
+	keys, err := readRotation(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	copies := make([]RotatingKey, len(keys))
+	for index, key := range keys {
+		copies[index] = *key
+	}
+
+	return publishKeys(copies)
+}
+
+// LoadKeyring returns the keyring of the rotation state saved in dir
+// without reading any secret key, for verifiers.
+func LoadKeyring(dir string) (*Keyring, error) {
+	keys, err := readRotation(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	copies := make([]RotatingKey, len(keys))
+	for index, key := range keys {
+		copies[index] = *key
+	}
+
+	return keyringOf(copies)
+}
+
+// readRotation reads the public part of the rotation state saved in dir.
+func readRotation(dir string) ([]*RotatingKey, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, rotationFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var encoded jsonRotation
+	if err = json.Unmarshal(data, &encoded); err != nil {
+		return nil, ErrorInvalidEncoding
+	}
+
+	var keys []*RotatingKey
+	counts := make(map[KeyState]int)
+
+	for _, entry := range encoded.Keys {
+		state, stateError := ParseKeyState(entry.State)
+		keyID, kidError := jsonEncoding.DecodeString(entry.KeyID)
+		keyData, keyError := jsonEncoding.DecodeString(entry.Key)
+		if stateError != nil || kidError != nil || keyError != nil {
+			return nil, ErrorInvalidEncoding
+		}
+
+		pk := &PublicKey{}
+		if err = pk.UnmarshalBinary(keyData); err != nil {
+			return nil, err
+		}
+
+		fingerprint, fingerprintError := pk.Fingerprint()
+		if fingerprintError != nil || string(fingerprint) != string(keyID) {
+			return nil, ErrorInvalidKeyID
+		}
+
+		counts[state]++
+		keys = append(keys, &RotatingKey{
+			Pk:          pk,
+			KeyID:       keyID,
+			State:       state,
+			Created:     entry.Created,
+			Activated:   entry.Activated,
+			Deactivated: entry.Deactivated,
+			Issued:      entry.Issued,
+		})
+	}
+
+	if counts[KeyActive] != 1 || counts[KeyPending] != 1 {
+		return nil, ErrorInvalidEncoding
+	}
+
+	return keys, nil
+}
+
+// cachePassphrase asks passphrase once and then repeats the answer.
+func cachePassphrase(passphrase PassphraseFunc) PassphraseFunc {
+	if passphrase == nil {
+		return nil
+	}
+
+	var secret []byte
+	var err error
+	var once sync.Once
+
+	return func() ([]byte, error) {
+		once.Do(func() { secret, err = passphrase() })
+		return secret, err
+	}
+}
@@ -0,0 +1,121 @@
+package signing
+
+import (
+	"crypto/elliptic"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestKeyRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	policy := RotationPolicy{Lifetime: 24 * time.Hour, MaxIssuance: 3, Overlap: time.Hour}
+
+	rotator, err := NewKeyRotator(policy, nil, clock)
+	if err != nil {
+		t.Fatal("failed to create rotator:", err)
+	}
+
+	first := rotator.Active()
+	set, _ := rotator.KeySet()
+	if len(set.Keys) != 2 || set.Keys[0].Key != first {
+		t.Fatal("successor not published ahead of activation")
+	}
+	successor := set.Keys[1].Key
+
+	message := []byte("message")
+	info, _ := CompressInfo(elliptic.P256(), []byte("info"))
+
+	// the fourth issuance rotates
+
+	var tokens []Token
+	for i := 0; i < 4; i++ {
+		sk, err := rotator.Issue()
+		if err != nil {
+			t.Fatal("failed to issue:", err)
+		}
+		tokens = append(tokens, issueToken(t, sk, info, message))
+	}
+
+	if rotator.Active() != successor {
+		t.Fatal("pending key not activated after issuance limit")
+	}
+
+	if err = rotator.Save(dir, []byte("passphrase")); err != nil {
+		t.Fatal("failed to save rotator:", err)
+	}
+
+	passphrase := func() ([]byte, error) { return []byte("passphrase"), nil }
+	rotator, err = LoadKeyRotator(dir, policy, passphrase, clock)
+	if err != nil {
+		t.Fatal("failed to load rotator:", err)
+	}
+
+	states := map[KeyState]int{}
+	for _, key := range rotator.Keys() {
+		states[key.State]++
+	}
+	if states[KeyActive] != 1 || states[KeyPending] != 1 || states[KeyVerifyOnly] != 1 {
+		t.Error("key states not restored:", states)
+	}
+
+	// verifiers load the same keyring from the public state alone
+
+	ring, err := LoadKeyring(dir)
+	if err != nil {
+		t.Fatal("failed to load keyring:", err)
+	}
+	ring.Now = clock
+	if len(ring.Keys()) != 3 {
+		t.Error("keys missing from loaded keyring:", len(ring.Keys()))
+	}
+	if err = ring.Check(tokens[0], info, message); err != nil {
+		t.Error("verify-only key rejected by loaded keyring:", err)
+	}
+
+	// tokens of the replaced key verify during the overlap only
+
+	ring, _ = rotator.Keyring()
+	if err = ring.Check(tokens[0], info, message); err != nil {
+		t.Error("verify-only key rejected during overlap:", err)
+	}
+	if err = ring.Check(tokens[3], info, message); err != nil {
+		t.Error("active key rejected:", err)
+	}
+
+	now = now.Add(time.Hour)
+	if changed, _ := rotator.Update(); !changed {
+		t.Error("verify-only key not retired after overlap")
+	}
+
+	ring, _ = rotator.Keyring()
+	if err = ring.Check(tokens[0], info, message); err != ErrorUnknownKey {
+		t.Error("retired key accepted:", err)
+	}
+
+	// the schedule rotates as well
+
+	now = now.Add(24 * time.Hour)
+	sk, _ := rotator.Issue()
+	if sk.GetPublicKey().X.Cmp(successor.X) == 0 {
+		t.Error("active key not rotated after lifetime")
+	}
+
+	if err = rotator.Save(dir, []byte("passphrase")); err != nil {
+		t.Fatal("failed to save rotator:", err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1+3 {
+		t.Error("secret keys of retired keys not deleted:", len(files))
+	}
+}
@@ -0,0 +1,307 @@
+package signing
+
+// Constant-time scalar arithmetic.
+//
+// Sums, differences and products of secret scalars modulo the curve order
+// are computed by a scalarField instead of math/big, whose running time
+// depends on the values. Elements are fixed-width little-endian 64 bit
+// limbs, carries come from math/bits, conditional subtractions are done by
+// masking, and products use Montgomery multiplication (CIOS), so every
+// operation runs the same instructions for every value. Scalars are passed
+// to ScalarBaseMult and ScalarMult as fixed-width big-endian bytes, so their
+// length does not vary either.
+//
+// Values enter and leave the field as *big.Int. The conversion reads the
+// words of the value, whose number only depends on its top word, and
+// reduces inputs of at most the bit length of the order with one masked
+// subtraction.
+
+import (
+	"crypto/elliptic"
+	"math/big"
+	"math/bits"
+	"sync"
+)
+
+type scalarField struct {
+	modulus []uint64 // little-endian limbs
+	bitLen  int
+	size    int      // bytes of the fixed-width encoding
+	words   int      // word capacity of results
+	inverse uint64   // -modulus^-1 mod 2^64, for Montgomery reduction
+	rr      []uint64 // R^2 mod modulus, with R = 2^(64 limbs)
+}
+
+var scalarFields sync.Map // *scalarField by curve name
+
+// scalarFieldOf returns the field of scalars modulo the order of curve.
+func scalarFieldOf(curve elliptic.Curve) *scalarField {
+	params := curve.Params()
+
+	if field, ok := scalarFields.Load(params.Name); ok {
+		return field.(*scalarField)
+	}
+
+	field, _ := scalarFields.LoadOrStore(params.Name, newScalarField(params.N))
+	return field.(*scalarField)
+}
+
+// newScalarField returns arithmetic modulo modulus. Multiplication needs an
+// odd modulus; addition, subtraction and reduction work for any.
+func newScalarField(modulus *big.Int) *scalarField {
+	limbs := (modulus.BitLen() + 63) / 64
+
+	field := &scalarField{
+		modulus: make([]uint64, limbs),
+		bitLen:  modulus.BitLen(),
+		size:    (modulus.BitLen() + 7) / 8,
+		words:   2*(modulus.BitLen()+bits.UintSize-1)/bits.UintSize + 1,
+	}
+	field.putLimbs(field.modulus, modulus)
+
+	if modulus.Bit(0) == 1 {
+		// Newton's iteration doubles the correct low bits each step
+		inverse := uint64(1)
+		for i := 0; i < 6; i++ {
+			inverse *= 2 - field.modulus[0]*inverse
+		}
+		field.inverse = -inverse
+
+		rr := new(big.Int).Lsh(big.NewInt(1), uint(128*limbs))
+		rr.Mod(rr, modulus)
+		field.rr = make([]uint64, limbs)
+		field.putLimbs(field.rr, rr)
+	}
+
+	return field
+}
+
+// putLimbs writes the words of x into z.
+func (field *scalarField) putLimbs(z []uint64, x *big.Int) {
+	for index, word := range x.Bits() {
+		limb := index * bits.UintSize / 64
+		if limb >= len(z) {
+			break
+		}
+		z[limb] |= uint64(word) << uint(index*bits.UintSize%64)
+	}
+}
+
+// element returns x, reduced modulo the modulus, as limbs.
+func (field *scalarField) element(x *big.Int) []uint64 {
+	if x.Sign() < 0 || x.BitLen() > field.bitLen {
+		// never the case for secret scalars, which are reduced
+		x = new(big.Int).Mod(x, field.modulusInt())
+	}
+
+	z := make([]uint64, len(field.modulus))
+	field.putLimbs(z, x)
+	field.reduceOnce(z, 0)
+
+	return z
+}
+
+func (field *scalarField) modulusInt() *big.Int {
+	x := new(big.Int)
+	for index := len(field.modulus) - 1; index >= 0; index-- {
+		x.Lsh(x, 64)
+		x.Or(x, new(big.Int).SetUint64(field.modulus[index]))
+	}
+	return x
+}
+
+// int returns x as a secret scalar and wipes x.
+func (field *scalarField) int(x []uint64) *big.Int {
+	words := make([]big.Word, len(x)*64/bits.UintSize, field.words)
+	for index := range words {
+		words[index] = big.Word(x[index*bits.UintSize/64] >> uint(index*bits.UintSize%64))
+	}
+
+	wipeLimbs(x)
+
+	return new(big.Int).SetBits(words)
+}
+
+// bytes returns x as fixed-width big-endian bytes.
+func (field *scalarField) bytes(x []uint64) []byte {
+	buff := make([]byte, field.size)
+	for index := range buff {
+		position := len(buff) - 1 - index
+		buff[index] = byte(x[position/8] >> uint(8*(position%8)))
+	}
+	return buff
+}
+
+// reduceOnce subtracts the modulus from the value carry:z if it is not
+// smaller than the modulus. The value must be below twice the modulus.
+func (field *scalarField) reduceOnce(z []uint64, carry uint64) {
+	diff := make([]uint64, len(z))
+	defer wipeLimbs(diff)
+
+	var borrow uint64
+	for index := range z {
+		diff[index], borrow = bits.Sub64(z[index], field.modulus[index], borrow)
+	}
+
+	// keep z if the subtraction borrowed past the carry
+	_, borrow = bits.Sub64(carry, 0, borrow)
+	ctSelect(z, z, diff, borrow)
+}
+
+func (field *scalarField) add(z, x, y []uint64) {
+	var carry uint64
+	for index := range z {
+		z[index], carry = bits.Add64(x[index], y[index], carry)
+	}
+
+	field.reduceOnce(z, carry)
+}
+
+func (field *scalarField) sub(z, x, y []uint64) {
+	var borrow uint64
+	for index := range z {
+		z[index], borrow = bits.Sub64(x[index], y[index], borrow)
+	}
+
+	// add the modulus back if the difference is negative
+	mask := -borrow
+	var carry uint64
+	for index := range z {
+		z[index], carry = bits.Add64(z[index], field.modulus[index]&mask, carry)
+	}
+}
+
+// montMul sets z to x*y/R modulo the modulus.
+func (field *scalarField) montMul(z, x, y []uint64) {
+	n := len(field.modulus)
+	t := make([]uint64, n+2)
+	defer wipeLimbs(t)
+
+	for i := 0; i < n; i++ {
+		var c, carry uint64
+
+		for j := 0; j < n; j++ {
+			hi, lo := bits.Mul64(x[j], y[i])
+			lo, carry = bits.Add64(lo, t[j], 0)
+			hi += carry
+			lo, carry = bits.Add64(lo, c, 0)
+			hi += carry
+			t[j], c = lo, hi
+		}
+		t[n], carry = bits.Add64(t[n], c, 0)
+		t[n+1] = carry
+
+		u := t[0] * field.inverse
+
+		hi, lo := bits.Mul64(u, field.modulus[0])
+		_, carry = bits.Add64(lo, t[0], 0)
+		c = hi + carry
+
+		for j := 1; j < n; j++ {
+			hi, lo = bits.Mul64(u, field.modulus[j])
+			lo, carry = bits.Add64(lo, t[j], 0)
+			hi += carry
+			lo, carry = bits.Add64(lo, c, 0)
+			hi += carry
+			t[j-1], c = lo, hi
+		}
+		t[n-1], carry = bits.Add64(t[n], c, 0)
+		t[n] = t[n+1] + carry
+	}
+
+	copy(z, t[:n])
+	field.reduceOnce(z, t[n])
+}
+
+func (field *scalarField) mul(z, x, y []uint64) {
+	product := make([]uint64, len(z))
+	defer wipeLimbs(product)
+
+	field.montMul(product, x, y)
+	field.montMul(z, product, field.rr)
+}
+
+// reduce returns the big-endian value data modulo the modulus, shifting it
+// in one bit at a time.
+func (field *scalarField) reduce(data []byte) []uint64 {
+	z := make([]uint64, len(field.modulus))
+	bit := make([]uint64, len(field.modulus))
+
+	for _, value := range data {
+		for shift := 7; shift >= 0; shift-- {
+			field.add(z, z, z)
+			bit[0] = uint64(value>>uint(shift)) & 1
+			field.add(z, z, bit)
+		}
+	}
+
+	wipeLimbs(bit)
+
+	return z
+}
+
+// addInt, subInt and mulInt compute on scalars in constant time and return
+// secret scalars.
+
+func (field *scalarField) addInt(x, y *big.Int) *big.Int {
+	a, b := field.element(x), field.element(y)
+	defer wipeLimbs(b)
+
+	field.add(a, a, b)
+	return field.int(a)
+}
+
+func (field *scalarField) subInt(x, y *big.Int) *big.Int {
+	a, b := field.element(x), field.element(y)
+	defer wipeLimbs(b)
+
+	field.sub(a, a, b)
+	return field.int(a)
+}
+
+func (field *scalarField) mulInt(x, y *big.Int) *big.Int {
+	a, b := field.element(x), field.element(y)
+	defer wipeLimbs(b)
+
+	field.mul(a, a, b)
+	return field.int(a)
+}
+
+// bytesInt returns the scalar x as fixed-width big-endian bytes, the input
+// for ScalarBaseMult and ScalarMult. The caller should wipe them.
+func (field *scalarField) bytesInt(x *big.Int) []byte {
+	a := field.element(x)
+	defer wipeLimbs(a)
+
+	return field.bytes(a)
+}
+
+// scalarBaseMult is ScalarBaseMult for a secret scalar.
+func scalarBaseMult(curve elliptic.Curve, k *big.Int) (*big.Int, *big.Int) {
+	buff := scalarFieldOf(curve).bytesInt(k)
+	defer wipeBytes(buff)
+
+	return curve.ScalarBaseMult(buff)
+}
+
+// scalarMult is ScalarMult for a secret scalar.
+func scalarMult(curve elliptic.Curve, x, y *big.Int, k *big.Int) (*big.Int, *big.Int) {
+	buff := scalarFieldOf(curve).bytesInt(k)
+	defer wipeBytes(buff)
+
+	return curve.ScalarMult(x, y, buff)
+}
+
+// ctSelect sets z to x if choice is 1 and to y if it is 0.
+func ctSelect(z, x, y []uint64, choice uint64) {
+	mask := -choice
+	for index := range z {
+		z[index] = y[index] ^ (mask & (x[index] ^ y[index]))
+	}
+}
+
+func wipeLimbs(limbs []uint64) {
+	for index := range limbs {
+		limbs[index] = 0
+	}
+}
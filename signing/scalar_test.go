@@ -0,0 +1,148 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+var testCurves = []elliptic.Curve{elliptic.P224(), elliptic.P256(), elliptic.P384(), elliptic.P521()}
+
+func TestScalarField(t *testing.T) {
+	for _, curve := range testCurves {
+		order := curve.Params().N
+		field := scalarFieldOf(curve)
+
+		values := []*big.Int{
+			big.NewInt(0),
+			big.NewInt(1),
+			new(big.Int).Sub(order, big.NewInt(1)),
+			new(big.Int).Sub(order, big.NewInt(2)),
+		}
+		for i := 0; i < 16; i++ {
+			x, _ := rand.Int(rand.Reader, order)
+			values = append(values, x)
+		}
+
+		for _, x := range values {
+			for _, y := range values {
+				sum := new(big.Int).Add(x, y)
+				difference := new(big.Int).Sub(x, y)
+				product := new(big.Int).Mul(x, y)
+
+				if field.addInt(x, y).Cmp(sum.Mod(sum, order)) != 0 {
+					t.Fatal("wrong sum on", curve.Params().Name)
+				}
+				if field.subInt(x, y).Cmp(difference.Mod(difference, order)) != 0 {
+					t.Fatal("wrong difference on", curve.Params().Name)
+				}
+				if field.mulInt(x, y).Cmp(product.Mod(product, order)) != 0 {
+					t.Fatal("wrong product on", curve.Params().Name)
+				}
+			}
+
+			expected := make([]byte, scalarSize(curve))
+			putFixed(expected, x)
+			if !bytes.Equal(field.bytesInt(x), expected) {
+				t.Fatal("wrong encoding on", curve.Params().Name)
+			}
+		}
+
+		// unreduced inputs are reduced
+
+		unreduced := new(big.Int).Add(order, big.NewInt(5))
+		if field.addInt(unreduced, big.NewInt(0)).Int64() != 5 {
+			t.Fatal("unreduced input not reduced on", curve.Params().Name)
+		}
+
+		// wide values reduce modulo any modulus
+
+		data := make([]byte, 2*scalarSize(curve))
+		rand.Read(data)
+		for _, modulus := range []*big.Int{order, new(big.Int).Sub(order, big.NewInt(1))} {
+			expected := new(big.Int).SetBytes(data)
+			expected.Mod(expected, modulus)
+
+			reduced := newScalarField(modulus)
+			if reduced.int(reduced.reduce(data)).Cmp(expected) != 0 {
+				t.Fatal("wrong reduction on", curve.Params().Name)
+			}
+		}
+	}
+}
+
+// medianDurations times batches of f on two classes of inputs in turn and
+// returns the median batch time of each.
+func medianDurations(rounds int, batch int, f func(class int)) [2]time.Duration {
+	var samples [2][]time.Duration
+
+	for round := 0; round < rounds; round++ {
+		for class := 0; class < 2; class++ {
+			start := time.Now()
+			for i := 0; i < batch; i++ {
+				f(class)
+			}
+			samples[class] = append(samples[class], time.Since(start))
+		}
+	}
+
+	var medians [2]time.Duration
+	for class := range samples {
+		sort.Slice(samples[class], func(i, j int) bool { return samples[class][i] < samples[class][j] })
+		medians[class] = samples[class][len(samples[class])/2]
+	}
+
+	return medians
+}
+
+// TestScalarFieldTiming compares the time secret scalar operations take on
+// inputs that variable-time code would treat differently. Timing is noisy
+// on shared machines, so it only runs with PBLIND_TIMING=1.
+func TestScalarFieldTiming(t *testing.T) {
+	if os.Getenv("PBLIND_TIMING") != "1" || testing.Short() {
+		t.Skip("timing test runs with PBLIND_TIMING=1")
+	}
+
+	for _, curve := range testCurves {
+		order := curve.Params().N
+		field := scalarFieldOf(curve)
+
+		// a sparse value and a random value of the same bit length, and a
+		// short value against a full-width one
+
+		top := new(big.Int).Lsh(big.NewInt(1), uint(order.BitLen()-2))
+		sparse := new(big.Int).Add(top, big.NewInt(1))
+		dense, _ := rand.Int(rand.Reader, top)
+		dense.Add(dense, top)
+
+		full := new(big.Int).Sub(order, big.NewInt(1))
+		short := big.NewInt(1)
+
+		operations := []struct {
+			name   string
+			inputs [2]*big.Int
+			batch  int
+			f      func(x *big.Int)
+		}{
+			{"arithmetic", [2]*big.Int{sparse, dense}, 200, func(x *big.Int) { field.subInt(field.mulInt(x, x), x) }},
+			{"product", [2]*big.Int{short, full}, 200, func(x *big.Int) { field.mulInt(x, dense) }},
+			{"encoding", [2]*big.Int{short, full}, 200, func(x *big.Int) { field.bytesInt(x) }},
+			{"base multiplication", [2]*big.Int{short, full}, 10, func(x *big.Int) { scalarBaseMult(curve, x) }},
+		}
+
+		for _, operation := range operations {
+			inputs, f := operation.inputs, operation.f
+			medians := medianDurations(51, operation.batch, func(class int) { f(inputs[class]) })
+
+			ratio := float64(medians[0]) / float64(medians[1])
+			if ratio < 0.8 || ratio > 1.25 {
+				t.Errorf("%s scalar %s time depends on the values: %v and %v", curve.Params().Name, operation.name, medians[0], medians[1])
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package signing
+
+// Secret hygiene.
+//
+// Secret scalars, the key and the session nonces and blinding factors, are
+// *big.Int values backed by a word buffer that is allocated once, large
+// enough for any product of two scalars, so arithmetic on them does not
+// leave copies behind in reallocated buffers. Destroy zeroes that buffer
+// once a key or a protocol state is no longer needed, whether the protocol
+// finished or was aborted. On Linux a secret key can also be moved to
+// pages of its own that are locked into memory, so it is never written to
+// swap.
+
+import (
+	"crypto/elliptic"
+	"io"
+	"math/big"
+	"math/bits"
+	"sync"
+	"unsafe"
+)
+
+const (
+	stateSignerDestroyed    = -1
+	stateRequesterDestroyed = -1
+)
+
+// secretWords is the size of the word buffer of secret scalars.
+func secretWords(curve elliptic.Curve) int {
+	return 2*(curve.Params().N.BitLen()+bits.UintSize-1)/bits.UintSize + 1
+}
+
+func newSecretInt(curve elliptic.Curve) *big.Int {
+	return new(big.Int).SetBits(make([]big.Word, 0, secretWords(curve)))
+}
+
+// fixSecretInt moves the value of x into a buffer of the secret size and
+// wipes the buffer it had. Copies of the pointer x stay valid.
+func fixSecretInt(curve elliptic.Curve, x *big.Int) {
+	old := x.Bits()
+
+	size := secretWords(curve)
+	if len(old) > size {
+		size = len(old)
+	}
+
+	words := make([]big.Word, len(old), size)
+	copy(words, old)
+	x.SetBits(words)
+
+	wipeWords(old)
+}
+
+// randomScalar returns a uniform scalar in [0, N) by rejection sampling.
+func randomScalar(random io.Reader, curve elliptic.Curve) (*big.Int, error) {
+	order := curve.Params().N
+
+	buff := make([]byte, scalarSize(curve))
+	defer wipeBytes(buff)
+
+	excess := uint(len(buff)*8 - order.BitLen())
+	x := newSecretInt(curve)
+
+	for {
+		if _, err := io.ReadFull(random, buff); err != nil {
+			return nil, err
+		}
+
+		buff[0] &= byte(0xff >> excess)
+		x.SetBytes(buff)
+
+		if x.Cmp(order) < 0 {
+			return x, nil
+		}
+	}
+}
+
+// wipeInt zeroes the whole word buffer of x and sets it to 0.
+func wipeInt(x *big.Int) {
+	if x == nil {
+		return
+	}
+
+	words := x.Bits()
+	wipeWords(words[:cap(words)])
+	x.SetInt64(0)
+}
+
+func wipeWords(words []big.Word) {
+	for index := range words {
+		words[index] = 0
+	}
+}
+
+func wipeBytes(buff []byte) {
+	for index := range buff {
+		buff[index] = 0
+	}
+}
+
+// memoryWords is memory as an empty buffer of words.
+func memoryWords(memory []byte) []big.Word {
+	size := len(memory) * 8 / bits.UintSize
+	return (*[1 << 27]big.Word)(unsafe.Pointer(&memory[0]))[:0:size]
+}
+
+// keyState is shared by the copies of a SecretKey, which share its scalar.
+// Using the scalar takes a read lock, so that Lock and Destroy never change
+// it under a session that is responding.
+type keyState struct {
+	mutex  sync.RWMutex
+	locked []byte // pages locked by Lock that hold the scalar
+}
+
+// keyStatesMutex guards creating the state of keys built without one.
+var keyStatesMutex sync.Mutex
+
+func newKeyState() *keyState {
+	return &keyState{}
+}
+
+// state returns the shared state of sk. Keys made by this package have one
+// from the start, so that their copies share it; others get one on first
+// use.
+func (sk *SecretKey) state() *keyState {
+	keyStatesMutex.Lock()
+	defer keyStatesMutex.Unlock()
+
+	if sk.shared == nil {
+		sk.shared = newKeyState()
+	}
+
+	return sk.shared
+}
+
+// Lock moves the scalar of sk to pages of its own that are kept in memory,
+// so it is not written to swap. It is only supported on Linux, where it may
+// need CAP_IPC_LOCK or a raised RLIMIT_MEMLOCK, and returns ErrorMemoryLock
+// elsewhere. Destroy unlocks the pages.
+func (sk *SecretKey) Lock() error {
+	state := sk.state()
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if state.locked != nil {
+		return nil
+	}
+	if sk.Scalar.Sign() == 0 {
+		return ErrorKeyDestroyed
+	}
+
+	old := sk.Scalar.Bits()
+
+	size := secretWords(sk.Curve)
+	if len(old) > size {
+		size = len(old)
+	}
+
+	memory, err := lockMemory(size * bits.UintSize / 8)
+	if err != nil {
+		return err
+	}
+
+	words := memoryWords(memory)[:len(old)]
+	copy(words, old)
+	sk.Scalar.SetBits(words)
+	wipeWords(old[:cap(old)])
+
+	state.locked = memory
+
+	return nil
+}
+
+// Destroy wipes the scalar of sk. The key cannot be used afterwards: it
+// waits for sessions that are responding, and later ones fail with
+// ErrorKeyDestroyed.
+func (sk *SecretKey) Destroy() {
+	state := sk.state()
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	wipeInt(sk.Scalar)
+
+	if state.locked != nil {
+		// the scalar must not keep pointing into the unmapped pages
+		sk.Scalar.SetBits(nil)
+		unlockMemory(state.locked)
+		state.locked = nil
+	}
+}
+
+// Destroy wipes the session nonces. It does not touch the key, which
+// belongs to the key backend.
+func (st *StateSigner) Destroy() {
+	for _, secret := range []*big.Int{st.U, st.S, st.D} {
+		wipeInt(secret)
+	}
+
+	st.State = stateSignerDestroyed
+}
+
+// Destroy wipes the blinding factors and the seed they came from. The
+// signature stays available once Message3 has been processed, and the
+// protocol cannot be continued before that.
+func (st *StateRequester) Destroy() {
+	for _, secret := range []*big.Int{st.T1, st.T2, st.T3, st.T4} {
+		wipeInt(secret)
+	}
+
+	wipeBytes(st.seed)
+	st.seed = nil
+
+	if st.State != stateRequesterMsg3Processed {
+		st.State = stateRequesterDestroyed
+	}
+}
@@ -0,0 +1,228 @@
+package signing
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"os"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestDestroy(t *testing.T) {
+	curve := elliptic.P256()
+
+	sk, _ := NewSecretKey(curve)
+	pk := sk.GetPublicKey()
+	info, _ := CompressInfo(curve, []byte("info"))
+	message := []byte("message")
+
+	if err := sk.Lock(); err != nil {
+		t.Log("memory not locked:", err)
+	}
+
+	requester, _ := CreateRequester(pk, info, message)
+	signer, _ := CreateSigner(*sk, info)
+
+	// secrets live in fixed buffers that are wiped completely
+
+	words := signer.U.Bits()
+	if cap(words) != secretWords(curve) {
+		t.Fatal("nonce buffer has", cap(words), "words")
+	}
+
+	msg1, _ := signer.CreateMessage1()
+	requester.ProcessMessage1(msg1)
+	msg2, _ := requester.CreateMessage2()
+	signer.ProcessMessage2(msg2)
+	msg3, _ := signer.CreateMessage3()
+
+	signer.Destroy()
+	for _, word := range words[:cap(words)] {
+		if word != 0 {
+			t.Fatal("nonce not wiped")
+		}
+	}
+	if _, err := signer.CreateMessage3(); err != ErrorInvalidSignerState {
+		t.Fatal("destroyed signer still answers:", err)
+	}
+
+	// the message outlives the signer and the signature the requester
+
+	if err := requester.ProcessMessage3(msg3); err != nil {
+		t.Fatal("failed to process msg3:", err)
+	}
+	requester.Destroy()
+
+	if requester.T1.Sign() != 0 || requester.T4.Sign() != 0 {
+		t.Fatal("blinding factors not wiped")
+	}
+
+	sig, err := requester.Signature()
+	if err != nil || !pk.Check(sig, info, message) {
+		t.Fatal("signature lost by Destroy:", err)
+	}
+
+	// an aborted requester cannot be continued
+
+	aborted, _ := CreateRequester(pk, info, message)
+	aborted.Destroy()
+	if err = aborted.ProcessMessage1(msg1); err != ErrorInvalidRequesterState {
+		t.Fatal("destroyed requester continued:", err)
+	}
+
+	sk.Destroy()
+	if sk.Scalar.Sign() != 0 || sk.shared.locked != nil {
+		t.Fatal("secret key not wiped")
+	}
+}
+
+func TestLockPages(t *testing.T) {
+	curve := elliptic.P256()
+	info, _ := CompressInfo(curve, []byte("info"))
+	message := []byte("message")
+
+	first, _ := NewSecretKey(curve)
+	second, _ := NewSecretKey(curve)
+	if err := first.Lock(); err != nil {
+		t.Skip("memory not locked:", err)
+	}
+	if err := second.Lock(); err != nil {
+		t.Skip("memory not locked:", err)
+	}
+
+	// each key has pages of its own, so unlocking one leaves the other
+
+	pageSize := uintptr(os.Getpagesize())
+	firstPage := uintptr(unsafe.Pointer(&first.shared.locked[0]))
+	secondPage := uintptr(unsafe.Pointer(&second.shared.locked[0]))
+	if firstPage%pageSize != 0 || secondPage%pageSize != 0 || firstPage == secondPage {
+		t.Fatal("locked keys do not have pages of their own")
+	}
+	if uintptr(unsafe.Pointer(&second.Scalar.Bits()[0])) != secondPage {
+		t.Fatal("scalar not moved to the locked pages")
+	}
+
+	first.Destroy()
+	if _, err := first.Respond(big.NewInt(1), big.NewInt(1)); err != ErrorKeyDestroyed {
+		t.Fatal("destroyed key still responds:", err)
+	}
+
+	issueToken(t, second, info, message)
+	second.Destroy()
+
+	// a locked rotator locks its new keys and wipes those it retires
+
+	rotator, _ := NewKeyRotator(RotationPolicy{Overlap: 0}, nil, nil)
+	if err := rotator.Lock(); err != nil {
+		t.Fatal("failed to lock rotator:", err)
+	}
+	old, _ := rotator.Issue()
+
+	if err := rotator.Rotate(); err != nil {
+		t.Fatal("failed to rotate:", err)
+	}
+
+	for _, key := range rotator.Keys() {
+		if key.Sk != nil && key.Sk.shared.locked == nil {
+			t.Error("key not locked:", key.State)
+		}
+	}
+	if old.Scalar.Sign() != 0 || old.shared.locked != nil {
+		t.Error("retired key not wiped")
+	}
+}
+
+func TestRetireWhileResponding(t *testing.T) {
+	curve := elliptic.P256()
+	info, _ := CompressInfo(curve, []byte("info"))
+	message := []byte("message")
+
+	rotator, _ := NewKeyRotator(RotationPolicy{}, nil, nil)
+	if err := rotator.Lock(); err != nil {
+		t.Log("memory not locked:", err)
+	}
+
+	sk, _ := rotator.Issue()
+	pk := sk.GetPublicKey()
+	keyID, _ := pk.Fingerprint()
+
+	backend := NewLocalSigner(func() (KeyBackend, error) { return sk, nil })
+
+	// sessions started under the key answer while it is retired, with a
+	// signature or ErrorKeyDestroyed
+
+	type session struct {
+		id        []byte
+		requester *StateRequester
+		msg2      Message2
+	}
+
+	var sessions []session
+	for i := 0; i < 32; i++ {
+		requester, _ := CreateRequester(pk, info, message)
+		id, msg1, err := backend.Begin(info)
+		if err != nil {
+			t.Fatal("failed to begin session:", err)
+		}
+		if err = requester.ProcessMessage1(msg1); err != nil {
+			t.Fatal("failed to process msg1:", err)
+		}
+		msg2, _ := requester.CreateMessage2()
+		sessions = append(sessions, session{id: id, requester: requester, msg2: msg2})
+	}
+
+	var group sync.WaitGroup
+	for _, started := range sessions {
+		group.Add(1)
+		go func(started session) {
+			defer group.Done()
+
+			msg3, err := backend.Respond(started.id, started.msg2)
+			if err == ErrorKeyDestroyed {
+				return
+			}
+			if err != nil {
+				t.Error("failed to respond:", err)
+				return
+			}
+			if err = started.requester.ProcessMessage3(msg3); err != nil {
+				t.Error("response does not give a signature:", err)
+			}
+		}(started)
+	}
+
+	if err := rotator.Retire(keyID); err != nil {
+		t.Fatal("failed to retire key:", err)
+	}
+	group.Wait()
+
+	if _, err := sk.Respond(big.NewInt(1), big.NewInt(1)); err != ErrorKeyDestroyed {
+		t.Error("retired key still responds:", err)
+	}
+}
+
+func TestRandomScalar(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P224(), elliptic.P521()} {
+		order := curve.Params().N
+		maximum := new(big.Int)
+
+		for i := 0; i < 64; i++ {
+			x, err := randomScalar(rand.Reader, curve)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if x.Sign() < 0 || x.Cmp(order) >= 0 {
+				t.Fatal("scalar out of range")
+			}
+			if x.Cmp(maximum) > 0 {
+				maximum = x
+			}
+		}
+
+		if maximum.BitLen() < order.BitLen()-8 {
+			t.Error("scalars are too short for", curve.Params().Name)
+		}
+	}
+}
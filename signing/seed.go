@@ -0,0 +1,96 @@
+package signing
+
+// Seeded requesters.
+//
+// A requester created from a 32-byte session seed derives its blinding
+// factors T1 to T4 from the seed instead of drawing them at random, so a
+// wallet with many pending issuances only has to keep one seed per session.
+// Calling CreateRequesterFromSeed again with the same key, info, message and
+// seed rebuilds the same requester, and saved states of seeded requesters
+// hold the seed instead of the four scalars. Known seeds also make sessions
+// replayable for debugging. A seed must never be used for two sessions.
+//
+// The factors are HKDF-SHA512 output under a fixed salt, with the curve, key,
+// info and message as info, each 128 bits longer than the curve order and
+// reduced modulo N.
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const requesterSeedSalt = "pblind requester seed v1"
+
+const RequesterSeedSize = 32
+
+// NewRequesterSeed returns a random session seed.
+func NewRequesterSeed() ([]byte, error) {
+	seed := make([]byte, RequesterSeedSize)
+	_, err := rand.Read(seed)
+	return seed, err
+}
+
+func CreateRequesterFromSeed(pk *PublicKey, info Info, message []byte, seed []byte) (*StateRequester, error) {
+	if len(seed) != RequesterSeedSize {
+		return nil, ErrorInvalidSeed
+	}
+
+	st := StateRequester{
+		State:   stateRequesterFresh,
+		Info:    info,
+		Pk:      pk,
+		Curve:   pk.Curve,
+		Message: message,
+		seed:    append([]byte{}, seed...),
+	}
+
+	if err := st.deriveBlindingFactors(); err != nil {
+		return nil, err
+	}
+
+	return &st, nil
+}
+
+// Seed returns the session seed of a seeded requester and nil otherwise.
+func (st *StateRequester) Seed() []byte {
+	return st.seed
+}
+
+func (st *StateRequester) deriveBlindingFactors() error {
+	entry, err := lookupCurve(st.Curve)
+	if err != nil {
+		return err
+	}
+
+	if st.Pk == nil || !st.Curve.IsOnCurve(st.Pk.X, st.Pk.Y) || st.Info.X == nil || !st.Curve.IsOnCurve(st.Info.X, st.Info.Y) {
+		return ErrorPointNotOnCurve
+	}
+
+	context := appendLengthPrefixed(nil, []byte(entry.Name))
+	context = appendLengthPrefixed(context, marshalCompressed(st.Curve, st.Pk.X, st.Pk.Y))
+	context = appendLengthPrefixed(context, marshalCompressed(st.Curve, st.Info.X, st.Info.Y))
+	context = appendLengthPrefixed(context, st.Message)
+
+	reader := hkdf.New(sha512.New, st.seed, []byte(requesterSeedSalt), context)
+	field := scalarFieldOf(st.Curve)
+
+	buff := make([]byte, scalarSize(st.Curve)+16)
+	defer wipeBytes(buff)
+
+	factors := make([]*big.Int, 4)
+	for index := range factors {
+		if _, err = io.ReadFull(reader, buff); err != nil {
+			return err
+		}
+
+		factors[index] = field.int(field.reduce(buff))
+	}
+
+	st.T1, st.T2, st.T3, st.T4 = factors[0], factors[1], factors[2], factors[3]
+
+	return nil
+}
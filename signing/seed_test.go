@@ -0,0 +1,89 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"testing"
+)
+
+func TestSeededRequester(t *testing.T) {
+	seed := bytes.Repeat([]byte{9}, RequesterSeedSize)
+
+	for _, curve := range testCurves {
+		sk, _ := NewSecretKey(curve)
+		pk := sk.GetPublicKey()
+		info, _ := CompressInfo(curve, []byte("info"))
+
+		a, err := CreateRequesterFromSeed(pk, info, []byte("message"), seed)
+		if err != nil {
+			t.Fatal("failed to create seeded requester:", err)
+		}
+		b, _ := CreateRequesterFromSeed(pk, info, []byte("message"), seed)
+		c, _ := CreateRequesterFromSeed(pk, info, []byte("other message"), seed)
+
+		if a.T1.Cmp(b.T1) != 0 || a.T4.Cmp(b.T4) != 0 {
+			t.Error("derivation is not deterministic")
+		}
+		if a.T1.Cmp(c.T1) == 0 || a.T1.Cmp(a.T2) == 0 {
+			t.Error("blinding factors repeat")
+		}
+
+		// the same seed replays the same session against the same signer
+		// messages
+
+		signer, _ := CreateSigner(*sk, info)
+		msg1, _ := signer.CreateMessage1()
+		a.ProcessMessage1(msg1)
+		b.ProcessMessage1(msg1)
+		msg2, _ := a.CreateMessage2()
+		replayed, _ := b.CreateMessage2()
+		if msg2.E.Cmp(replayed.E) != 0 {
+			t.Error("replayed session differs")
+		}
+
+		// saved states keep the seed instead of the blinding factors
+
+		random, _ := CreateRequester(pk, info, []byte("message"))
+		random.ProcessMessage1(msg1)
+		random.CreateMessage2()
+
+		seeded, err := gobEncode(a)
+		if err != nil {
+			t.Fatal("failed to encode requester:", err)
+		}
+		unseeded, _ := gobEncode(random)
+		if len(seeded) >= len(unseeded) {
+			t.Error("seeded state is not smaller:", len(seeded), len(unseeded))
+		}
+
+		var restored StateRequester
+		if err = gobDecode(seeded, &restored); err != nil {
+			t.Fatal("failed to decode requester:", err)
+		}
+		if restored.T3.Cmp(a.T3) != 0 || !bytes.Equal(restored.Seed(), seed) {
+			t.Fatal("blinding factors not restored")
+		}
+
+		signer.ProcessMessage2(msg2)
+		msg3, _ := signer.CreateMessage3()
+		if err = restored.ProcessMessage3(msg3); err != nil {
+			t.Fatal("failed to process msg3:", err)
+		}
+
+		sig, _ := restored.Signature()
+		if !pk.Check(sig, info, []byte("message")) {
+			t.Error("failed to validate signature")
+		}
+
+		restored.Destroy()
+		if restored.Seed() != nil {
+			t.Error("seed not wiped")
+		}
+	}
+
+	sk, _ := NewSecretKey(elliptic.P256())
+	info, _ := CompressInfo(elliptic.P256(), []byte("info"))
+	if _, err := CreateRequesterFromSeed(sk.GetPublicKey(), info, nil, seed[:16]); err != ErrorInvalidSeed {
+		t.Error("accepted short seed:", err)
+	}
+}
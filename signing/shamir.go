@@ -0,0 +1,231 @@
+package signing
+
+// Shamir secret sharing of secret keys.
+//
+// The scalar of a secret key is the constant term of a random polynomial of
+// degree threshold-1 modulo the curve order, and share i is its value at i.
+// Any threshold shares give the polynomial back by Lagrange interpolation;
+// fewer reveal nothing about the key. A recovered key is only accepted if
+// it matches the public key.
+//
+// Shares are armored as "PBLIND KEY SHARE" PEM blocks, with the curve, the
+// key ID, the threshold and the share index in headers and a checksum over
+// all of them and the share value, so a mistyped share is caught before it
+// is combined.
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const pemKeyShare = "PBLIND KEY SHARE"
+
+const maxKeyShares = 255
+
+type KeyShare struct {
+	Curve     string // curve name
+	KeyID     []byte
+	Threshold int
+	Index     int
+	Value     *big.Int
+}
+
+// SplitSecretKey splits sk into shares of which any threshold recover it.
+func SplitSecretKey(sk *SecretKey, threshold int, shares int) ([]KeyShare, error) {
+	if threshold < 1 || shares < threshold || shares > maxKeyShares {
+		return nil, ErrorNotEnoughShares
+	}
+
+	entry, err := lookupCurve(sk.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := sk.GetPublicKey().Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+
+	field := scalarFieldOf(sk.Curve)
+
+	coefficients := []*big.Int{sk.Scalar}
+	for len(coefficients) < threshold {
+		coefficient, err := randomScalar(rand.Reader, sk.Curve)
+		if err != nil {
+			return nil, err
+		}
+		defer wipeInt(coefficient)
+		coefficients = append(coefficients, coefficient)
+	}
+
+	result := make([]KeyShare, shares)
+	for index := range result {
+		x := big.NewInt(int64(index + 1))
+
+		// Horner's rule
+		y := new(big.Int)
+		for power := len(coefficients) - 1; power >= 0; power-- {
+			product := field.mulInt(y, x)
+			wipeInt(y)
+			y = field.addInt(product, coefficients[power])
+			wipeInt(product)
+		}
+
+		result[index] = KeyShare{Curve: entry.Name, KeyID: keyID, Threshold: threshold, Index: index + 1, Value: y}
+	}
+
+	return result, nil
+}
+
+// CombineKeyShares recovers the secret key of pk from at least threshold of
+// its shares.
+func CombineKeyShares(shares []KeyShare, pk *PublicKey) (*SecretKey, error) {
+	keyID, err := pk.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(shares) == 0 || len(shares) < shares[0].Threshold {
+		return nil, ErrorNotEnoughShares
+	}
+
+	order := pk.Curve.Params().N
+	field := scalarFieldOf(pk.Curve)
+	seen := make(map[int]bool)
+
+	for _, share := range shares {
+		if string(share.KeyID) != string(keyID) || share.Threshold != shares[0].Threshold {
+			return nil, ErrorInvalidShare
+		}
+
+		if share.Index < 1 || share.Index > maxKeyShares || seen[share.Index] || share.Value == nil || share.Value.Cmp(order) >= 0 {
+			return nil, ErrorInvalidShare
+		}
+
+		seen[share.Index] = true
+	}
+
+	// Lagrange interpolation at 0, with public indices and secret values
+
+	scalar := new(big.Int)
+	for i, share := range shares {
+		numerator := big.NewInt(1)
+		denominator := big.NewInt(1)
+
+		for j, other := range shares {
+			if i == j {
+				continue
+			}
+
+			numerator.Mul(numerator, big.NewInt(int64(other.Index)))
+			numerator.Mod(numerator, order)
+			denominator.Mul(denominator, big.NewInt(int64(other.Index-share.Index)))
+			denominator.Mod(denominator, order)
+		}
+
+		coefficient := new(big.Int).ModInverse(denominator, order)
+		coefficient.Mul(coefficient, numerator)
+		coefficient.Mod(coefficient, order)
+
+		term := field.mulInt(coefficient, share.Value)
+		sum := field.addInt(scalar, term)
+		wipeInt(term)
+		wipeInt(scalar)
+		scalar = sum
+	}
+
+	sk := &SecretKey{Curve: pk.Curve, Scalar: scalar, shared: newKeyState()}
+
+	recovered := sk.GetPublicKey()
+	if recovered.X.Cmp(pk.X) != 0 || recovered.Y.Cmp(pk.Y) != 0 {
+		return nil, ErrorInvalidShare
+	}
+
+	return sk, nil
+}
+
+func (share KeyShare) headers() map[string]string {
+	return map[string]string{
+		"Curve":     share.Curve,
+		"Key-ID":    jsonEncoding.EncodeToString(share.KeyID),
+		"Threshold": strconv.Itoa(share.Threshold),
+		"Index":     strconv.Itoa(share.Index),
+	}
+}
+
+func (share KeyShare) checksum(value []byte) string {
+	headers := share.headers()
+
+	var input []byte
+	for _, key := range []string{"Curve", "Key-ID", "Threshold", "Index"} {
+		input = appendLengthPrefixed(input, []byte(headers[key]))
+	}
+	input = appendLengthPrefixed(input, value)
+
+	hash := sha256.Sum256(input)
+	return hex.EncodeToString(hash[:4])
+}
+
+func (share KeyShare) MarshalText() ([]byte, error) {
+	entry, err := lookupCurveName(share.Curve)
+	if err != nil {
+		return nil, err
+	}
+
+	if share.Value == nil || share.Value.Cmp(entry.Curve.Params().N) >= 0 {
+		return nil, ErrorInvalidShare
+	}
+
+	value := make([]byte, scalarSize(entry.Curve))
+	putFixed(value, share.Value)
+
+	headers := share.headers()
+	headers["Checksum"] = share.checksum(value)
+
+	return pem.EncodeToMemory(&pem.Block{Type: pemKeyShare, Headers: headers, Bytes: value}), nil
+}
+
+func (share *KeyShare) UnmarshalText(data []byte) error {
+	block, rest := pem.Decode(data)
+	if block == nil || block.Type != pemKeyShare || len(block.Headers) != 5 || len(strings.TrimSpace(string(rest))) != 0 {
+		return ErrorInvalidEncoding
+	}
+
+	entry, err := lookupCurveName(block.Headers["Curve"])
+	if err != nil {
+		return err
+	}
+
+	keyID, kidError := jsonEncoding.DecodeString(block.Headers["Key-ID"])
+	threshold, thresholdError := strconv.Atoi(block.Headers["Threshold"])
+	index, indexError := strconv.Atoi(block.Headers["Index"])
+	if kidError != nil || thresholdError != nil || indexError != nil || len(keyID) != keyIDSize {
+		return ErrorInvalidEncoding
+	}
+
+	if len(block.Bytes) != scalarSize(entry.Curve) {
+		return ErrorInvalidEncoding
+	}
+
+	decoded := KeyShare{
+		Curve:     entry.Name,
+		KeyID:     keyID,
+		Threshold: threshold,
+		Index:     index,
+		Value:     fromFixed(block.Bytes),
+	}
+
+	if subtle.ConstantTimeCompare([]byte(decoded.checksum(block.Bytes)), []byte(block.Headers["Checksum"])) != 1 {
+		return ErrorInvalidShare
+	}
+
+	*share = decoded
+
+	return nil
+}
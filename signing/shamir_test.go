@@ -0,0 +1,67 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"testing"
+)
+
+func TestKeyShares(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P224(), elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		sk, _ := NewSecretKey(curve)
+		pk := sk.GetPublicKey()
+
+		shares, err := SplitSecretKey(sk, 3, 5)
+		if err != nil {
+			t.Fatal("failed to split key:", err)
+		}
+
+		// shares go through their armored form
+
+		for index := range shares {
+			text, err := shares[index].MarshalText()
+			if err != nil {
+				t.Fatal("failed to armor share:", err)
+			}
+			if err = shares[index].UnmarshalText(text); err != nil {
+				t.Fatal("failed to parse share:", err)
+			}
+		}
+
+		for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+			var selected []KeyShare
+			for _, index := range subset {
+				selected = append(selected, shares[index])
+			}
+
+			recovered, err := CombineKeyShares(selected, pk)
+			if err != nil {
+				t.Fatal("failed to combine shares", subset, err)
+			}
+			if recovered.Scalar.Cmp(sk.Scalar) != 0 {
+				t.Error("recovered wrong key")
+			}
+		}
+
+		if _, err = CombineKeyShares(shares[:2], pk); err != ErrorNotEnoughShares {
+			t.Error("combined too few shares:", err)
+		}
+
+		other, _ := NewSecretKey(curve)
+		if _, err = CombineKeyShares(shares[:3], other.GetPublicKey()); err != ErrorInvalidShare {
+			t.Error("combined shares for another key:", err)
+		}
+	}
+
+	// a typo in an armored share is caught by the checksum
+
+	sk, _ := NewSecretKey(elliptic.P256())
+	shares, _ := SplitSecretKey(sk, 2, 2)
+	text, _ := shares[0].MarshalText()
+	typo := bytes.Replace(text, []byte("Index: 1"), []byte("Index: 2"), 1)
+
+	var share KeyShare
+	if err := share.UnmarshalText(typo); err != ErrorInvalidShare {
+		t.Error("accepted share with bad checksum:", err)
+	}
+}
@@ -3,10 +3,7 @@ package signing
 // https://link.springer.com/content/pdf/10.1007/3-540-44598-6_17.pdf
 
 import (
-	"bytes"
 	"crypto/elliptic"
-	"crypto/rand"
-	"encoding/gob"
 	"io/ioutil"
 	"math/big"
 )
@@ -22,62 +19,108 @@ type StateSigner struct {
 	State int
 	Info  Info           // shared Info for exchange
 	Curve elliptic.Curve // domain
-	Sk    SecretKey      // secret key
+	Key   KeyBackend     // holds the secret key, not saved with the state
 	U     *big.Int       // Scalar
 	S     *big.Int       // Scalar
 	D     *big.Int       // Scalar
 	E     *big.Int       // Scalar
+
+	keyID []byte // fingerprint of the public key of Key
 }
 
-func CreateSigner(sk SecretKey, info Info) (*StateSigner, error) {
+func CreateSigner(sk SecretKey, info Info, opts ...Option) (*StateSigner, error) {
+	return CreateSignerWithBackend(&sk, info, opts...)
+}
 
-	st := StateSigner{
-		State: stateSignerFresh,
-		Sk:    sk,
-		Curve: sk.Curve,
-		Info:  info,
-	}
+// CreateSignerWithBackend is CreateSigner for a key held by a KeyBackend.
+func CreateSignerWithBackend(key KeyBackend, info Info, opts ...Option) (*StateSigner, error) {
+	random := applyOptions(opts).random
 
-	order := st.Curve.Params().N
+	st, err := newStateSigner(key, info)
+	if err != nil {
+		return nil, err
+	}
 
-	var err error
+	if st.U, err = randomScalar(random, st.Curve); err != nil {
+		return nil, err
+	}
 
-	if st.U, err = rand.Int(rand.Reader, order); err != nil {
+	if st.S, err = randomScalar(random, st.Curve); err != nil {
 		return nil, err
 	}
 
-	if st.S, err = rand.Int(rand.Reader, order); err != nil {
+	if st.D, err = randomScalar(random, st.Curve); err != nil {
 		return nil, err
 	}
 
-	if st.D, err = rand.Int(rand.Reader, order); err != nil {
+	return st, nil
+}
+
+// newStateSigner returns a fresh signer for key and info without nonces.
+func newStateSigner(key KeyBackend, info Info) (*StateSigner, error) {
+	pk := key.GetPublicKey()
+
+	keyID, err := pk.Fingerprint()
+	if err != nil {
 		return nil, err
 	}
 
-	return &st, nil
+	return &StateSigner{
+		State: stateSignerFresh,
+		Key:   key,
+		Curve: pk.Curve,
+		Info:  info,
+		keyID: keyID,
+	}, nil
 }
 
-func LoadSigner(filename string) (*StateSigner, error) {
+// LoadSigner reads a signer state and attaches the key with the saved key
+// ID that keys returns. States saved before key backends hold their secret
+// key, which is attached if keys is nil. Without a key the state can only be
+// advanced to CreateMessage3.
+func LoadSigner(filename string, keys func(keyID []byte) (KeyBackend, error)) (*StateSigner, error) {
 	data, readError := ioutil.ReadFile(filename)
 	if readError != nil {
 		return nil, readError
 	}
 
-	var signer StateSigner
-	var buffer bytes.Buffer
-	buffer.Write(data)
-	decoder := gob.NewDecoder(&buffer)
-	decodeError := decoder.Decode(&signer)
+	signer := &StateSigner{}
+	decodeError := gobDecode(data, signer)
 	if decodeError != nil {
-		return nil, decodeError
+		legacy, legacyError := loadLegacySigner(data)
+		if legacyError != nil {
+			return nil, decodeError
+		}
+
+		signer = legacy
 	}
 
-	return &signer, nil
+	if keys == nil {
+		return signer, nil
+	}
+
+	key, keyError := keys(signer.keyID)
+	if keyError != nil {
+		return nil, keyError
+	}
+
+	keyID, fingerprintError := key.GetPublicKey().Fingerprint()
+	if fingerprintError != nil {
+		return nil, fingerprintError
+	}
+
+	if string(keyID) != string(signer.keyID) {
+		return nil, ErrorInvalidKeyID
+	}
+
+	signer.Key = key
+
+	return signer, nil
 }
 
 func (st *StateSigner) CreateMessage1() (Message1, error) {
 
-	var msg Message1
+	msg := Message1{Curve: st.Curve}
 
 	if st.State != stateSignerFresh {
 		return msg, ErrorInvalidSignerState
@@ -87,10 +130,10 @@ func (st *StateSigner) CreateMessage1() (Message1, error) {
 	 * b = S * g + D * z
 	 */
 
-	t1x, t1y := st.Curve.ScalarMult(st.Info.X, st.Info.Y, st.D.Bytes())
-	t2x, t2y := st.Curve.ScalarBaseMult(st.S.Bytes())
+	t1x, t1y := scalarMult(st.Curve, st.Info.X, st.Info.Y, st.D)
+	t2x, t2y := scalarBaseMult(st.Curve, st.S)
 
-	msg.Ax, msg.Ay = st.Curve.ScalarBaseMult(st.U.Bytes())
+	msg.Ax, msg.Ay = scalarBaseMult(st.Curve, st.U)
 	msg.Bx, msg.By = st.Curve.Add(t1x, t1y, t2x, t2y)
 
 	st.State = stateSignerMsg1Created
@@ -110,38 +153,29 @@ func (st *StateSigner) ProcessMessage2(msg Message2) error {
 
 func (st *StateSigner) CreateMessage3() (Message3, error) {
 
-	if st.State != stateSignerMsg2Processed {
+	if st.State != stateSignerMsg2Processed || st.Key == nil {
 		return Message3{}, ErrorInvalidSignerState
 	}
 
-	params := st.Curve.Params()
-
-	c := big.NewInt(0)
-	c.Sub(st.E, st.D)
-	c.Mod(c, params.N)
+	c := scalarFieldOf(st.Curve).subInt(st.E, st.D)
 
-	r := big.NewInt(0)
-	r.Mul(c, st.Sk.Scalar)
-	r.Sub(st.U, r)
-	r.Mod(r, params.N)
+	r, err := st.Key.Respond(st.U, c)
+	if err != nil {
+		return Message3{}, err
+	}
 
 	st.State = stateSignerMsg3Created
 
-	return Message3{R: r, C: c, S: st.S}, nil
+	// S is copied so the message outlives Destroy
+	return Message3{Curve: st.Curve, R: r, C: c, S: new(big.Int).Set(st.S)}, nil
 }
 
 func (signer *StateSigner) Save(filename string) error {
-	var buffer bytes.Buffer        // Stand-in for a network connection
-	encoder := gob.NewEncoder(&buffer)
-	encodingError := encoder.Encode(signer)
+	data, encodingError := gobEncode(signer)
 	if encodingError != nil {
 		return encodingError
 	}
+	defer wipeBytes(data)
 
-	requestWriteError := ioutil.WriteFile(filename, buffer.Bytes(), 0644)
-	if requestWriteError != nil {
-		return requestWriteError
-	}
-
-	return nil
-}
\ No newline at end of file
+	return writeFileAtomic(filename, data, 0600)
+}
@@ -0,0 +1,334 @@
+package signing
+
+// The signer daemon protocol.
+//
+// A SignerServer exposes a SignerBackend over a Unix socket and a
+// SocketSigner is the SignerBackend that talks to it. Only processes whose
+// peer credentials pass Authorize may connect, by default those of the user
+// running the server.
+//
+// Every request and response is a frame: a four byte big-endian length and
+// the body. Requests start with an operation, followed by
+//
+//	begin:   curve identifier (1) || info point
+//	respond: session ID (16) || message 2
+//
+// and responses start with a status, followed by either the session ID and
+// message 1 or message 3, or an error message.
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	signerBegin byte = 1 + iota
+	signerRespond
+)
+
+const (
+	signerOK byte = iota
+	signerError
+)
+
+const maxSignerFrame = 1 << 16
+
+const signerTimeout = 30 * time.Second
+
+// PeerCredentials identify the process at the other end of a Unix socket.
+type PeerCredentials struct {
+	PID int
+	UID int
+	GID int
+}
+
+type SignerServer struct {
+	Backend   SignerBackend
+	Authorize func(peer PeerCredentials) error // only the user of this process if nil
+}
+
+// SocketSigner is a SignerBackend served by a SignerServer at Path.
+type SocketSigner struct {
+	Path string
+}
+
+// signerErrors are the errors that keep their identity across the socket.
+var signerErrors = []error{
+	ErrorInvalidSignerState,
+	ErrorInvalidEncoding,
+	ErrorPointNotOnCurve,
+	ErrorUnknownCurve,
+	ErrorUnknownSession,
+	ErrorPeerNotAllowed,
+}
+
+// ListenSigner listens on a Unix socket at path that only the owner can
+// connect to. A stale socket file left at path is replaced.
+func ListenSigner(path string) (*net.UnixListener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// Serve handles connections from listener until it is closed.
+func (server *SignerServer) Serve(listener *net.UnixListener) error {
+	for {
+		connection, err := listener.AcceptUnix()
+		if err != nil {
+			return err
+		}
+
+		go server.handle(connection)
+	}
+}
+
+func (server *SignerServer) authorize(peer PeerCredentials) error {
+	if server.Authorize != nil {
+		return server.Authorize(peer)
+	}
+
+	if peer.UID != os.Getuid() {
+		return ErrorPeerNotAllowed
+	}
+
+	return nil
+}
+
+func (server *SignerServer) handle(connection *net.UnixConn) {
+	defer connection.Close()
+
+	peer, err := peerCredentials(connection)
+	if err == nil {
+		err = server.authorize(peer)
+	}
+
+	for {
+		connection.SetDeadline(time.Now().Add(signerTimeout))
+
+		request, readError := readSignerFrame(connection)
+		if readError != nil {
+			return
+		}
+
+		var response []byte
+		if err != nil {
+			response = append([]byte{signerError}, err.Error()...)
+		} else {
+			response = server.dispatch(request)
+		}
+
+		if writeSignerFrame(connection, response) != nil {
+			return
+		}
+	}
+}
+
+func (server *SignerServer) dispatch(request []byte) []byte {
+	response, err := server.call(request)
+	if err != nil {
+		return append([]byte{signerError}, err.Error()...)
+	}
+
+	return append([]byte{signerOK}, response...)
+}
+
+func (server *SignerServer) call(request []byte) ([]byte, error) {
+	if len(request) < 1 {
+		return nil, ErrorInvalidEncoding
+	}
+
+	switch request[0] {
+	case signerBegin:
+		info, err := decodeSignerInfo(request[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		session, msg, err := server.Backend.Begin(info)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := msg.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+
+		return append(session, data...), nil
+
+	case signerRespond:
+		if len(request) < 1+sessionIDSize {
+			return nil, ErrorInvalidEncoding
+		}
+
+		var msg Message2
+		if err := msg.UnmarshalBinary(request[1+sessionIDSize:]); err != nil {
+			return nil, err
+		}
+
+		result, err := server.Backend.Respond(request[1:1+sessionIDSize], msg)
+		if err != nil {
+			return nil, err
+		}
+
+		return result.MarshalBinary()
+	}
+
+	return nil, ErrorInvalidEncoding
+}
+
+func (backend SocketSigner) Begin(info Info) ([]byte, Message1, error) {
+	entry, err := lookupCurve(info.Curve)
+	if err != nil {
+		return nil, Message1{}, err
+	}
+
+	request, err := appendPoint([]byte{signerBegin, entry.ID}, info.Curve, info.X, info.Y)
+	if err != nil {
+		return nil, Message1{}, err
+	}
+
+	response, err := backend.call(request)
+	if err != nil {
+		return nil, Message1{}, err
+	}
+
+	if len(response) < sessionIDSize {
+		return nil, Message1{}, ErrorInvalidEncoding
+	}
+
+	var msg Message1
+	if err = msg.UnmarshalBinary(response[sessionIDSize:]); err != nil {
+		return nil, Message1{}, err
+	}
+
+	return response[:sessionIDSize], msg, nil
+}
+
+func (backend SocketSigner) Respond(session []byte, msg Message2) (Message3, error) {
+	if len(session) != sessionIDSize {
+		return Message3{}, ErrorUnknownSession
+	}
+
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return Message3{}, err
+	}
+
+	request := append([]byte{signerRespond}, session...)
+	response, err := backend.call(append(request, data...))
+	if err != nil {
+		return Message3{}, err
+	}
+
+	var result Message3
+	if err = result.UnmarshalBinary(response); err != nil {
+		return Message3{}, err
+	}
+
+	return result, nil
+}
+
+func (backend SocketSigner) call(request []byte) ([]byte, error) {
+	connection, err := net.DialTimeout("unix", backend.Path, signerTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+
+	connection.SetDeadline(time.Now().Add(signerTimeout))
+
+	if err = writeSignerFrame(connection, request); err != nil {
+		return nil, err
+	}
+
+	response, err := readSignerFrame(connection)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(response) < 1 {
+		return nil, ErrorInvalidEncoding
+	}
+
+	switch response[0] {
+	case signerOK:
+		return response[1:], nil
+	case signerError:
+		message := string(response[1:])
+		for _, known := range signerErrors {
+			if known.Error() == message {
+				return nil, known
+			}
+		}
+		return nil, errors.New(message)
+	}
+
+	return nil, ErrorInvalidEncoding
+}
+
+func decodeSignerInfo(data []byte) (Info, error) {
+	if len(data) < 1 {
+		return Info{}, ErrorInvalidEncoding
+	}
+
+	entry, err := lookupCurveID(data[0])
+	if err != nil {
+		return Info{}, err
+	}
+
+	if len(data) != 2+fieldSize(entry.Curve) {
+		return Info{}, ErrorInvalidEncoding
+	}
+
+	x, y, err := unmarshalCompressed(entry.Curve, data[1:])
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Info{Curve: entry.Curve, X: x, Y: y}, nil
+}
+
+func readSignerFrame(reader io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(reader, length[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(length[:])
+	if size > maxSignerFrame {
+		return nil, ErrorInvalidEncoding
+	}
+
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+func writeSignerFrame(writer io.Writer, frame []byte) error {
+	if len(frame) > maxSignerFrame {
+		return ErrorInvalidEncoding
+	}
+
+	_, err := writer.Write(appendLengthPrefixed(nil, frame))
+	return err
+}
@@ -0,0 +1,582 @@
+package signing
+
+// Key transparency log.
+//
+// The signer appends every key it publishes to an append-only Merkle tree
+// log as in RFC 6962, and the log signs tree heads with its own Ed25519 key.
+// Before trusting a key, requesters and verifiers ask for a proof that it is
+// included in a signed tree head and a proof that the tree head extends the
+// last one they saw, so the log cannot show different users different
+// histories without signing both. Auditors fetch all entries and recompute
+// the tree.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	logEntriesFile   = "entries"
+	logSecretFile    = "log.secret"
+	logPublicFile    = "log.public"
+	logMaxEntriesGet = 1000
+)
+
+// TreeHead is a signed commitment to the first Size entries of a log.
+type TreeHead struct {
+	Size      uint64
+	Timestamp time.Time
+	RootHash  []byte
+	Signature []byte
+}
+
+type TransparencyLog struct {
+	Now func() time.Time // time.Now if nil
+
+	mutex   sync.RWMutex
+	key     ed25519.PrivateKey
+	dir     string
+	entries [][]byte
+	leaves  [][]byte
+	index   map[string]uint64 // by leaf hash
+}
+
+// LogClient checks keys against a remote log. Head is the last tree head
+// it verified, which later tree heads must be consistent with.
+type LogClient struct {
+	URL  string
+	Key  ed25519.PublicKey
+	Head *TreeHead
+}
+
+type jsonTreeHead struct {
+	Size      uint64 `json:"tree_size"`
+	Timestamp int64  `json:"timestamp"`
+	RootHash  string `json:"root_hash"`
+	Signature string `json:"signature"`
+}
+
+type jsonInclusionProof struct {
+	Index uint64   `json:"leaf_index"`
+	Proof []string `json:"audit_path"`
+}
+
+type jsonConsistencyProof struct {
+	Proof []string `json:"consistency"`
+}
+
+type jsonEntries struct {
+	Entries []string `json:"entries"`
+}
+
+func (head TreeHead) signingInput() []byte {
+	input := appendLengthPrefixed(nil, []byte("pblind tree head"))
+
+	var numbers [16]byte
+	binary.BigEndian.PutUint64(numbers[:8], head.Size)
+	binary.BigEndian.PutUint64(numbers[8:], uint64(head.Timestamp.UnixNano()/int64(time.Millisecond)))
+
+	input = append(input, numbers[:]...)
+	return append(input, head.RootHash...)
+}
+
+func (head TreeHead) Verify(key ed25519.PublicKey) error {
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, head.signingInput(), head.Signature) {
+		return ErrorInvalidSignature
+	}
+	return nil
+}
+
+func (head TreeHead) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonTreeHead{
+		Size:      head.Size,
+		Timestamp: head.Timestamp.UnixNano() / int64(time.Millisecond),
+		RootHash:  jsonEncoding.EncodeToString(head.RootHash),
+		Signature: jsonEncoding.EncodeToString(head.Signature),
+	})
+}
+
+func (head *TreeHead) UnmarshalJSON(data []byte) error {
+	var encoded jsonTreeHead
+	if err := decodeJSONStrict(data, &encoded); err != nil {
+		return err
+	}
+
+	root, rootError := jsonEncoding.DecodeString(encoded.RootHash)
+	signature, signatureError := jsonEncoding.DecodeString(encoded.Signature)
+	if rootError != nil || signatureError != nil || len(root) != 32 {
+		return ErrorInvalidEncoding
+	}
+
+	*head = TreeHead{
+		Size:      encoded.Size,
+		Timestamp: time.Unix(0, encoded.Timestamp*int64(time.Millisecond)).UTC(),
+		RootHash:  root,
+		Signature: signature,
+	}
+
+	return nil
+}
+
+// NewTransparencyLog returns an empty log kept in memory.
+func NewTransparencyLog(key ed25519.PrivateKey) *TransparencyLog {
+	return &TransparencyLog{key: key, index: make(map[string]uint64)}
+}
+
+// OpenTransparencyLog opens the log kept in dir, creating it and its
+// signing key if it does not exist yet.
+func OpenTransparencyLog(dir string, passphrase PassphraseFunc) (*TransparencyLog, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	secretFile := filepath.Join(dir, logSecretFile)
+
+	var key ed25519.PrivateKey
+	var err error
+
+	if _, statError := os.Stat(secretFile); os.IsNotExist(statError) {
+		if key, err = NewRootKey(); err != nil {
+			return nil, err
+		}
+
+		var secret []byte
+		if passphrase != nil {
+			if secret, err = passphrase(); err != nil {
+				return nil, err
+			}
+		}
+
+		if err = SaveRootKey(secretFile, key, secret); err != nil {
+			return nil, err
+		}
+
+		if err = SaveRootPublicKey(filepath.Join(dir, logPublicFile), key.Public().(ed25519.PublicKey)); err != nil {
+			return nil, err
+		}
+	} else if key, err = LoadRootKey(secretFile, passphrase); err != nil {
+		return nil, err
+	}
+
+	log := NewTransparencyLog(key)
+	log.dir = dir
+
+	file, err := os.Open(filepath.Join(dir, logEntriesFile))
+	if os.IsNotExist(err) {
+		return log, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		entry, err := jsonEncoding.DecodeString(scanner.Text())
+		if err != nil {
+			return nil, ErrorInvalidEncoding
+		}
+		log.add(entry)
+	}
+
+	return log, scanner.Err()
+}
+
+func (log *TransparencyLog) now() time.Time {
+	if log.Now != nil {
+		return log.Now()
+	}
+	return time.Now()
+}
+
+func (log *TransparencyLog) PublicKey() ed25519.PublicKey {
+	return log.key.Public().(ed25519.PublicKey)
+}
+
+func (log *TransparencyLog) add(entry []byte) uint64 {
+	leaf := merkleLeafHash(entry)
+	index := uint64(len(log.leaves))
+
+	log.entries = append(log.entries, entry)
+	log.leaves = append(log.leaves, leaf)
+	log.index[string(leaf)] = index
+
+	return index
+}
+
+// Append adds entry to the log unless it is already there and returns its
+// index.
+func (log *TransparencyLog) Append(entry []byte) (uint64, error) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	if index, ok := log.index[string(merkleLeafHash(entry))]; ok {
+		return index, nil
+	}
+
+	if log.dir != "" {
+		file, err := os.OpenFile(filepath.Join(log.dir, logEntriesFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return 0, err
+		}
+
+		_, writeError := fmt.Fprintln(file, jsonEncoding.EncodeToString(entry))
+		syncError := file.Sync()
+		closeError := file.Close()
+
+		for _, err = range []error{writeError, syncError, closeError} {
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return log.add(entry), nil
+}
+
+// AppendKey logs the binary encoding of pk.
+func (log *TransparencyLog) AppendKey(pk *PublicKey) (uint64, error) {
+	data, err := pk.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	return log.Append(data)
+}
+
+func (log *TransparencyLog) Size() uint64 {
+	log.mutex.RLock()
+	defer log.mutex.RUnlock()
+
+	return uint64(len(log.leaves))
+}
+
+// TreeHead signs the current state of the log.
+func (log *TransparencyLog) TreeHead() (TreeHead, error) {
+	log.mutex.RLock()
+	head := TreeHead{
+		Size:      uint64(len(log.leaves)),
+		Timestamp: log.now().Truncate(time.Millisecond).UTC(),
+		RootHash:  merkleRoot(log.leaves),
+	}
+	log.mutex.RUnlock()
+
+	head.Signature = ed25519.Sign(log.key, head.signingInput())
+
+	return head, nil
+}
+
+// InclusionProof proves that the entry with leafHash is in the tree of the
+// given size.
+func (log *TransparencyLog) InclusionProof(leafHash []byte, size uint64) (uint64, [][]byte, error) {
+	log.mutex.RLock()
+	defer log.mutex.RUnlock()
+
+	index, ok := log.index[string(leafHash)]
+	if !ok || index >= size || size > uint64(len(log.leaves)) {
+		return 0, nil, ErrorNotInLog
+	}
+
+	return index, merkleInclusionProof(index, log.leaves[:size]), nil
+}
+
+// ConsistencyProof proves that the tree of size first is a prefix of the
+// tree of size second.
+func (log *TransparencyLog) ConsistencyProof(first uint64, second uint64) ([][]byte, error) {
+	log.mutex.RLock()
+	defer log.mutex.RUnlock()
+
+	if first > second || second > uint64(len(log.leaves)) {
+		return nil, ErrorInvalidProof
+	}
+
+	if first == 0 {
+		return nil, nil
+	}
+
+	return merkleConsistencyProof(first, log.leaves[:second], true), nil
+}
+
+// Entries returns the entries from start up to, not including, end.
+func (log *TransparencyLog) Entries(start uint64, end uint64) ([][]byte, error) {
+	log.mutex.RLock()
+	defer log.mutex.RUnlock()
+
+	if start > end || end > uint64(len(log.entries)) {
+		return nil, ErrorNotInLog
+	}
+
+	return log.entries[start:end], nil
+}
+
+// ServeHTTP serves the log API:
+//
+//	GET /sth
+//	GET /proof/inclusion?hash=<leaf hash>&size=<tree size>
+//	GET /proof/consistency?first=<tree size>&second=<tree size>
+//	GET /entries?start=<index>&end=<index>
+//
+// with hashes and entries in unpadded base64url.
+func (log *TransparencyLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	number := func(name string) (uint64, bool) {
+		value, err := strconv.ParseUint(query.Get(name), 10, 64)
+		return value, err == nil
+	}
+
+	var response interface{}
+
+	switch r.URL.Path {
+	case "/sth":
+		head, err := log.TreeHead()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response = head
+
+	case "/proof/inclusion":
+		hash, err := jsonEncoding.DecodeString(query.Get("hash"))
+		size, ok := number("size")
+		if err != nil || !ok {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		index, proof, err := log.InclusionProof(hash, size)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		response = jsonInclusionProof{Index: index, Proof: encodeHashes(proof)}
+
+	case "/proof/consistency":
+		first, firstOk := number("first")
+		second, secondOk := number("second")
+		if !firstOk || !secondOk {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		proof, err := log.ConsistencyProof(first, second)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response = jsonConsistencyProof{Proof: encodeHashes(proof)}
+
+	case "/entries":
+		start, startOk := number("start")
+		end, endOk := number("end")
+		if !startOk || !endOk || end-start > logMaxEntriesGet {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		entries, err := log.Entries(start, end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response = jsonEntries{Entries: encodeHashes(entries)}
+
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func encodeHashes(hashes [][]byte) []string {
+	encoded := make([]string, len(hashes))
+	for index, hash := range hashes {
+		encoded[index] = jsonEncoding.EncodeToString(hash)
+	}
+	return encoded
+}
+
+func decodeHashes(encoded []string) ([][]byte, error) {
+	hashes := make([][]byte, len(encoded))
+	for index, value := range encoded {
+		hash, err := jsonEncoding.DecodeString(value)
+		if err != nil {
+			return nil, ErrorInvalidEncoding
+		}
+		hashes[index] = hash
+	}
+	return hashes, nil
+}
+
+func (client *LogClient) get(path string, value interface{}) error {
+	data, err := Fetch(client.URL + path)
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(data, value); err != nil {
+		return ErrorInvalidEncoding
+	}
+
+	return nil
+}
+
+// Update fetches the current tree head and checks its signature and that
+// it is consistent with Head, which it then replaces.
+func (client *LogClient) Update() (TreeHead, error) {
+	var head TreeHead
+	if err := client.get("/sth", &head); err != nil {
+		return TreeHead{}, err
+	}
+
+	if err := head.Verify(client.Key); err != nil {
+		return TreeHead{}, err
+	}
+
+	if client.Head != nil {
+		if client.Head.Size > head.Size {
+			return TreeHead{}, ErrorInvalidProof
+		}
+
+		var encoded jsonConsistencyProof
+		path := fmt.Sprintf("/proof/consistency?first=%d&second=%d", client.Head.Size, head.Size)
+		if err := client.get(path, &encoded); err != nil {
+			return TreeHead{}, err
+		}
+
+		proof, err := decodeHashes(encoded.Proof)
+		if err != nil {
+			return TreeHead{}, err
+		}
+
+		if err = VerifyConsistency(client.Head.Size, head.Size, client.Head.RootHash, head.RootHash, proof); err != nil {
+			return TreeHead{}, err
+		}
+	}
+
+	client.Head = &head
+
+	return head, nil
+}
+
+// Check makes sure pk is in the log, as of a tree head consistent with all
+// tree heads the client saw before.
+func (client *LogClient) Check(pk *PublicKey) error {
+	head, err := client.Update()
+	if err != nil {
+		return err
+	}
+
+	data, err := pk.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	leaf := merkleLeafHash(data)
+
+	var encoded jsonInclusionProof
+	path := fmt.Sprintf("/proof/inclusion?hash=%s&size=%d", jsonEncoding.EncodeToString(leaf), head.Size)
+	if err = client.get(path, &encoded); err != nil {
+		return ErrorNotInLog
+	}
+
+	proof, err := decodeHashes(encoded.Proof)
+	if err != nil {
+		return err
+	}
+
+	return VerifyInclusion(leaf, encoded.Index, head.Size, head.RootHash, proof)
+}
+
+// LoadTreeHead reads a tree head saved by SaveTreeHead.
+func LoadTreeHead(filename string) (*TreeHead, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var head TreeHead
+	if err = json.Unmarshal(data, &head); err != nil {
+		return nil, err
+	}
+
+	return &head, nil
+}
+
+// SaveTreeHead writes the tree head a client pins atomically, so a crash
+// cannot leave a torn head that fails every later consistency check.
+func SaveTreeHead(filename string, head *TreeHead) error {
+	data, err := json.Marshal(head)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, data, 0600)
+}
+
+// CreateRequester is CreateRequester for a key that is in the log.
+func (client *LogClient) CreateRequester(pk *PublicKey, info Info, message []byte) (*StateRequester, error) {
+	if err := client.Check(pk); err != nil {
+		return nil, err
+	}
+
+	return CreateRequester(pk, info, message)
+}
+
+// Audit fetches every entry of the log and checks that they hash to the
+// root of the current tree head.
+func (client *LogClient) Audit() ([][]byte, error) {
+	head, err := client.Update()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries [][]byte
+	leaves := make([][]byte, 0, head.Size)
+
+	for start := uint64(0); start < head.Size; start += logMaxEntriesGet {
+		end := start + logMaxEntriesGet
+		if end > head.Size {
+			end = head.Size
+		}
+
+		var encoded jsonEntries
+		if err = client.get(fmt.Sprintf("/entries?start=%d&end=%d", start, end), &encoded); err != nil {
+			return nil, err
+		}
+
+		batch, err := decodeHashes(encoded.Entries)
+		if err != nil || uint64(len(batch)) != end-start {
+			return nil, ErrorInvalidEncoding
+		}
+
+		for _, entry := range batch {
+			entries = append(entries, entry)
+			leaves = append(leaves, merkleLeafHash(entry))
+		}
+	}
+
+	if !bytes.Equal(merkleRoot(leaves), head.RootHash) {
+		return nil, ErrorInvalidProof
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,187 @@
+package signing
+
+import (
+	"crypto/elliptic"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMerkleProofs(t *testing.T) {
+	var leaves [][]byte
+	for i := 0; i < 20; i++ {
+		leaves = append(leaves, merkleLeafHash([]byte(fmt.Sprint(i))))
+	}
+
+	for size := uint64(1); size <= uint64(len(leaves)); size++ {
+		root := merkleRoot(leaves[:size])
+
+		for index := uint64(0); index < size; index++ {
+			proof := merkleInclusionProof(index, leaves[:size])
+			if err := VerifyInclusion(leaves[index], index, size, root, proof); err != nil {
+				t.Fatal("inclusion proof failed for", index, "in", size)
+			}
+			if VerifyInclusion(leaves[(index+1)%20], index, size, root, proof) == nil {
+				t.Fatal("inclusion proof accepted for wrong leaf")
+			}
+		}
+
+		for first := uint64(1); first <= size; first++ {
+			proof := merkleConsistencyProof(first, leaves[:size], true)
+			if err := VerifyConsistency(first, size, merkleRoot(leaves[:first]), root, proof); err != nil {
+				t.Fatal("consistency proof failed for", first, "to", size)
+			}
+			if first < size && VerifyConsistency(first, size, merkleRoot(leaves[1:first+1]), root, proof) == nil {
+				t.Fatal("consistency proof accepted for rewritten history")
+			}
+		}
+	}
+}
+
+func TestTransparencyLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	passphrase := func() ([]byte, error) { return []byte("passphrase"), nil }
+
+	log, err := OpenTransparencyLog(dir, passphrase)
+	if err != nil {
+		t.Fatal("failed to create log:", err)
+	}
+
+	var keys []*PublicKey
+	for i := 0; i < 3; i++ {
+		sk, _ := NewSecretKey(elliptic.P256())
+		keys = append(keys, sk.GetPublicKey())
+		if _, err = log.AppendKey(keys[i]); err != nil {
+			t.Fatal("failed to append key:", err)
+		}
+	}
+
+	// entries survive reopening and appends are idempotent
+
+	log, err = OpenTransparencyLog(dir, passphrase)
+	if err != nil {
+		t.Fatal("failed to reopen log:", err)
+	}
+	if index, _ := log.AppendKey(keys[1]); index != 1 || log.Size() != 3 {
+		t.Error("log not restored")
+	}
+
+	server := httptest.NewServer(log)
+	defer server.Close()
+
+	client := LogClient{URL: server.URL, Key: log.PublicKey()}
+
+	if err = client.Check(keys[2]); err != nil {
+		t.Error("key in log rejected:", err)
+	}
+
+	sk, _ := NewSecretKey(elliptic.P256())
+	if err = client.Check(sk.GetPublicKey()); err != ErrorNotInLog {
+		t.Error("key not in log accepted:", err)
+	}
+
+	log.AppendKey(sk.GetPublicKey())
+	if err = client.Check(sk.GetPublicKey()); err != nil || client.Head.Size != 4 {
+		t.Error("appended key rejected:", err)
+	}
+
+	entries, err := client.Audit()
+	if err != nil || len(entries) != 4 {
+		t.Error("audit failed:", err)
+	}
+
+	// a log with another history under the same key is detected
+
+	forked := NewTransparencyLog(log.key)
+	for _, pk := range []*PublicKey{keys[0], keys[2], keys[1], sk.GetPublicKey(), keys[0]} {
+		forked.AppendKey(pk)
+	}
+	forked.Append([]byte("extra"))
+
+	forkServer := httptest.NewServer(forked)
+	defer forkServer.Close()
+
+	client.URL = forkServer.URL
+	if _, err = client.Update(); err != ErrorInvalidProof {
+		t.Error("inconsistent tree head accepted:", err)
+	}
+}
+
+func TestKeyringLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pblind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	passphrase := func() ([]byte, error) { return []byte("passphrase"), nil }
+
+	log, err := OpenTransparencyLog(filepath.Join(dir, "log"), passphrase)
+	if err != nil {
+		t.Fatal("failed to create log:", err)
+	}
+
+	logged, _ := NewSecretKey(elliptic.P256())
+	unlogged, _ := NewSecretKey(elliptic.P256())
+	log.AppendKey(logged.GetPublicKey())
+
+	server := httptest.NewServer(log)
+	defer server.Close()
+
+	info, _ := CompressInfo(elliptic.P256(), []byte("info"))
+	message := []byte("message")
+
+	ring := NewKeyring()
+	ring.Add(logged.GetPublicKey(), time.Time{}, time.Time{})
+	ring.Add(unlogged.GetPublicKey(), time.Time{}, time.Time{})
+	ring.Log = &LogClient{URL: server.URL, Key: log.PublicKey()}
+
+	if err = ring.Check(issueToken(t, logged, info, message), info, message); err != nil {
+		t.Error("key in log rejected:", err)
+	}
+
+	if err = ring.Check(issueToken(t, unlogged, info, message), info, message); err != ErrorNotInLog {
+		t.Error("key not in log accepted:", err)
+	}
+
+	// the pinned tree head survives restarts and must be extended
+
+	headFile := filepath.Join(dir, "treehead.json")
+	if err = SaveTreeHead(headFile, ring.Log.Head); err != nil {
+		t.Fatal("failed to save tree head:", err)
+	}
+
+	stat, err := os.Stat(headFile)
+	if err != nil || stat.Mode().Perm() != 0600 {
+		t.Error("tree head file not private:", err)
+	}
+
+	head, err := LoadTreeHead(headFile)
+	if err != nil {
+		t.Fatal("failed to load tree head:", err)
+	}
+
+	forked := NewTransparencyLog(log.key)
+	forked.Append([]byte("other history"))
+	forked.AppendKey(unlogged.GetPublicKey())
+
+	forkServer := httptest.NewServer(forked)
+	defer forkServer.Close()
+
+	forkedRing := NewKeyring()
+	forkedRing.Add(unlogged.GetPublicKey(), time.Time{}, time.Time{})
+	forkedRing.Log = &LogClient{URL: forkServer.URL, Key: log.PublicKey(), Head: head}
+
+	if err = forkedRing.Check(issueToken(t, unlogged, info, message), info, message); err != ErrorInvalidProof {
+		t.Error("key from inconsistent log accepted:", err)
+	}
+}
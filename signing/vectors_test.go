@@ -0,0 +1,331 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var updateVectors = flag.Bool("update", false, "rewrite testdata/vectors.json")
+
+const vectorsFile = "vectors.json"
+
+// testVector is one issuance with every intermediate value. Scalars are
+// fixed-width and points SEC1 compressed, both in hex.
+type testVector struct {
+	Curve     string `json:"curve"`
+	Info      string `json:"info"`
+	Message   string `json:"message"`
+	Sk        string `json:"sk"`
+	Pk        string `json:"pk"`
+	InfoPoint string `json:"info_point"`
+	U         string `json:"u"`
+	T1        string `json:"t1"`
+	T2        string `json:"t2"`
+	T3        string `json:"t3"`
+	T4        string `json:"t4"`
+	A         string `json:"a"`
+	B         string `json:"b"`
+	E         string `json:"e"`
+	R         string `json:"r"`
+	C         string `json:"c"`
+	S         string `json:"s"`
+	D         string `json:"d"`
+	Signature struct {
+		P string `json:"p"`
+		W string `json:"w"`
+		O string `json:"o"`
+		G string `json:"g"`
+	} `json:"signature"`
+}
+
+// vectorRandom is the deterministic randomness of one role in the vectors.
+func vectorRandom(curve string, role string) io.Reader {
+	return hkdf.New(sha256.New, []byte("pblind test vectors"), nil, []byte(curve+"/"+role))
+}
+
+// generateVectors runs one issuance per curve with deterministic randomness.
+func generateVectors(t *testing.T) []testVector {
+	var vectors []testVector
+
+	for _, curve := range testCurves {
+		name, _ := curveName(curve)
+		scalar := func(x *big.Int) string {
+			buff := make([]byte, scalarSize(curve))
+			putFixed(buff, x)
+			return hex.EncodeToString(buff)
+		}
+		point := func(x, y *big.Int) string {
+			return hex.EncodeToString(marshalCompressed(curve, x, y))
+		}
+
+		vector := testVector{Curve: name, Info: "test vector info", Message: hex.EncodeToString([]byte("test vector message"))}
+		message, _ := hex.DecodeString(vector.Message)
+
+		sk, err := NewSecretKey(curve, WithRandom(vectorRandom(name, "key")))
+		if err != nil {
+			t.Fatal("failed to create key:", err)
+		}
+		pk := sk.GetPublicKey()
+		info, _ := CompressInfo(curve, []byte(vector.Info))
+
+		signer, err := CreateSigner(*sk, info, WithRandom(vectorRandom(name, "signer")))
+		if err != nil {
+			t.Fatal("failed to create signer:", err)
+		}
+		requester, err := CreateRequester(pk, info, message, WithRandom(vectorRandom(name, "requester")))
+		if err != nil {
+			t.Fatal("failed to create requester:", err)
+		}
+
+		vector.Sk, vector.Pk, vector.InfoPoint = scalar(sk.Scalar), point(pk.X, pk.Y), point(info.X, info.Y)
+		vector.U = scalar(signer.U)
+		vector.T1, vector.T2, vector.T3, vector.T4 = scalar(requester.T1), scalar(requester.T2), scalar(requester.T3), scalar(requester.T4)
+
+		msg1, _ := signer.CreateMessage1()
+		if err = requester.ProcessMessage1(msg1); err != nil {
+			t.Fatal("failed to process msg1:", err)
+		}
+		msg2, _ := requester.CreateMessage2()
+		if err = signer.ProcessMessage2(msg2); err != nil {
+			t.Fatal("failed to process msg2:", err)
+		}
+		msg3, _ := signer.CreateMessage3()
+		if err = requester.ProcessMessage3(msg3); err != nil {
+			t.Fatal("failed to process msg3:", err)
+		}
+		sig, _ := requester.Signature()
+
+		vector.A, vector.B = point(msg1.Ax, msg1.Ay), point(msg1.Bx, msg1.By)
+		vector.E = scalar(msg2.E)
+		vector.R, vector.C, vector.S, vector.D = scalar(msg3.R), scalar(msg3.C), scalar(msg3.S), scalar(signer.D)
+		vector.Signature.P, vector.Signature.W = scalar(sig.P), scalar(sig.W)
+		vector.Signature.O, vector.Signature.G = scalar(sig.O), scalar(sig.G)
+
+		vectors = append(vectors, vector)
+	}
+
+	return vectors
+}
+
+// recomputeVector computes every value of an issuance from the key, the
+// nonces u, s and d and the blinding factors of vector, using the protocol
+// equations on math/big and the curve directly rather than the signer and
+// requester code.
+func recomputeVector(t *testing.T, vector testVector) testVector {
+	curve, err := curveFromName(vector.Curve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := curve.Params().N
+	size := (order.BitLen() + 7) / 8
+
+	decode := func(value string) []byte {
+		data, err := hex.DecodeString(value)
+		if err != nil {
+			t.Fatal("invalid hex in", vector.Curve, "vector:", err)
+		}
+		return data
+	}
+	scalar := func(x *big.Int) string {
+		return fmt.Sprintf("%0*x", 2*size, new(big.Int).Mod(x, order))
+	}
+	point := func(x, y *big.Int) string {
+		return hex.EncodeToString(marshalCompressed(curve, x, y))
+	}
+
+	sk, u, s, d := decode(vector.Sk), decode(vector.U), decode(vector.S), decode(vector.D)
+	t1, t2, t3, t4 := decode(vector.T1), decode(vector.T2), decode(vector.T3), decode(vector.T4)
+	message := decode(vector.Message)
+	number := func(data []byte) *big.Int { return new(big.Int).SetBytes(data) }
+
+	recomputed := testVector{
+		Curve: vector.Curve, Info: vector.Info, Message: vector.Message,
+		Sk: vector.Sk, U: vector.U, S: vector.S, D: vector.D,
+		T1: vector.T1, T2: vector.T2, T3: vector.T3, T4: vector.T4,
+	}
+
+	// y = sk*g, z = H(info)
+
+	yx, yy := curve.ScalarBaseMult(sk)
+	info, _ := CompressInfo(curve, []byte(vector.Info))
+	recomputed.Pk, recomputed.InfoPoint = point(yx, yy), point(info.X, info.Y)
+
+	// a = u*g, b = s*g + d*z
+
+	ax, ay := curve.ScalarBaseMult(u)
+	sx, sy := curve.ScalarBaseMult(s)
+	dx, dy := curve.ScalarMult(info.X, info.Y, d)
+	bx, by := curve.Add(sx, sy, dx, dy)
+	recomputed.A, recomputed.B = point(ax, ay), point(bx, by)
+
+	// alpha = a + t1*g + t2*y, beta = b + t3*g + t4*z
+
+	add := func(x, y *big.Int, points ...*big.Int) (*big.Int, *big.Int) {
+		for index := 0; index < len(points); index += 2 {
+			x, y = curve.Add(x, y, points[index], points[index+1])
+		}
+		return x, y
+	}
+	t1x, t1y := curve.ScalarBaseMult(t1)
+	t2x, t2y := curve.ScalarMult(yx, yy, t2)
+	t3x, t3y := curve.ScalarBaseMult(t3)
+	t4x, t4y := curve.ScalarMult(info.X, info.Y, t4)
+	alphax, alphay := add(ax, ay, t1x, t1y, t2x, t2y)
+	betax, betay := add(bx, by, t3x, t3y, t4x, t4y)
+
+	// e = H(alpha, beta, z, message) - t2 - t4, the hash read from
+	// HKDF-SHA512 by rejection sampling with the top bits masked
+
+	hashed := elliptic.Marshal(curve, alphax, alphay)
+	hashed = append(hashed, elliptic.Marshal(curve, betax, betay)...)
+	hashed = append(hashed, elliptic.Marshal(curve, info.X, info.Y)...)
+	hashed = append(hashed, message...)
+
+	kdf := hkdf.New(sha512.New, hashed, []byte(curve.Params().Name), []byte("SCALAR-HASHING"))
+	buff := make([]byte, size)
+	h := new(big.Int)
+	for {
+		if _, err = io.ReadFull(kdf, buff); err != nil {
+			t.Fatal(err)
+		}
+		if excess := uint(size*8 - order.BitLen()); excess > 0 {
+			buff[0] &= byte(0xff >> excess)
+		}
+		if h.SetBytes(buff).Cmp(order) < 0 {
+			break
+		}
+	}
+
+	e := new(big.Int).Sub(h, number(t2))
+	e.Sub(e, number(t4))
+	recomputed.E = scalar(e)
+
+	// c = e - d, r = u - c*sk
+
+	c := new(big.Int).Sub(e, number(d))
+	r := new(big.Int).Mul(c, number(sk))
+	r.Sub(number(u), r)
+	recomputed.C, recomputed.R = scalar(c), scalar(r)
+
+	// rho = r + t1, omega = c + t2, sigma = s + t3, delta = d + t4
+
+	recomputed.Signature.P = scalar(new(big.Int).Add(r, number(t1)))
+	recomputed.Signature.W = scalar(new(big.Int).Add(c, number(t2)))
+	recomputed.Signature.O = scalar(new(big.Int).Add(number(s), number(t3)))
+	recomputed.Signature.G = scalar(new(big.Int).Add(number(d), number(t4)))
+
+	return recomputed
+}
+
+// vectorDifference names the first field, in the order of testVector, in
+// which got differs from want, or returns "" if there is none.
+func vectorDifference(want, got testVector) string {
+	var difference func(prefix string, want, got reflect.Value) string
+	difference = func(prefix string, want, got reflect.Value) string {
+		for index := 0; index < want.NumField(); index++ {
+			name := prefix + want.Type().Field(index).Tag.Get("json")
+
+			if want.Field(index).Kind() == reflect.Struct {
+				if name := difference(name+".", want.Field(index), got.Field(index)); name != "" {
+					return name
+				}
+			} else if want.Field(index).String() != got.Field(index).String() {
+				return name
+			}
+		}
+		return ""
+	}
+
+	return difference("", reflect.ValueOf(want), reflect.ValueOf(got))
+}
+
+func TestVectors(t *testing.T) {
+	filename := filepath.Join("testdata", vectorsFile)
+
+	vectors := generateVectors(t)
+	generated, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated = append(generated, '\n')
+
+	if *updateVectors {
+		if err = ioutil.WriteFile(filename, generated, 0644); err != nil {
+			t.Fatal("failed to write vectors:", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal("failed to read vectors, run with -update:", err)
+	}
+
+	var stored []testVector
+	if err = json.Unmarshal(data, &stored); err != nil {
+		t.Fatal("failed to decode vectors:", err)
+	}
+
+	if len(stored) != len(testCurves) {
+		t.Fatal("vectors for", len(stored), "curves")
+	}
+
+	if !bytes.Equal(data, generated) {
+		t.Error("issuance differs from", filename)
+		for index, vector := range stored {
+			if name := vectorDifference(vector, vectors[index]); name != "" {
+				t.Errorf("%s vector: %s differs", vector.Curve, name)
+			}
+		}
+	}
+
+	// every value follows from the inputs by the protocol equations, and
+	// the signatures verify on their own
+
+	for _, vector := range stored {
+		if name := vectorDifference(vector, recomputeVector(t, vector)); name != "" {
+			t.Errorf("%s vector: %s does not follow from the inputs", vector.Curve, name)
+		}
+
+		curve, _ := curveFromName(vector.Curve)
+		decode := func(value string) []byte {
+			data, _ := hex.DecodeString(value)
+			return data
+		}
+		scalar := func(value string) *big.Int {
+			return new(big.Int).SetBytes(decode(value))
+		}
+
+		x, y, err := unmarshalCompressed(curve, decode(vector.Pk))
+		if err != nil {
+			t.Fatal("invalid key in", vector.Curve, "vector:", err)
+		}
+		pk := PublicKey{Curve: curve, X: x, Y: y}
+
+		info, _ := CompressInfo(curve, []byte(vector.Info))
+		sig := Signature{
+			Curve: curve,
+			P:     scalar(vector.Signature.P),
+			W:     scalar(vector.Signature.W),
+			O:     scalar(vector.Signature.O),
+			G:     scalar(vector.Signature.G),
+		}
+
+		if !pk.Check(sig, info, decode(vector.Message)) {
+			t.Error("signature in", vector.Curve, "vector does not verify")
+		}
+	}
+}